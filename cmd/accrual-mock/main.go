@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/pkg/accrualmock"
+	"loyalty-system/pkg/logger"
+)
+
+func main() {
+	if err := run(); err != nil {
+		panic(err)
+	}
+}
+
+func run() error {
+	host := flag.String("a", "localhost:8080", "адрес и порт запуска имитации системы начислений")
+	logLevel := flag.String("l", "info", "log level")
+	statusDelay := flag.Duration("d", 2*time.Second, "сколько заказ остаётся в статусах REGISTERED и PROCESSING")
+	rateLimit := flag.Int("rl", 0, "допустимое количество запросов заказов в минуту; 0 - без ограничения")
+	retryAfter := flag.Int("ra", 0, "значение Retry-After в секундах при превышении; 0 - до конца минуты")
+	errorRate := flag.Float64("er", 0, "доля запросов заказов, на которые отвечать 500")
+	latency := flag.Duration("lt", 0, "задержка каждого ответа")
+	autoAccrual := flag.Float64("aa", 0, "начисление для незарегистрированных заказов; 0 - отвечать 204")
+	seed := flag.Int64("seed", 1, "начальное значение генератора ошибок")
+	rules := flag.String("g", "", "JSON-файл с массивом правил вознаграждения [{\"match\",\"reward\",\"reward_type\"}]")
+	flag.Parse()
+
+	if err := logger.Initialize(*logLevel); err != nil {
+		return fmt.Errorf("log initialize: %w", err)
+	}
+	mock := accrualmock.New(accrualmock.Config{
+		StatusDelay: *statusDelay,
+		RateLimit:   *rateLimit,
+		RetryAfter:  *retryAfter,
+		ErrorRate:   *errorRate,
+		Latency:     *latency,
+		AutoAccrual: *autoAccrual,
+		Seed:        *seed,
+	})
+	if *rules != "" {
+		if err := loadRules(mock, *rules); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	httpServer := &http.Server{Addr: *host, Handler: mock}
+	go func() {
+		<-ctx.Done()
+		httpServer.Shutdown(context.Background())
+	}()
+	logger.Log.Info("Starting accrual mock", zap.String("address", *host))
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func loadRules(mock *accrualmock.Server, path string) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read rules: %w", err)
+	}
+	rules := []accrualmock.Rule{}
+	if err = json.Unmarshal(body, &rules); err != nil {
+		return fmt.Errorf("parse rules: %w", err)
+	}
+	for _, rule := range rules {
+		if err = mock.AddRule(rule); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Match, err)
+		}
+	}
+	return nil
+}
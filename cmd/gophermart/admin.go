@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"loyalty-system/internal/config"
+	"loyalty-system/internal/domain"
+	"loyalty-system/internal/domain/actions"
+	"loyalty-system/internal/domain/dbstorage/migrations"
+	"loyalty-system/pkg/postgresql"
+)
+
+var errAdminUsage = errors.New("usage: gophermart [flags] admin create|grant|revoke <login>")
+
+// runAdmin назначает роли пользователям. Пароль для create читается из первой строки stdin
+func runAdmin(ctx context.Context, cfg *config.Config, args []string) error {
+	if len(args) != 2 {
+		return errAdminUsage
+	}
+	if cfg.InMemory() {
+		return errors.New("admin: memory storage is not shared with the running service")
+	}
+	// команда может выполняться до первого запуска сервиса, поэтому схема PostgreSQL создаётся здесь же
+	if cfg.Postgres() {
+		db, err := postgresql.NewConn(cfg.DSN)
+		if err != nil {
+			return err
+		}
+		_, err = migrations.Up(ctx, db)
+		db.Close()
+		if err != nil {
+			return fmt.Errorf("admin: migrate: %w", err)
+		}
+	}
+	storage, err := actions.OpenStorage(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+	users, err := actions.GetUserStorage(cfg, storage)
+	if err != nil {
+		return err
+	}
+	login := args[1]
+	switch args[0] {
+	case "create":
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		password = strings.TrimRight(password, "\r\n")
+		if password == "" {
+			return fmt.Errorf("admin create: read password from stdin: %w", err)
+		}
+		if err = users.CreateAdmin(ctx, login, password); err != nil {
+			return fmt.Errorf("admin create: %w", err)
+		}
+		fmt.Printf("created admin %s\n", login)
+	case "grant":
+		if err = users.SetRole(ctx, login, domain.RoleAdmin); err != nil {
+			return fmt.Errorf("admin grant: %w", err)
+		}
+		fmt.Printf("granted admin role to %s\n", login)
+	case "revoke":
+		if err = users.SetRole(ctx, login, domain.RoleUser); err != nil {
+			return fmt.Errorf("admin revoke: %w", err)
+		}
+		fmt.Printf("revoked admin role from %s\n", login)
+	default:
+		return errAdminUsage
+	}
+	return nil
+}
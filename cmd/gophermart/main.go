@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os/signal"
 	"syscall"
@@ -9,6 +10,7 @@ import (
 	"loyalty-system/internal/config"
 	"loyalty-system/internal/server"
 	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/tracing"
 )
 
 func main() {
@@ -27,6 +29,17 @@ func run() error {
 	}
 	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGABRT)
 	defer cancel()
+	shutdownTracing, err := tracing.Initialize(ctx, cfg.TraceExporter, cfg.TraceEndpoint)
+	if err != nil {
+		return fmt.Errorf("tracing initialize: %w", err)
+	}
+	defer shutdownTracing(context.Background())
+	if args := flag.Args(); len(args) > 0 && args[0] == "migrate" {
+		return runMigrate(ctx, cfg, args[1:])
+	}
+	if args := flag.Args(); len(args) > 0 && args[0] == "admin" {
+		return runAdmin(ctx, cfg, args[1:])
+	}
 	app, err := server.New(ctx, cfg)
 	if err != nil {
 		return err
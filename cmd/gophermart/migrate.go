@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"loyalty-system/internal/config"
+	"loyalty-system/internal/domain/dbstorage/migrations"
+	"loyalty-system/pkg/postgresql"
+)
+
+var errMigrateUsage = errors.New("usage: gophermart [flags] migrate up|down [steps]|status")
+
+func runMigrate(ctx context.Context, cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errMigrateUsage
+	}
+	if !cfg.Postgres() {
+		// схема SQLite применяется при запуске сервиса, а в памяти схемы нет
+		return errors.New("migrate: only PostgreSQL storage has migrations")
+	}
+	db, err := postgresql.NewConn(cfg.DSN)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	switch args[0] {
+	case "up":
+		applied, err := migrations.Up(ctx, db)
+		if err != nil {
+			return fmt.Errorf("migrate up: %w", err)
+		}
+		fmt.Printf("applied %d migration(s)\n", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errMigrateUsage
+			}
+		}
+		reverted, err := migrations.Down(ctx, db, steps)
+		if err != nil {
+			return fmt.Errorf("migrate down: %w", err)
+		}
+		fmt.Printf("reverted %d migration(s)\n", reverted)
+	case "status":
+		statuses, err := migrations.GetStatus(ctx, db)
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05 -0700")
+			}
+			fmt.Printf("%06d  %-40s %s\n", s.Version, s.Name, appliedAt)
+		}
+		if err != nil {
+			return fmt.Errorf("migrate status: %w", err)
+		}
+	default:
+		return errMigrateUsage
+	}
+	return nil
+}
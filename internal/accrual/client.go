@@ -0,0 +1,171 @@
+// Package accrual - клиент системы расчёта начислений. Запросы всех горутин проходят через общий ограничитель частоты,
+// который также выдерживает паузу из Retry-After, и через автомат отключения (circuit breaker), который после серии
+// ошибок 5xx перестаёт обращаться к системе на время OpenTimeout
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+var ErrNotRegistered = errors.New("order is not registered in accrual system")
+var ErrRateLimited = errors.New("accrual system rate limit exceeded")
+var ErrCircuitOpen = errors.New("accrual system circuit is open")
+var ErrUnexpectedStatus = errors.New("unexpected accrual system response")
+
+// RateLimitError - ответ 429; RetryAfter - пауза, которую попросила система
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrRateLimited, e.RetryAfter)
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// Client - клиент системы расчёта начислений
+type Client interface {
+	// GetOrder возвращает расчёт по заказу; ErrNotRegistered - заказ в системе не зарегистрирован,
+	// *RateLimitError - превышена частота запросов, ErrCircuitOpen - система недоступна и запрос не отправлялся
+	GetOrder(ctx context.Context, number string) (*domain.Accrual, error)
+	// Ping проверяет, что система отвечает на HTTP-запросы; код ответа не важен
+	Ping(ctx context.Context) error
+}
+
+type Config struct {
+	BaseURL string
+	// Timeout - время на один запрос
+	Timeout time.Duration
+	// RateLimit - сколько запросов в секунду отправляют все горутины вместе; 0 - без ограничения
+	RateLimit float64
+	// FailureThreshold - после скольких ошибок подряд запросы прекращаются; 0 - не прекращаются
+	FailureThreshold int
+	// OpenTimeout - через сколько после отключения отправляется пробный запрос
+	OpenTimeout time.Duration
+}
+
+// defaultRetryAfter - пауза при ответе 429 без понятного заголовка Retry-After
+const defaultRetryAfter = time.Minute
+
+type HTTPClient struct {
+	client  *resty.Client
+	timeout time.Duration
+	limiter *limiter
+	breaker *breaker
+}
+
+func New(cfg Config) *HTTPClient {
+	return &HTTPClient{
+		client: resty.New().
+			SetBaseURL(cfg.BaseURL).
+			OnBeforeRequest(tracing.InjectRequest),
+		timeout: cfg.Timeout,
+		limiter: newLimiter(cfg.RateLimit),
+		breaker: newBreaker(cfg.FailureThreshold, cfg.OpenTimeout),
+	}
+}
+
+func (c *HTTPClient) GetOrder(ctx context.Context, number string) (*domain.Accrual, error) {
+	ctx, span := tracing.Start(ctx, "accrual.GetOrder",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("order", number)))
+	defer span.End()
+	if err := c.limiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	if !c.breaker.allow() {
+		metrics.AccrualRequests.WithLabelValues("circuit_open").Inc()
+		span.SetStatus(codes.Error, ErrCircuitOpen.Error())
+		return nil, ErrCircuitOpen
+	}
+	callCtx := ctx
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	ret, err := c.client.
+		R().
+		SetContext(callCtx).
+		SetPathParam("number", number).
+		Get("/api/orders/{number}")
+	if err != nil {
+		// отмена запроса вызывающим не говорит о состоянии системы начислений
+		if ctx.Err() == nil {
+			c.breaker.failure()
+		} else {
+			c.breaker.cancel()
+		}
+		metrics.AccrualRequests.WithLabelValues("error").Inc()
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("send: %w", err)
+	}
+	metrics.AccrualRequests.WithLabelValues(strconv.Itoa(ret.StatusCode())).Inc()
+	span.SetAttributes(attribute.Int("http.response.status_code", ret.StatusCode()))
+	if ret.StatusCode() >= http.StatusInternalServerError {
+		c.breaker.failure()
+		span.SetStatus(codes.Error, ret.Status())
+		return nil, fmt.Errorf("get accrual: %v", ret.Status())
+	}
+	c.breaker.success()
+	switch ret.StatusCode() {
+	case http.StatusNoContent:
+		return nil, ErrNotRegistered
+	case http.StatusTooManyRequests:
+		retryAfter := parseRetryAfter(ret.Header().Get("Retry-After"))
+		c.limiter.block(retryAfter)
+		return nil, &RateLimitError{RetryAfter: retryAfter}
+	case http.StatusOK:
+		accrual := domain.Accrual{}
+		err = json.Unmarshal(ret.Body(), &accrual)
+		if err != nil {
+			return nil, fmt.Errorf("unmarshal json: %w", err)
+		}
+		if accrual.Sum == nil {
+			amount := domain.CustomMoney(0)
+			accrual.Sum = &amount
+		}
+		return &accrual, nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnexpectedStatus, ret.Status())
+}
+
+// parseRetryAfter разбирает Retry-After в секундах или в виде даты HTTP
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return defaultRetryAfter
+}
+
+func (c *HTTPClient) Ping(ctx context.Context) error {
+	if c.breaker.isOpen() {
+		return ErrCircuitOpen
+	}
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	_, err := c.client.R().SetContext(ctx).Head("/")
+	return err
+}
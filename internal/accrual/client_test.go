@@ -0,0 +1,336 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeAccrual - сервер системы начислений, ответ которого задаёт тест
+type fakeAccrual struct {
+	*httptest.Server
+	requests atomic.Int64
+	mu       sync.Mutex
+	handler  http.HandlerFunc
+}
+
+func newFakeAccrual(t *testing.T, handler http.HandlerFunc) *fakeAccrual {
+	t.Helper()
+	f := &fakeAccrual{handler: handler}
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.requests.Add(1)
+		f.mu.Lock()
+		h := f.handler
+		f.mu.Unlock()
+		h(w, r)
+	}))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeAccrual) setHandler(handler http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handler = handler
+}
+
+func status(code int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}
+}
+
+func TestGetOrderProcessed(t *testing.T) {
+	var path string
+	f := newFakeAccrual(t, func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"order":"12345678903","status":"PROCESSED","accrual":729.98}`))
+	})
+	c := New(Config{BaseURL: f.URL})
+	ret, err := c.GetOrder(context.Background(), "12345678903")
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if path != "/api/orders/12345678903" {
+		t.Errorf("path = %q, want /api/orders/12345678903", path)
+	}
+	if ret.Order != "12345678903" || ret.Status != "PROCESSED" || ret.Sum == nil || *ret.Sum != 72998 {
+		t.Errorf("GetOrder = %+v", ret)
+	}
+}
+
+func TestGetOrderWithoutAccrual(t *testing.T) {
+	f := newFakeAccrual(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"order":"1","status":"PROCESSING"}`))
+	})
+	ret, err := New(Config{BaseURL: f.URL}).GetOrder(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("GetOrder: %v", err)
+	}
+	if ret.Sum == nil || *ret.Sum != 0 {
+		t.Errorf("Sum = %v, want 0", ret.Sum)
+	}
+}
+
+func TestGetOrderNotRegistered(t *testing.T) {
+	f := newFakeAccrual(t, status(http.StatusNoContent))
+	_, err := New(Config{BaseURL: f.URL}).GetOrder(context.Background(), "1")
+	if !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("err = %v, want ErrNotRegistered", err)
+	}
+}
+
+func TestGetOrderUnexpectedStatus(t *testing.T) {
+	f := newFakeAccrual(t, status(http.StatusTeapot))
+	_, err := New(Config{BaseURL: f.URL}).GetOrder(context.Background(), "1")
+	if !errors.Is(err, ErrUnexpectedStatus) {
+		t.Fatalf("err = %v, want ErrUnexpectedStatus", err)
+	}
+}
+
+func TestGetOrderRateLimited(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		want       time.Duration
+	}{
+		{name: "seconds", retryAfter: "7", want: 7 * time.Second},
+		{name: "http date", retryAfter: time.Now().Add(90 * time.Second).UTC().Format(http.TimeFormat), want: 90 * time.Second},
+		{name: "date in the past", retryAfter: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), want: 0},
+		{name: "missing", retryAfter: "", want: defaultRetryAfter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeAccrual(t, func(w http.ResponseWriter, r *http.Request) {
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+			})
+			_, err := New(Config{BaseURL: f.URL}).GetOrder(context.Background(), "1")
+			if !errors.Is(err, ErrRateLimited) {
+				t.Fatalf("err = %v, want ErrRateLimited", err)
+			}
+			var rateLimit *RateLimitError
+			if !errors.As(err, &rateLimit) {
+				t.Fatalf("err = %T, want *RateLimitError", err)
+			}
+			// дата в заголовке округлена до секунды
+			if diff := rateLimit.RetryAfter - tt.want; diff < -time.Second || diff > time.Second {
+				t.Errorf("RetryAfter = %v, want %v", rateLimit.RetryAfter, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitBlocksOtherCalls(t *testing.T) {
+	var limited atomic.Bool
+	f := newFakeAccrual(t, func(w http.ResponseWriter, r *http.Request) {
+		if limited.CompareAndSwap(false, true) {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	c := New(Config{BaseURL: f.URL})
+	_, err := c.GetOrder(context.Background(), "1")
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetOrder(context.Background(), "1"); !errors.Is(err, ErrNotRegistered) {
+				t.Errorf("err = %v, want ErrNotRegistered", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 900*time.Millisecond {
+		t.Errorf("calls after 429 finished in %v, want at least Retry-After", elapsed)
+	}
+}
+
+func TestRateLimitAcrossGoroutines(t *testing.T) {
+	f := newFakeAccrual(t, status(http.StatusNoContent))
+	c := New(Config{BaseURL: f.URL, RateLimit: 20})
+	const calls = 6
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.GetOrder(context.Background(), "1")
+		}()
+	}
+	wg.Wait()
+	// первый запрос уходит сразу, остальные - через 50 мс друг за другом
+	if elapsed, want := time.Since(start), (calls-1)*50*time.Millisecond; elapsed < want-10*time.Millisecond {
+		t.Errorf("%d calls at 20 rps took %v, want at least %v", calls, elapsed, want)
+	}
+	if got := f.requests.Load(); got != calls {
+		t.Errorf("requests = %d, want %d", got, calls)
+	}
+}
+
+func TestLimiterBlockDelaysWaitingCalls(t *testing.T) {
+	l := newLimiter(10)
+	if err := l.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	done := make(chan time.Duration)
+	go func() {
+		l.wait(context.Background())
+		done <- time.Since(start)
+	}()
+	// горутина уже ждёт свой слот через 100 мс, когда приходит пауза 300 мс
+	time.Sleep(20 * time.Millisecond)
+	l.block(300 * time.Millisecond)
+	if elapsed := <-done; elapsed < 300*time.Millisecond {
+		t.Errorf("waiting call resumed after %v, want at least 300ms", elapsed)
+	}
+}
+
+func TestLimiterWaitCanceled(t *testing.T) {
+	l := newLimiter(0)
+	l.block(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	f := newFakeAccrual(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	})
+	c := New(Config{BaseURL: f.URL, Timeout: 50 * time.Millisecond})
+	start := time.Now()
+	_, err := c.GetOrder(context.Background(), "1")
+	if err == nil {
+		t.Fatal("GetOrder succeeded, want timeout")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("GetOrder took %v, want about 50ms", elapsed)
+	}
+}
+
+func TestBreakerOpensAfterServerErrors(t *testing.T) {
+	f := newFakeAccrual(t, status(http.StatusInternalServerError))
+	c := New(Config{BaseURL: f.URL, FailureThreshold: 3, OpenTimeout: 100 * time.Millisecond})
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetOrder(ctx, "1"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d: err = %v, want server error", i, err)
+		}
+	}
+	if _, err := c.GetOrder(ctx, "1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+	if err := c.Ping(ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Ping = %v, want ErrCircuitOpen", err)
+	}
+	if got := f.requests.Load(); got != 3 {
+		t.Errorf("requests = %d, want 3: open circuit must not reach the server", got)
+	}
+
+	// после OpenTimeout проходит один пробный запрос; пока он выполняется, остальные отклоняются
+	time.Sleep(120 * time.Millisecond)
+	release := make(chan struct{})
+	f.setHandler(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	})
+	probe := make(chan error)
+	go func() {
+		_, err := c.GetOrder(ctx, "1")
+		probe <- err
+	}()
+	for f.requests.Load() != 4 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.GetOrder(ctx, "1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second call in half-open state: err = %v, want ErrCircuitOpen", err)
+	}
+	close(release)
+	if err := <-probe; !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("probe: err = %v, want ErrNotRegistered", err)
+	}
+
+	// успешный пробный запрос закрывает автомат
+	for i := 0; i < 5; i++ {
+		if _, err := c.GetOrder(ctx, "1"); !errors.Is(err, ErrNotRegistered) {
+			t.Fatalf("after probe: err = %v, want ErrNotRegistered", err)
+		}
+	}
+}
+
+func TestBreakerReopensAfterFailedProbe(t *testing.T) {
+	f := newFakeAccrual(t, status(http.StatusBadGateway))
+	c := New(Config{BaseURL: f.URL, FailureThreshold: 2, OpenTimeout: 50 * time.Millisecond})
+	ctx := context.Background()
+	c.GetOrder(ctx, "1")
+	c.GetOrder(ctx, "1")
+	time.Sleep(70 * time.Millisecond)
+	if _, err := c.GetOrder(ctx, "1"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("probe: err = %v, want server error", err)
+	}
+	if _, err := c.GetOrder(ctx, "1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("after failed probe: err = %v, want ErrCircuitOpen", err)
+	}
+	if got := f.requests.Load(); got != 3 {
+		t.Errorf("requests = %d, want 3", got)
+	}
+}
+
+func TestBreakerIgnoresRateLimit(t *testing.T) {
+	f := newFakeAccrual(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	c := New(Config{BaseURL: f.URL, FailureThreshold: 1, OpenTimeout: time.Hour})
+	for i := 0; i < 3; i++ {
+		if _, err := c.GetOrder(context.Background(), "1"); !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("call %d: err = %v, want ErrRateLimited", i, err)
+		}
+	}
+}
+
+func TestBreakerReleasesCanceledProbe(t *testing.T) {
+	f := newFakeAccrual(t, status(http.StatusInternalServerError))
+	c := New(Config{BaseURL: f.URL, FailureThreshold: 1, OpenTimeout: 20 * time.Millisecond})
+	c.GetOrder(context.Background(), "1")
+	time.Sleep(30 * time.Millisecond)
+	f.setHandler(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := c.GetOrder(ctx, "1"); err == nil {
+		t.Fatal("canceled probe succeeded")
+	}
+	// отменённый вызывающим пробный запрос не говорит о состоянии системы и не занимает пробу навсегда
+	f.setHandler(status(http.StatusNoContent))
+	if _, err := c.GetOrder(context.Background(), "1"); !errors.Is(err, ErrNotRegistered) {
+		t.Fatalf("err = %v, want ErrNotRegistered", err)
+	}
+}
@@ -0,0 +1,79 @@
+package accrual
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter распределяет запросы всех горутин по времени: каждый следующий запрос получает слот не раньше,
+// чем через interval после предыдущего и не раньше окончания паузы из Retry-After
+type limiter struct {
+	mu           sync.Mutex
+	interval     time.Duration
+	next         time.Time
+	blockedUntil time.Time
+}
+
+func newLimiter(rps float64) *limiter {
+	l := &limiter{}
+	if rps > 0 {
+		l.interval = time.Duration(float64(time.Second) / rps)
+	}
+	return l
+}
+
+// wait ждёт слота для запроса; возвращает ошибку, если ctx отменён раньше. Пауза из Retry-After, полученная, пока
+// горутина ждала, переносит её слот за конец паузы
+func (l *limiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	at := l.reserve(time.Now())
+	l.mu.Unlock()
+	for {
+		if delay := time.Until(at); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+		l.mu.Lock()
+		now := time.Now()
+		if !l.blockedUntil.After(now) {
+			l.mu.Unlock()
+			return nil
+		}
+		at = l.reserve(now)
+		l.mu.Unlock()
+	}
+}
+
+// reserve занимает ближайший слот не раньше now; вызывается под l.mu
+func (l *limiter) reserve(now time.Time) time.Time {
+	at := now
+	if l.blockedUntil.After(at) {
+		at = l.blockedUntil
+	}
+	if l.interval > 0 {
+		if l.next.After(at) {
+			at = l.next
+		}
+		l.next = at.Add(l.interval)
+	}
+	return at
+}
+
+// block откладывает все запросы на d. Уже ожидающие слота горутины увидят паузу, когда проснутся, и подождут ещё
+func (l *limiter) block(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until := time.Now().Add(d)
+	if until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+	if l.interval > 0 && until.After(l.next) {
+		l.next = until
+	}
+}
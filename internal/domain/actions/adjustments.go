@@ -0,0 +1,73 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/retry"
+	"loyalty-system/pkg/security"
+	"loyalty-system/pkg/tracing"
+)
+
+var ErrAdjustmentAmount = errors.New("adjustment amount must not be zero")
+var ErrAdjustmentReason = errors.New("adjustment reason is required")
+
+// maxReasonLength ограничивает длину причины корректировки
+const maxReasonLength = 500
+
+// NewAdjustment проводит ручную корректировку баланса пользователя от имени оператора;
+// запись журнала adjustment.Audit сохраняется вместе с корректировкой
+func (o *TransactionRepo) NewAdjustment(ctx context.Context, adjustment domain.Adjustment) (*domain.Adjustment, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.NewAdjustment")
+	defer span.End()
+	if adjustment.Amount == 0 {
+		return nil, ErrAdjustmentAmount
+	}
+	adjustment.Reason = strings.TrimSpace(adjustment.Reason)
+	if adjustment.Reason == "" || len(adjustment.Reason) > maxReasonLength {
+		return nil, ErrAdjustmentReason
+	}
+	id, err := security.NewSecret(8)
+	if err != nil {
+		return nil, err
+	}
+	adjustment.Number = "ADJ-" + id
+	adjustment.ProcessedAt = domain.CustomTime(time.Now())
+	if adjustment.Amount > 0 {
+		adjustment.ExpiresAt = o.expiresAt()
+	}
+	err = retry.DoWithoutReturn(ctx, 3, o.transactionStorage.AddAdjustment, &adjustment, o.transactionStorage.IsRetryable)
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		return nil, ErrUserNotExists
+	case errors.Is(err, domain.ErrInsufficientFunds):
+		return nil, ErrInsufficientFounds
+	case err != nil:
+		return nil, fmt.Errorf("add adjustment: %w", err)
+	}
+	return &adjustment, nil
+}
+
+func (o *TransactionRepo) GetAllAdjustments(ctx context.Context, filter domain.ListFilter) (*[]domain.Adjustment, *domain.ListCursor, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.GetAllAdjustments")
+	defer span.End()
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit = limit + 1
+	}
+	ret, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetAllAdjustments, &filter, o.transactionStorage.IsRetryable)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ret == nil {
+		return nil, nil, ErrNotExists
+	}
+	adjustments, next := page(*ret, limit, func(v domain.Adjustment) domain.ListCursor {
+		return domain.ListCursor{At: time.Time(v.ProcessedAt), Number: v.Number}
+	})
+	return &adjustments, next, nil
+}
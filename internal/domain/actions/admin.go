@@ -0,0 +1,108 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/retry"
+	"loyalty-system/pkg/tracing"
+)
+
+var ErrOrderNotExists = errors.New("order not found")
+var ErrOrderProcessed = domain.ErrOrderProcessed
+
+func (u *UserStorage) GetUserInfo(ctx context.Context, userID int64) (*domain.UserInfo, error) {
+	ctx, span := tracing.Start(ctx, "UserStorage.GetUserInfo")
+	defer span.End()
+	user, err := retry.DoWithReturn(ctx, 3, u.users.GetUserInfo, &userID, u.IsRetryable)
+	if err != nil {
+		return nil, fmt.Errorf("get user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotExists
+	}
+	return user, nil
+}
+
+func (u *UserStorage) SearchUsers(ctx context.Context, login string, limit int) (*[]domain.UserInfo, error) {
+	ctx, span := tracing.Start(ctx, "UserStorage.SearchUsers")
+	defer span.End()
+	search := &domain.UserSearch{Login: login, Limit: limit}
+	ret, err := retry.DoWithReturn(ctx, 3, u.users.SearchUsers, search, u.IsRetryable)
+	if err != nil {
+		return nil, fmt.Errorf("search users: %w", err)
+	}
+	if ret == nil {
+		return nil, ErrNotExists
+	}
+	return ret, nil
+}
+
+// CreateAdmin создаёт администратора, в том числе с зарезервированным логином; вызывается оператором, а не через API
+func (u *UserStorage) CreateAdmin(ctx context.Context, login string, password string) error {
+	ctx, span := tracing.Start(ctx, "UserStorage.CreateAdmin")
+	defer span.End()
+	return u.addUser(ctx, login, password, domain.RoleAdmin)
+}
+
+// SetRole назначает пользователю роль; роль из БД попадает в токен при следующем входе и сверяется при каждом запросе
+func (u *UserStorage) SetRole(ctx context.Context, login string, role string) error {
+	ctx, span := tracing.Start(ctx, "UserStorage.SetRole")
+	defer span.End()
+	err := retry.DoWithoutReturn(ctx, 3, u.users.SetRole, &domain.UserRole{Login: login, Role: role}, u.IsRetryable)
+	if errors.Is(err, domain.ErrNotFound) {
+		return ErrUserNotExists
+	}
+	if err != nil {
+		return fmt.Errorf("set role: %w", err)
+	}
+	return nil
+}
+
+// SetLocked блокирует или разблокирует пользователя. Заблокированный пользователь не может войти,
+// а уже выданные ему токены перестают приниматься. Запись журнала audit сохраняется вместе с блокировкой
+func (u *UserStorage) SetLocked(ctx context.Context, userID int64, locked bool, audit *domain.AuditRecord) error {
+	ctx, span := tracing.Start(ctx, "UserStorage.SetLocked")
+	defer span.End()
+	lock := &domain.UserLock{UserID: userID, Locked: locked, Audit: audit}
+	err := retry.DoWithoutReturn(ctx, 3, u.users.SetLocked, lock, u.IsRetryable)
+	if errors.Is(err, domain.ErrNotFound) {
+		return ErrUserNotExists
+	}
+	return err
+}
+
+func (u *UserStorage) Audit(ctx context.Context, record *domain.AuditRecord) error {
+	return retry.DoWithoutReturn(ctx, 3, u.users.AddAudit, record, u.IsRetryable)
+}
+
+func (u *UserStorage) GetAudit(ctx context.Context, filter domain.AuditFilter) (*[]domain.AuditRecord, error) {
+	ctx, span := tracing.Start(ctx, "UserStorage.GetAudit")
+	defer span.End()
+	ret, err := retry.DoWithReturn(ctx, 3, u.users.GetAudit, &filter, u.IsRetryable)
+	if err != nil {
+		return nil, fmt.Errorf("get audit: %w", err)
+	}
+	if ret == nil {
+		return nil, ErrNotExists
+	}
+	return ret, nil
+}
+
+// ResetOrder возвращает заказ в статус NEW для повторного опроса системы начислений.
+// Запись журнала audit сохраняется вместе с возвратом
+func (o *TransactionRepo) ResetOrder(ctx context.Context, number string, audit *domain.AuditRecord) (*domain.OrderReset, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.ResetOrder")
+	defer span.End()
+	reset := &domain.OrderReset{Number: number, Audit: audit}
+	err := retry.DoWithoutReturn(ctx, 3, o.transactionStorage.ResetOrder, reset, o.transactionStorage.IsRetryable)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, ErrOrderNotExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reset, nil
+}
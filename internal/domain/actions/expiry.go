@@ -0,0 +1,54 @@
+package actions
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/retry"
+)
+
+// expiresAt возвращает срок действия баллов, начисляемых сейчас, или nil, если баллы не сгорают
+func (o *TransactionRepo) expiresAt() *time.Time {
+	if o.pointsTTL <= 0 {
+		return nil
+	}
+	t := time.Now().Add(o.pointsTTL)
+	return &t
+}
+
+// RunExpiry периодически списывает просроченные баллы пачками до batchLimit пользователей
+func (o *TransactionRepo) RunExpiry(ctx context.Context, batchLimit int, interval int) error {
+	ticker := time.NewTicker(time.Minute * time.Duration(interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// пока находятся просроченные баллы, продолжаем без ожидания следующего тика
+			for ctx.Err() == nil {
+				expired, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.ExpirePoints, &batchLimit, o.transactionStorage.IsRetryable)
+				if err != nil {
+					logger.Log.Error("Expire points", zap.Error(err))
+					break
+				}
+				if expired == nil {
+					break
+				}
+				for _, v := range *expired {
+					logger.Log.Info("Points expired",
+						zap.Int64("user", v.UserID),
+						zap.Int64("amount", int64(v.Amount)),
+						zap.Int("lots", v.Lots),
+					)
+				}
+				if len(*expired) < batchLimit {
+					break
+				}
+			}
+		case <-ctx.Done():
+			logger.Log.Info("Expiry shutting down gracefully")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/tracing"
+)
+
+var ErrExportPeriod = errors.New("export period must have start before end")
+
+// Export выгружает операции через write. Повторы при ошибках БД здесь не выполняются:
+// часть строк к этому моменту уже может быть отправлена клиенту
+func (o *TransactionRepo) Export(ctx context.Context, filter domain.ExportFilter, write func(*domain.ExportRow) error) error {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.Export")
+	defer span.End()
+	if filter.From != nil && filter.To != nil && !filter.From.Before(*filter.To) {
+		return ErrExportPeriod
+	}
+	if filter.UserID == 0 && (filter.From == nil || filter.To == nil) {
+		return ErrExportPeriod
+	}
+	if err := o.transactionStorage.ExportTransactions(ctx, &filter, write); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	return nil
+}
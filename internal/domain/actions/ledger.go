@@ -0,0 +1,49 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/retry"
+	"loyalty-system/pkg/tracing"
+)
+
+var ErrStatementPeriod = errors.New("statement period start must be before its end")
+
+func (o *TransactionRepo) GetLedger(ctx context.Context, filter domain.ListFilter) (*[]domain.LedgerEntry, *domain.ListCursor, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.GetLedger")
+	defer span.End()
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit = limit + 1
+	}
+	ret, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetLedger, &filter, o.transactionStorage.IsRetryable)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get ledger: %w", err)
+	}
+	if ret == nil {
+		return nil, nil, ErrNotExists
+	}
+	entries, next := page(*ret, limit, func(v domain.LedgerEntry) domain.ListCursor {
+		return domain.ListCursor{At: time.Time(v.ProcessedAt), Number: v.Number}
+	})
+	return &entries, next, nil
+}
+
+// GetStatement возвращает выписку за период; пустые границы означают начало истории и текущий момент
+func (o *TransactionRepo) GetStatement(ctx context.Context, userID int64, from, to *time.Time) (*domain.Statement, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.GetStatement")
+	defer span.End()
+	if from != nil && to != nil && !from.Before(*to) {
+		return nil, ErrStatementPeriod
+	}
+	filter := domain.ListFilter{UserID: userID, From: from, To: to}
+	ret, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetStatement, &filter, o.transactionStorage.IsRetryable)
+	if err != nil {
+		return nil, fmt.Errorf("get statement: %w", err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,112 @@
+package actions
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+)
+
+// subscriberBuffer - сколько событий может накопиться у медленного подписчика, прежде чем новые начнут отбрасываться
+const subscriberBuffer = 32
+
+// eventHub раздаёт события, полученные через LISTEN, подписчикам того пользователя, к которому они относятся
+type eventHub struct {
+	mu          sync.Mutex
+	closed      bool
+	subscribers map[int64]map[chan domain.Event]struct{}
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[int64]map[chan domain.Event]struct{})}
+}
+
+func (h *eventHub) subscribe(userID int64) (chan domain.Event, func()) {
+	ch := make(chan domain.Event, subscriberBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan domain.Event]struct{})
+	}
+	h.subscribers[userID][ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subscribers[userID][ch]; !ok {
+			return
+		}
+		delete(h.subscribers[userID], ch)
+		if len(h.subscribers[userID]) == 0 {
+			delete(h.subscribers, userID)
+		}
+		close(ch)
+	}
+}
+
+func (h *eventHub) publish(event domain.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers[event.UserID] {
+		select {
+		case ch <- event:
+		default:
+			logger.Log.Warn("Subscriber is too slow, event dropped", zap.Int64("user", event.UserID), zap.String("type", event.Type))
+		}
+	}
+}
+
+// close закрывает каналы всех подписчиков, чтобы открытые потоки завершились при остановке сервиса
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for userID, channels := range h.subscribers {
+		for ch := range channels {
+			close(ch)
+		}
+		delete(h.subscribers, userID)
+	}
+}
+
+// Subscribe возвращает канал событий пользователя и функцию отписки. Канал закрывается при отписке или остановке сервиса
+func (o *TransactionRepo) Subscribe(userID int64) (<-chan domain.Event, func()) {
+	return o.hub.subscribe(userID)
+}
+
+// RunNotifications слушает события, зафиксированные любой репликой, и раздаёт их подписчикам.
+// При обрыве соединения с БД подписка восстанавливается; события, пришедшие во время обрыва, теряются
+func (o *TransactionRepo) RunNotifications(ctx context.Context) error {
+	events := make(chan domain.Event, subscriberBuffer)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range events {
+			o.hub.publish(event)
+		}
+	}()
+	defer func() {
+		close(events)
+		<-done
+		o.hub.close()
+		logger.Log.Info("Notifications shutting down gracefully")
+	}()
+	for {
+		err := o.transactionStorage.Listen(ctx, events)
+		if ctx.Err() != nil {
+			return nil
+		}
+		logger.Log.Error("Listen notifications", zap.Error(err))
+		select {
+		case <-time.After(3 * time.Second):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package actions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"loyalty-system/internal/config"
+	"loyalty-system/internal/domain/dbstorage/memstorage"
+	"loyalty-system/internal/domain/dbstorage/pgtransactions"
+	"loyalty-system/internal/domain/dbstorage/pgusers"
+	"loyalty-system/internal/domain/dbstorage/sqlitestorage"
+)
+
+// Storage - хранилища пользователей и операций одного бэкенда. Хранилища в памяти и SQLite обслуживают оба интерфейса
+// одним экземпляром: операции ссылаются на пользователей, а у файла SQLite общий пул соединений и общие подписчики на события
+type Storage struct {
+	users        users
+	transactions transactionStorage
+	db           *sql.DB
+	closers      []io.Closer
+}
+
+// OpenStorage открывает хранилище по config; из одного Storage создаются и UserStorage, и TransactionRepo
+func OpenStorage(ctx context.Context, cfg *config.Config) (*Storage, error) {
+	switch cfg.Storage {
+	case config.StorageMemory:
+		ms := memstorage.New()
+		return &Storage{users: ms, transactions: ms}, nil
+	case config.StoragePostgres, "":
+		if cfg.SQLite() {
+			ms, err := sqlitestorage.New(ctx, strings.TrimPrefix(cfg.DSN, config.SQLiteScheme))
+			if err != nil {
+				return nil, err
+			}
+			return &Storage{users: ms, transactions: ms, closers: []io.Closer{ms}}, nil
+		}
+		userStorage, err := pgusers.NewUserStorage(ctx, cfg.DSN)
+		if err != nil {
+			return nil, err
+		}
+		transactions, err := pgtransactions.NewOrdersStorage(ctx, cfg.DSN)
+		if err != nil {
+			userStorage.Close()
+			return nil, err
+		}
+		return &Storage{users: userStorage, transactions: transactions, db: transactions.DB(),
+			closers: []io.Closer{userStorage, transactions}}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage %q", cfg.Storage)
+	}
+}
+
+// DB возвращает пул соединений PostgreSQL для миграций; у хранилищ в памяти и SQLite - nil
+func (s *Storage) DB() *sql.DB {
+	return s.db
+}
+
+// Close закрывает соединения с БД; хранилище в памяти закрывать не нужно
+func (s *Storage) Close() error {
+	var errs []error
+	for _, c := range s.closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
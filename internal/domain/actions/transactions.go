@@ -2,24 +2,24 @@ package actions
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
-	"sort"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
+	"loyalty-system/internal/accrual"
 	"loyalty-system/internal/config"
 	"loyalty-system/internal/domain"
-	"loyalty-system/internal/domain/dbstorage/pgtransactions"
+	"loyalty-system/internal/events"
 	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
 	"loyalty-system/pkg/retry"
 	"loyalty-system/pkg/security"
+	"loyalty-system/pkg/tracing"
 )
 
 var ErrOrderUploadedCurrUser = errors.New("the order number has already been uploaded by this user")
@@ -28,44 +28,98 @@ var ErrOrderAccepted = errors.New("the new order number has been accepted for pr
 var ErrUnexpectedReturn = errors.New("unexpected error")
 var ErrOrderFormat = errors.New("incorrect order number format")
 var ErrNotExists = errors.New("no transactionStorage")
-var ErrInsufficientFounds = errors.New("there are insufficient funds in the account")
+var ErrInsufficientFounds = domain.ErrInsufficientFunds
+var ErrBatchTooLarge = errors.New("too many order numbers in one batch")
+var ErrBatchEmpty = errors.New("no order numbers in batch")
+var ErrWithdrawSum = errors.New("withdrawal sum must be positive")
+
+// MaxBatchOrders - наибольшее количество номеров в одной пачке
+const MaxBatchOrders = 1000
 
 type TransactionRepo struct {
 	transactionStorage
-	balanceRWMutex sync.RWMutex
-	client         *resty.Client
+	accrual       accrual.Client
+	webhookClient *resty.Client
+	hub           *eventHub
+	validator     security.OrderValidator
+	heartbeat     atomic.Int64
+	pointsTTL     time.Duration
+	expiryNotice  time.Duration
 }
 
 type transactionStorage interface {
 	AddOrder(ctx context.Context, order *domain.Order) error
+	ResetOrder(ctx context.Context, reset *domain.OrderReset) error
+	AddAdjustment(ctx context.Context, adjustment *domain.Adjustment) error
+	GetAllAdjustments(ctx context.Context, filter *domain.ListFilter) (*[]domain.Adjustment, error)
+	GetLedger(ctx context.Context, filter *domain.ListFilter) (*[]domain.LedgerEntry, error)
+	GetStatement(ctx context.Context, filter *domain.ListFilter) (*domain.Statement, error)
+	ExportTransactions(ctx context.Context, filter *domain.ExportFilter, write func(*domain.ExportRow) error) error
+	ExpirePoints(ctx context.Context, limit *int) (*[]domain.Expiration, error)
+	GetExpiringPoints(ctx context.Context, filter *domain.ExpiryFilter) (*domain.ExpiringPoints, error)
+	AddOrders(ctx context.Context, batch *domain.OrderBatch) (*[]domain.BatchOrder, error)
 	GetOrder(ctx context.Context, orderNumber *string) (*domain.Order, error)
 	AddWithdraw(ctx context.Context, withdraw *domain.Withdraw) error
 	GetWithdraw(ctx context.Context, orderNumber *string) (*domain.Withdraw, error)
-	GetAllOrders(ctx context.Context, UserID *int64) (*[]domain.Order, error)
-	GetAllWithdraw(ctx context.Context, UserID *int64) (*[]domain.Withdraw, error)
+	GetAllOrders(ctx context.Context, filter *domain.ListFilter) (*[]domain.Order, error)
+	GetAllWithdraw(ctx context.Context, filter *domain.ListFilter) (*[]domain.Withdraw, error)
 	GetBalance(ctx context.Context, UserID *int64) (*domain.Balance, error)
 	GetUnprocessedOrders(ctx context.Context, batchLimit *int) (*[]domain.Order, error)
 	SetProcessedAccruals(ctx context.Context, orders *[]domain.Accrual) error
+	CountOrdersByStatus(ctx context.Context) (map[string]int64, error)
+	Ping(ctx context.Context) error
+	ReconcileBalances(ctx context.Context, repair *bool) (*[]domain.BalanceDrift, error)
+	GetPendingEvents(ctx context.Context, limit *int) (*[]domain.Event, error)
+	MarkEventsDelivered(ctx context.Context, ids *[]int64) error
+	MarkEventsFailed(ctx context.Context, failure *domain.EventFailure) error
+	DeleteEvents(ctx context.Context, cleanup *domain.EventCleanup) (int64, error)
+	AddWebhook(ctx context.Context, webhook *domain.Webhook) error
+	GetWebhooks(ctx context.Context, userID *int64) (*[]domain.Webhook, error)
+	DeleteWebhook(ctx context.Context, webhook *domain.Webhook) error
+	GetWebhookDeliveries(ctx context.Context, webhook *domain.Webhook) (*[]domain.WebhookDelivery, error)
+	GetDueDeliveries(ctx context.Context, limit *int) (*[]domain.WebhookDelivery, error)
+	SaveDeliveryResult(ctx context.Context, delivery *domain.WebhookDelivery) error
+	DeleteDeliveries(ctx context.Context, olderThan *time.Time) (int64, error)
+	Listen(ctx context.Context, events chan<- domain.Event) error
 	IsRetryable(err error) bool
 }
 
-func GetTransactionRepo(ctx context.Context, config *config.Config) (TransactionRepo, error) {
-	storage, err := pgtransactions.NewOrdersStorage(ctx, config.DSN)
+func GetTransactionRepo(config *config.Config, storage *Storage) (TransactionRepo, error) {
+	return NewTransactionRepo(config, storage, NewAccrualClient(config))
+}
+
+// NewAccrualClient создаёт клиент системы начислений с таймаутом, ограничением частоты и автоматом отключения из config
+func NewAccrualClient(config *config.Config) accrual.Client {
+	return accrual.New(accrual.Config{
+		BaseURL:          config.AccrualHost,
+		Timeout:          time.Second * time.Duration(config.AccrualTimeout),
+		RateLimit:        config.AccrualRateLimit,
+		FailureThreshold: config.AccrualBreakerThreshold,
+		OpenTimeout:      time.Second * time.Duration(config.AccrualBreakerTimeout),
+	})
+}
+
+// NewTransactionRepo создаёт репозиторий поверх открытого хранилища storage с заданным клиентом системы начислений
+func NewTransactionRepo(config *config.Config, storage *Storage, client accrual.Client) (TransactionRepo, error) {
+	validator, err := security.NewOrderValidator(config.OrderValidation, config.OrderPattern)
 	if err != nil {
 		return TransactionRepo{}, err
 	}
 	return TransactionRepo{
-		transactionStorage: storage,
-		client: resty.New().
-			SetBaseURL(config.AccrualHost).
-			SetRetryCount(3).
-			SetRetryWaitTime(3 * time.Second),
+		transactionStorage: storage.transactions,
+		accrual:            client,
+		webhookClient:      newWebhookClient(),
+		hub:                newEventHub(),
+		validator:          validator,
+		pointsTTL:          time.Duration(config.PointsTTL) * 24 * time.Hour,
+		expiryNotice:       time.Duration(config.ExpiryNotice) * 24 * time.Hour,
 	}, nil
 }
 
 func (o *TransactionRepo) NewOrder(ctx context.Context, userID int64, orderNum string) error {
-	orderInt, err := strconv.ParseInt(orderNum, 10, 64)
-	if err != nil || !security.ValidLuhn(orderInt) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.NewOrder")
+	defer span.End()
+	if !o.validator.Valid(orderNum) {
 		return ErrOrderFormat
 	}
 	order, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetOrder, &orderNum, o.transactionStorage.IsRetryable)
@@ -76,6 +130,10 @@ func (o *TransactionRepo) NewOrder(ctx context.Context, userID int64, orderNum s
 		amount := domain.CustomMoney(0)
 		order = &domain.Order{UserID: userID, Number: orderNum, Status: "NEW", Accrual: &amount, UploadedAt: domain.CustomTime(time.Now())}
 		err = retry.DoWithoutReturn(ctx, 3, o.transactionStorage.AddOrder, order, o.transactionStorage.IsRetryable)
+		if errors.Is(err, domain.ErrAlreadyExists) {
+			// номер успели загрузить параллельным запросом - определяем, кто
+			return o.NewOrder(ctx, userID, orderNum)
+		}
 		if err != nil {
 			return fmt.Errorf("add order: %w", err)
 		}
@@ -89,64 +147,169 @@ func (o *TransactionRepo) NewOrder(ctx context.Context, userID int64, orderNum s
 	}
 }
 
-func (o *TransactionRepo) GetAllOrders(ctx context.Context, UserID int64) (*[]domain.Order, error) {
-	ret, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetAllOrders, &UserID, o.transactionStorage.IsRetryable)
+// NewOrders загружает пачку номеров заказов. Результат по каждому номеру (в порядке запроса, включая повторы)
+// соответствует ответу NewOrder для этого номера
+func (o *TransactionRepo) NewOrders(ctx context.Context, userID int64, numbers []string) ([]domain.OrderUploadResult, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.NewOrders")
+	defer span.End()
+	if len(numbers) == 0 {
+		return nil, ErrBatchEmpty
+	}
+	if len(numbers) > MaxBatchOrders {
+		return nil, ErrBatchTooLarge
+	}
+	results := make(map[string]error, len(numbers))
+	batch := &domain.OrderBatch{UserID: userID, UploadedAt: domain.CustomTime(time.Now())}
+	for _, v := range numbers {
+		if _, ok := results[v]; ok {
+			continue
+		}
+		if !o.validator.Valid(v) {
+			results[v] = ErrOrderFormat
+			continue
+		}
+		results[v] = nil
+		batch.Numbers = append(batch.Numbers, v)
+	}
+	if len(batch.Numbers) > 0 {
+		inserted, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.AddOrders, batch, o.transactionStorage.IsRetryable)
+		if err != nil {
+			return nil, fmt.Errorf("add orders: %w", err)
+		}
+		for _, v := range *inserted {
+			switch {
+			case v.Accepted:
+				results[v.Number] = ErrOrderAccepted
+			case v.OwnerID == userID:
+				results[v.Number] = ErrOrderUploadedCurrUser
+			case v.OwnerID != 0:
+				results[v.Number] = ErrOrderUploadedAnotherUser
+			default:
+				// номер вставили параллельным запросом после начала нашего - владельца определяем отдельно
+				results[v.Number] = o.NewOrder(ctx, userID, v.Number)
+			}
+		}
+	}
+	ret := make([]domain.OrderUploadResult, 0, len(numbers))
+	for _, v := range numbers {
+		ret = append(ret, uploadResult(v, results[v]))
+	}
+	return ret, nil
+}
+
+func uploadResult(number string, err error) domain.OrderUploadResult {
+	ret := domain.OrderUploadResult{Number: number}
+	switch {
+	case errors.Is(err, ErrOrderAccepted):
+		ret.Result = domain.UploadAccepted
+	case errors.Is(err, ErrOrderUploadedCurrUser):
+		ret.Result = domain.UploadAlreadyUploaded
+	case errors.Is(err, ErrOrderUploadedAnotherUser):
+		ret.Result = domain.UploadAnotherUser
+	case errors.Is(err, ErrOrderFormat):
+		ret.Result = domain.UploadInvalidFormat
+	default:
+		ret.Result = domain.UploadFailed
+		if err == nil {
+			err = ErrUnexpectedReturn
+		}
+	}
+	ret.Message = err.Error()
+	return ret
+}
+
+// GetAllOrders возвращает заказы пользователя от новых к старым. Если filter.Limit задан и записей больше,
+// вместе со страницей возвращается курсор следующей страницы
+func (o *TransactionRepo) GetAllOrders(ctx context.Context, filter domain.ListFilter) (*[]domain.Order, *domain.ListCursor, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.GetAllOrders")
+	defer span.End()
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit = limit + 1
+	}
+	ret, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetAllOrders, &filter, o.transactionStorage.IsRetryable)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if ret == nil {
-		return nil, ErrNotExists
+		return nil, nil, ErrNotExists
 	}
-	sortedRet := *ret
-	sort.Slice(sortedRet[:], func(i, j int) (less bool) {
-		return time.Time(sortedRet[i].UploadedAt).After(time.Time(sortedRet[j].UploadedAt))
+	orders, next := page(*ret, limit, func(v domain.Order) domain.ListCursor {
+		return domain.ListCursor{At: time.Time(v.UploadedAt), Number: v.Number}
 	})
-	return &sortedRet, nil
+	return &orders, next, nil
 }
 
 func (o *TransactionRepo) GetBalance(ctx context.Context, UserID int64) (*domain.Balance, error) {
-	o.balanceRWMutex.RLock()
-	defer o.balanceRWMutex.RUnlock()
-	return retry.DoWithReturn(ctx, 3, o.transactionStorage.GetBalance, &UserID, o.transactionStorage.IsRetryable)
+	ctx, span := tracing.Start(ctx, "TransactionRepo.GetBalance")
+	defer span.End()
+	balance, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetBalance, &UserID, o.transactionStorage.IsRetryable)
+	if err != nil || o.expiryNotice <= 0 {
+		return balance, err
+	}
+	filter := domain.ExpiryFilter{UserID: UserID, Before: time.Now().Add(o.expiryNotice)}
+	balance.ExpiringSoon, err = retry.DoWithReturn(ctx, 3, o.transactionStorage.GetExpiringPoints, &filter, o.transactionStorage.IsRetryable)
+	if err != nil {
+		return nil, fmt.Errorf("get expiring points: %w", err)
+	}
+	return balance, nil
 }
 
-func (o *TransactionRepo) GetAllWithdraw(ctx context.Context, UserID int64) (*[]domain.Withdraw, error) {
-	ret, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetAllWithdraw, &UserID, o.transactionStorage.IsRetryable)
+func (o *TransactionRepo) GetAllWithdraw(ctx context.Context, filter domain.ListFilter) (*[]domain.Withdraw, *domain.ListCursor, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.GetAllWithdraw")
+	defer span.End()
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit = limit + 1
+	}
+	ret, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetAllWithdraw, &filter, o.transactionStorage.IsRetryable)
 	if err != nil {
-		return nil, fmt.Errorf("get all withdraw: %w", err)
+		return nil, nil, fmt.Errorf("get all withdraw: %w", err)
 	}
 	if ret == nil {
-		return nil, ErrNotExists
+		return nil, nil, ErrNotExists
 	}
-	sortedRet := *ret
-	sort.Slice(sortedRet[:], func(i, j int) (less bool) {
-		return time.Time(sortedRet[i].ProcessedAt).After(time.Time(sortedRet[j].ProcessedAt))
+	withdraws, next := page(*ret, limit, func(v domain.Withdraw) domain.ListCursor {
+		return domain.ListCursor{At: time.Time(v.ProcessedAt), Number: v.Order}
 	})
-	return &sortedRet, nil
+	return &withdraws, next, nil
+}
+
+// page обрезает выборку, запрошенную с запасом в одну запись, до limit и возвращает курсор, если есть следующая страница
+func page[T any](items []T, limit int, cursor func(T) domain.ListCursor) ([]T, *domain.ListCursor) {
+	if limit <= 0 || len(items) <= limit {
+		return items, nil
+	}
+	items = items[:limit]
+	next := cursor(items[limit-1])
+	return items, &next
 }
 
 func (o *TransactionRepo) NewWithdraw(ctx context.Context, newWithdraw domain.Withdraw) error {
-	orderInt, err := strconv.ParseInt(newWithdraw.Order, 10, 64)
-	if err != nil || !security.ValidLuhn(orderInt) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.NewWithdraw")
+	defer span.End()
+	if !o.validator.Valid(newWithdraw.Order) {
 		return ErrOrderFormat
 	}
+	if newWithdraw.Sum <= 0 {
+		return ErrWithdrawSum
+	}
 	withdraw, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetWithdraw, &newWithdraw.Order, o.transactionStorage.IsRetryable)
 	switch {
 	case err != nil:
 		return fmt.Errorf("get withdraw: %w", err)
 	case withdraw == nil:
 		withdraw = &domain.Withdraw{UserID: newWithdraw.UserID, Order: newWithdraw.Order, Sum: newWithdraw.Sum, ProcessedAt: domain.CustomTime(time.Now())}
-		o.balanceRWMutex.Lock()
-		defer o.balanceRWMutex.Unlock()
-		bal, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetBalance, &newWithdraw.UserID, o.transactionStorage.IsRetryable)
-		if err != nil {
-			return fmt.Errorf("chek balance: %w", err)
-		}
-		if bal.Current < newWithdraw.Sum {
-			return ErrInsufficientFounds
-		}
 		err = retry.DoWithoutReturn(ctx, 3, o.transactionStorage.AddWithdraw, withdraw, o.transactionStorage.IsRetryable)
-		if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrInsufficientFunds):
+			return ErrInsufficientFounds
+		case errors.Is(err, domain.ErrInvalidAmount):
+			return ErrWithdrawSum
+		case errors.Is(err, domain.ErrAlreadyExists):
+			// номер успели списать параллельным запросом - определяем, чей он
+			return o.NewWithdraw(ctx, newWithdraw)
+		case err != nil:
 			return fmt.Errorf("add withdraw: %w", err)
 		}
 		return nil
@@ -159,41 +322,6 @@ func (o *TransactionRepo) NewWithdraw(ctx context.Context, newWithdraw domain.Wi
 	}
 }
 
-func (o *TransactionRepo) getAccrual(ctx context.Context, orderNumber *string) (*domain.Accrual, *int, error) {
-	ret, err := o.client.
-		R().
-		SetContext(ctx).
-		SetHeader("Content-Type", "text/plain").
-		Get(fmt.Sprintf("%v/api/orders/%v", o.client.BaseURL, *orderNumber))
-	if err != nil {
-		return nil, nil, fmt.Errorf("send: %w", err)
-	}
-	switch ret.StatusCode() {
-	case http.StatusInternalServerError:
-		return nil, nil, fmt.Errorf("get accrual: %v", ret.Status())
-	case http.StatusNoContent:
-		return nil, nil, nil
-	case http.StatusTooManyRequests:
-		pause, err := strconv.Atoi(ret.Header().Get("Retry-After"))
-		if err != nil {
-			return nil, nil, nil
-		}
-		return nil, &pause, nil
-	case http.StatusOK:
-		accrual := domain.Accrual{}
-		err = json.Unmarshal(ret.Body(), &accrual)
-		if err != nil {
-			return nil, nil, fmt.Errorf("unmarshal json: %w", err)
-		}
-		if accrual.Sum == nil {
-			amount := domain.CustomMoney(0)
-			accrual.Sum = &amount
-		}
-		return &accrual, nil, nil
-	}
-	return nil, nil, ErrUnexpectedReturn
-}
-
 func (o *TransactionRepo) getUnprocessedOrders(ctx context.Context, batchLimit int) (*[]domain.Order, error) {
 	ret, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetUnprocessedOrders, &batchLimit, o.transactionStorage.IsRetryable)
 	if err != nil {
@@ -206,21 +334,38 @@ func (o *TransactionRepo) getUnprocessedOrders(ctx context.Context, batchLimit i
 }
 
 func (o *TransactionRepo) setProcessedAccruals(ctx context.Context, accrual *[]domain.Accrual) error {
-	o.balanceRWMutex.RLock()
-	defer o.balanceRWMutex.RUnlock()
+	if expiresAt := o.expiresAt(); expiresAt != nil {
+		for i := range *accrual {
+			if (*accrual)[i].Status == "PROCESSED" {
+				(*accrual)[i].ExpiresAt = expiresAt
+			}
+		}
+	}
 	return retry.DoWithoutReturn(ctx, 3, o.transactionStorage.SetProcessedAccruals, accrual, o.transactionStorage.IsRetryable)
 }
 
-func (o *TransactionRepo) processingBatchOrders(ctx context.Context, batchLimit int, SendLimit int) (int, error) {
-	pause := 0
+// processingBatchOrders обрабатывает пачку заказов и возвращает паузу, которую попросила система начислений.
+// Ошибка по одному заказу не мешает остальным: заказ пропускается до следующей пачки. После ответа 429 или
+// отключения системы начислений оставшиеся запросы пачки отменяются; полученные начисления сохраняются всегда
+func (o *TransactionRepo) processingBatchOrders(ctx context.Context, batchLimit int, SendLimit int) (time.Duration, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.processingBatchOrders")
+	defer span.End()
+	var pause time.Duration
 	unprocessedOrders, err := o.getUnprocessedOrders(ctx, batchLimit)
+	if errors.Is(err, ErrNotExists) {
+		metrics.ProcessingBatchSize.Observe(0)
+		metrics.ProcessingLag.Set(0)
+		return pause, nil
+	}
 	if err != nil {
 		logger.Log.Error("Get unprocessed orders", zap.Error(err))
 		return pause, fmt.Errorf("get unprocessed orders: %w", err)
 	}
 
+	metrics.ProcessingBatchSize.Observe(float64(len(*unprocessedOrders)))
+	metrics.ProcessingLag.Set(time.Since(time.Time((*unprocessedOrders)[0].UploadedAt)).Seconds())
+
 	returnedAccrual := make(chan *domain.Accrual, SendLimit)
-	requestPause := make(chan *int, SendLimit)
 	accrualForSet := make([]domain.Accrual, 0, len(*unprocessedOrders))
 	wg := sync.WaitGroup{}
 	wg.Add(1)
@@ -231,85 +376,219 @@ func (o *TransactionRepo) processingBatchOrders(ctx context.Context, batchLimit
 		}
 	}()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for val := range requestPause {
-			pause = *val
-		}
-	}()
-
-	g := errgroup.Group{}
+	g, gctx := errgroup.WithContext(ctx)
 	g.SetLimit(SendLimit)
 
 	for _, v := range *unprocessedOrders {
 		val := v
 		g.Go(func() error {
-			accrual, pause, err := o.getAccrual(ctx, &val.Number)
-			if err != nil {
+			ret, err := o.accrual.GetOrder(gctx, val.Number)
+			switch {
+			case err == nil:
+				returnedAccrual <- ret
+				return nil
+			case errors.Is(err, accrual.ErrNotRegistered):
+				return nil
+			case errors.Is(err, accrual.ErrRateLimited), errors.Is(err, accrual.ErrCircuitOpen):
 				return err
+			case gctx.Err() != nil:
+				// пачка уже остановлена другим запросом
+				return nil
+			default:
+				metrics.ProcessingOrderErrors.Inc()
+				logger.Log.Error("get accrual", zap.String("order", val.Number), zap.Error(err))
+				return nil
 			}
-			if pause != nil {
-				requestPause <- pause
-			}
-			if accrual != nil {
-				returnedAccrual <- accrual
-			}
-			return nil
 		})
 	}
-	err = g.Wait()
-	if err != nil {
-		logger.Log.Error("get accrual", zap.Error(err))
-		close(returnedAccrual)
-		close(requestPause)
-		return pause, fmt.Errorf("get accrual: %w", err)
-	}
+	stopErr := g.Wait()
 	close(returnedAccrual)
-	close(requestPause)
 	wg.Wait()
+	var rateLimit *accrual.RateLimitError
+	if errors.As(stopErr, &rateLimit) {
+		pause = rateLimit.RetryAfter
+		stopErr = nil
+	}
 
 	err = o.setProcessedAccruals(ctx, &accrualForSet)
 	if err != nil {
 		logger.Log.Error("Set processed orders", zap.Error(err))
 		return pause, fmt.Errorf("set processed orders: %w", err)
 	}
+	if stopErr != nil {
+		return pause, fmt.Errorf("get accrual: %w", stopErr)
+	}
 	return pause, nil
 }
 
 func (o *TransactionRepo) RunProcessing(ctx context.Context, batchLimit int, sendLimit int, pollInterval int) error {
-	sendTicker := time.NewTicker(time.Second * time.Duration(pollInterval))
+	interval := time.Second * time.Duration(pollInterval)
+	sendTicker := time.NewTicker(interval)
 	defer sendTicker.Stop()
-	pauseChan := make(chan int)
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for {
-			select {
-			case <-sendTicker.C:
-				pause, err := o.processingBatchOrders(ctx, batchLimit, sendLimit)
+	o.heartbeat.Store(time.Now().UnixNano())
+	for {
+		select {
+		case <-sendTicker.C:
+			pause, err := o.processingBatchOrders(ctx, batchLimit, sendLimit)
+			if err != nil {
+				logger.Log.Error("Processing", zap.Error(err))
+			}
+			o.updateOrderMetrics(ctx)
+			o.heartbeat.Store(time.Now().UnixNano())
+			logger.Log.Info("Processed")
+			if pause > interval {
+				logger.Log.Info("Requested pause", zap.Duration("duration", pause))
+				sendTicker.Reset(pause)
+			} else {
+				sendTicker.Reset(interval)
+			}
+		case <-ctx.Done():
+			logger.Log.Info("Processing shutting down gracefully")
+			return nil
+		}
+	}
+}
+
+// LastHeartbeat возвращает время последнего цикла обработки заказов; нулевое, если обработка не запускалась
+func (o *TransactionRepo) LastHeartbeat() time.Time {
+	ns := o.heartbeat.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// PingAccrual проверяет, что система расчёта начислений отвечает на HTTP-запросы; код ответа не важен
+func (o *TransactionRepo) PingAccrual(ctx context.Context) error {
+	return o.accrual.Ping(ctx)
+}
+
+func (o *TransactionRepo) RunReconciliation(ctx context.Context, reconcileInterval int, repair bool) error {
+	ticker := time.NewTicker(time.Minute * time.Duration(reconcileInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			drifts, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.ReconcileBalances, &repair, o.transactionStorage.IsRetryable)
+			if err != nil {
+				logger.Log.Error("Reconcile balances", zap.Error(err))
+			}
+			if drifts == nil {
+				continue
+			}
+			for _, v := range *drifts {
+				logger.Log.Warn("Balance drift",
+					zap.Int64("user", v.UserID),
+					zap.Int64("stored_current", int64(v.StoredCurrent)),
+					zap.Int64("actual_current", int64(v.ActualCurrent)),
+					zap.Int64("stored_withdrawn", int64(v.StoredWithdrawn)),
+					zap.Int64("actual_withdrawn", int64(v.ActualWithdrawn)),
+					zap.Bool("repaired", repair && err == nil),
+				)
+			}
+			logger.Log.Info("Balances reconciled", zap.Int("drifts", len(*drifts)))
+		case <-ctx.Done():
+			logger.Log.Info("Reconciliation shutting down gracefully")
+			return nil
+		}
+	}
+}
+
+// RunOutboxPublisher доставляет события из outbox в sink пачками до batchLimit.
+// Событие помечается доставленным только после успешной публикации, поэтому возможны повторы, но не потери
+func (o *TransactionRepo) RunOutboxPublisher(ctx context.Context, sink events.Sink, batchLimit int, interval int) error {
+	ticker := time.NewTicker(time.Second * time.Duration(interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// пока в outbox есть события, публикуем без ожидания следующего тика
+			for ctx.Err() == nil {
+				published, err := o.publishEvents(ctx, sink, batchLimit)
 				if err != nil {
-					logger.Log.Error("Processing", zap.Error(err))
+					logger.Log.Error("Publish events", zap.Error(err))
 				}
-				if pause > 0 {
-					pauseChan <- pause
+				if err != nil || published < batchLimit {
+					break
 				}
-				logger.Log.Info("Processed")
-			case pause := <-pauseChan:
-				sendTicker.Stop()
-				if pause > pollInterval {
-					logger.Log.Info("Requested pause", zap.Int("duration", pause))
-					time.Sleep(time.Second * time.Duration(pollInterval-pause))
-				}
-				sendTicker = time.NewTicker(time.Second * time.Duration(pollInterval))
-				logger.Log.Info("Pause")
-			case <-ctx.Done():
-				logger.Log.Info("Processing shutting down gracefully")
-				return
 			}
+		case <-ctx.Done():
+			logger.Log.Info("Outbox publisher shutting down gracefully")
+			return nil
 		}
-	}()
-	wg.Wait()
-	return nil
+	}
+}
+
+func (o *TransactionRepo) publishEvents(ctx context.Context, sink events.Sink, batchLimit int) (int, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.publishEvents")
+	defer span.End()
+	pending, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetPendingEvents, &batchLimit, o.transactionStorage.IsRetryable)
+	if err != nil {
+		return 0, fmt.Errorf("get pending events: %w", err)
+	}
+	if pending == nil {
+		return 0, nil
+	}
+	ids := make([]int64, 0, len(*pending))
+	for _, v := range *pending {
+		ids = append(ids, v.ID)
+	}
+	err = sink.Publish(ctx, *pending)
+	if err != nil {
+		failure := &domain.EventFailure{IDs: ids, Error: err.Error()}
+		if markErr := retry.DoWithoutReturn(ctx, 3, o.transactionStorage.MarkEventsFailed, failure, o.transactionStorage.IsRetryable); markErr != nil {
+			logger.Log.Error("Mark events failed", zap.Error(markErr))
+		}
+		return 0, fmt.Errorf("publish: %w", err)
+	}
+	err = retry.DoWithoutReturn(ctx, 3, o.transactionStorage.MarkEventsDelivered, &ids, o.transactionStorage.IsRetryable)
+	if err != nil {
+		return 0, fmt.Errorf("mark events delivered: %w", err)
+	}
+	logger.Log.Info("Events published", zap.Int("count", len(ids)))
+	return len(ids), nil
+}
+
+// outboxCleanupInterval - период удаления старых событий из outbox
+const outboxCleanupInterval = time.Hour
+
+// RunOutboxCleanup удаляет события, доставленные больше retention часов назад. Без приёмника событий (dropPending)
+// публиковать их некому, поэтому удаляются и недоставленные события старше retention
+func (o *TransactionRepo) RunOutboxCleanup(ctx context.Context, retention int, dropPending bool) error {
+	ticker := time.NewTicker(outboxCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			before := time.Now().Add(-time.Hour * time.Duration(retention))
+			cleanup := &domain.EventCleanup{DeliveredBefore: before}
+			if dropPending {
+				cleanup.PendingBefore = &before
+			}
+			deleted, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.DeleteEvents, cleanup, o.transactionStorage.IsRetryable)
+			if err != nil {
+				logger.Log.Error("Delete events", zap.Error(err))
+				continue
+			}
+			if deleted > 0 {
+				logger.Log.Info("Outbox cleaned", zap.Int64("deleted", deleted))
+			}
+		case <-ctx.Done():
+			logger.Log.Info("Outbox cleanup shutting down gracefully")
+			return nil
+		}
+	}
+}
+
+var orderStatuses = []string{"NEW", "REGISTERED", "PROCESSING", "INVALID", "PROCESSED"}
+
+func (o *TransactionRepo) updateOrderMetrics(ctx context.Context) {
+	counts, err := o.transactionStorage.CountOrdersByStatus(ctx)
+	if err != nil {
+		logger.Log.Error("Count orders by status", zap.Error(err))
+		return
+	}
+	for _, status := range orderStatuses {
+		metrics.Orders.WithLabelValues(status).Set(float64(counts[status]))
+	}
 }
@@ -4,37 +4,79 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
+	"go.uber.org/zap"
 	"loyalty-system/internal/config"
 	"loyalty-system/internal/domain"
-	"loyalty-system/internal/domain/dbstorage/pgusers"
+	"loyalty-system/pkg/logger"
 	"loyalty-system/pkg/retry"
 	"loyalty-system/pkg/security"
+	"loyalty-system/pkg/tracing"
 )
 
 var ErrUserExists = errors.New("user already exists")
 var ErrWrongPassword = errors.New("wrong password")
 var ErrUserNotExists = errors.New("no such user")
+var ErrTokenRevoked = errors.New("token has been revoked")
+var ErrUserLocked = errors.New("user is locked")
+var ErrLoginReserved = errors.New("login is reserved for administrators")
+var ErrPasswordTooLong = fmt.Errorf("password is longer than %d bytes", security.MaxPasswordLength)
 
 type UserStorage struct {
 	users
+	hasher security.PasswordHasher
+	// reservedLogins нельзя занять при самостоятельной регистрации: такие учётные записи создаёт оператор
+	reservedLogins map[string]struct{}
 }
 
 type users interface {
 	AddUser(ctx context.Context, user *domain.User) error
 	GetUser(ctx context.Context, login *string) (*domain.User, error)
+	UpdateHash(ctx context.Context, user *domain.User) error
+	RevokeToken(ctx context.Context, token *domain.RevokedToken) error
+	IsTokenRevoked(ctx context.Context, jti *string) (bool, error)
+	GetUserInfo(ctx context.Context, userID *int64) (*domain.UserInfo, error)
+	SearchUsers(ctx context.Context, search *domain.UserSearch) (*[]domain.UserInfo, error)
+	IsUserLocked(ctx context.Context, userID *int64) (bool, error)
+	SetLocked(ctx context.Context, lock *domain.UserLock) error
+	SetRole(ctx context.Context, role *domain.UserRole) error
+	AddAudit(ctx context.Context, record *domain.AuditRecord) error
+	GetAudit(ctx context.Context, filter *domain.AuditFilter) (*[]domain.AuditRecord, error)
+	Ping(ctx context.Context) error
 	IsRetryable(err error) bool
 }
 
-func GetUserStorage(ctx context.Context, config *config.Config) (UserStorage, error) {
-	storage, err := pgusers.NewUserStorage(ctx, config.DSN)
+// GetUserStorage создаёт сервис пользователей поверх открытого хранилища storage
+func GetUserStorage(config *config.Config, storage *Storage) (UserStorage, error) {
+	hasher, err := security.NewPasswordHasher(config.PasswordHasher, config.Salt)
 	if err != nil {
-		return UserStorage{}, fmt.Errorf("get user storage: %w", err)
+		return UserStorage{}, fmt.Errorf("get password hasher: %w", err)
 	}
-	return UserStorage{users: storage}, nil
+	reservedLogins := make(map[string]struct{})
+	for _, v := range strings.Split(config.AdminLogins, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			reservedLogins[v] = struct{}{}
+		}
+	}
+	return UserStorage{users: storage.users, hasher: hasher, reservedLogins: reservedLogins}, nil
+}
+
+// NewUser регистрирует пользователя с ролью user; логины из ADMIN_LOGINS зарезервированы
+func (u *UserStorage) NewUser(ctx context.Context, login string, password string) error {
+	ctx, span := tracing.Start(ctx, "UserStorage.NewUser")
+	defer span.End()
+	if _, ok := u.reservedLogins[login]; ok {
+		return ErrLoginReserved
+	}
+	return u.addUser(ctx, login, password, domain.RoleUser)
 }
 
-func (u *UserStorage) NewUser(ctx context.Context, login string, password string, salt string) error {
+// addUser создаёт пользователя сразу с ролью role, чтобы пользователь не появился без неё
+func (u *UserStorage) addUser(ctx context.Context, login string, password string, role string) error {
+	if len(password) > security.MaxPasswordLength {
+		return ErrPasswordTooLong
+	}
 	user, err := retry.DoWithReturn(ctx, 3, u.GetUser, &login, u.IsRetryable)
 	if err != nil {
 		return fmt.Errorf("get user: %w", err)
@@ -44,7 +86,11 @@ func (u *UserStorage) NewUser(ctx context.Context, login string, password string
 	}
 	user = &domain.User{}
 	user.Login = login
-	user.Hash = security.CreateHash(password, salt)
+	user.Role = role
+	user.Hash, err = u.hasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("hash password: %w", err)
+	}
 	err = retry.DoWithoutReturn(ctx, 3, u.AddUser, user, u.IsRetryable)
 	if err != nil {
 		return fmt.Errorf("new user: %w", err)
@@ -52,16 +98,82 @@ func (u *UserStorage) NewUser(ctx context.Context, login string, password string
 	return nil
 }
 
-func (u *UserStorage) LoginUser(ctx context.Context, login string, password string, salt string) (int64, error) {
+func (u *UserStorage) LoginUser(ctx context.Context, login string, password string) (*domain.UserInfo, error) {
+	ctx, span := tracing.Start(ctx, "UserStorage.LoginUser")
+	defer span.End()
 	user, err := retry.DoWithReturn(ctx, 3, u.GetUser, &login, u.IsRetryable)
 	if err != nil {
-		return -1, fmt.Errorf("login user: %w", err)
+		return nil, fmt.Errorf("login user: %w", err)
 	}
 	if user == nil {
-		return -1, ErrUserNotExists
+		return nil, ErrUserNotExists
+	}
+	ok, err := u.hasher.Verify(password, user.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("verify password: %w", err)
+	}
+	if !ok {
+		return nil, ErrWrongPassword
+	}
+	if user.Locked {
+		return nil, ErrUserLocked
+	}
+	if u.hasher.NeedsRehash(user.Hash) {
+		u.rehash(ctx, user, password)
 	}
-	if !security.CheckHash(password, user.Hash, salt) {
-		return -1, ErrWrongPassword
+	return &domain.UserInfo{ID: user.UserID, Login: user.Login, Role: user.Role}, nil
+}
+
+// rehash переводит хэш пароля на текущий алгоритм. Пароль уже проверен, поэтому ошибка лишь логируется
+func (u *UserStorage) rehash(ctx context.Context, user *domain.User, password string) {
+	hash, err := u.hasher.Hash(password)
+	if err != nil {
+		logger.Log.Error("Rehash password", zap.Error(err))
+		return
 	}
-	return user.UserID, nil
+	user.Hash = hash
+	err = retry.DoWithoutReturn(ctx, 3, u.UpdateHash, user, u.IsRetryable)
+	if err != nil {
+		logger.Log.Error("Update password hash", zap.Int64("user", user.UserID), zap.Error(err))
+		return
+	}
+	logger.Log.Info("Password hash upgraded", zap.Int64("user", user.UserID))
+}
+
+// RevokeToken вносит токен в список отозванных; повторный отзыв возвращает ErrTokenRevoked
+func (u *UserStorage) RevokeToken(ctx context.Context, claims *security.Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	token := &domain.RevokedToken{ID: claims.ID, UserID: claims.UserID, ExpiresAt: claims.ExpiresAt.Time}
+	err := retry.DoWithoutReturn(ctx, 3, u.users.RevokeToken, token, u.IsRetryable)
+	if errors.Is(err, domain.ErrAlreadyExists) {
+		return ErrTokenRevoked
+	}
+	if err != nil {
+		return fmt.Errorf("revoke token: %w", err)
+	}
+	return nil
+}
+
+// CheckToken проверяет, что токен не отозван и пользователь не заблокирован; токен без jti отозвать нельзя,
+// но блокировка проверяется и для него
+func (u *UserStorage) CheckToken(ctx context.Context, claims *security.Claims) error {
+	if claims.ID != "" {
+		revoked, err := retry.DoWithReturn(ctx, 3, u.IsTokenRevoked, &claims.ID, u.IsRetryable)
+		if err != nil {
+			return fmt.Errorf("check token: %w", err)
+		}
+		if revoked {
+			return ErrTokenRevoked
+		}
+	}
+	locked, err := retry.DoWithReturn(ctx, 3, u.IsUserLocked, &claims.UserID, u.IsRetryable)
+	if err != nil {
+		return fmt.Errorf("check user lock: %w", err)
+	}
+	if locked {
+		return ErrUserLocked
+	}
+	return nil
 }
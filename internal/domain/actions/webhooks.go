@@ -0,0 +1,295 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/retry"
+	"loyalty-system/pkg/security"
+	"loyalty-system/pkg/tracing"
+)
+
+var ErrWebhookURL = errors.New("webhook url must be an absolute http or https url")
+var ErrWebhookExists = errors.New("webhook with this url is already registered")
+var ErrWebhookLimit = domain.ErrWebhookLimit
+var ErrWebhookNotExists = errors.New("webhook not found")
+var ErrWebhookAddress = errors.New("webhook url must not point to a loopback, private or link-local address")
+
+const (
+	// попытка n выполняется через webhookBackoff * 2^(n-1), но не позже чем через webhookMaxBackoff
+	webhookBackoff     = 10 * time.Second
+	webhookMaxBackoff  = time.Hour
+	webhookMaxAttempts = 10
+)
+
+// newWebhookClient создаёт клиент доставки, который соединяется только с публичными адресами. Адрес проверяется
+// при соединении, а не только при регистрации, чтобы DNS-имя не подменили внутренним адресом позже (DNS rebinding)
+func newWebhookClient() *resty.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddr(addrPort.Addr()) {
+				return ErrWebhookAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// через прокси проверялся бы адрес прокси, а не получателя
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return resty.New().
+		SetTransport(transport).
+		SetTimeout(10 * time.Second).
+		SetRedirectPolicy(resty.NoRedirectPolicy()).
+		OnBeforeRequest(tracing.InjectRequest)
+}
+
+// nonPublicPrefixes - специальные сети, которых нет среди проверок netip.Addr. Адреса трансляции NAT64 и 6to4
+// содержат внутри IPv4-адрес, поэтому закрыты целиком
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "эта" сеть
+	netip.MustParsePrefix("100.64.0.0/10"),  // CGNAT, в том числе метаданные облаков 100.100.100.200
+	netip.MustParsePrefix("192.0.0.0/24"),   // служебные адреса IETF
+	netip.MustParsePrefix("198.18.0.0/15"),  // тестирование производительности сетей
+	netip.MustParsePrefix("240.0.0.0/4"),    // зарезервированные и широковещательный
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64
+	netip.MustParsePrefix("64:ff9b:1::/48"), // локальный NAT64
+	netip.MustParsePrefix("2001::/32"),      // Teredo
+	netip.MustParsePrefix("2002::/16"),      // 6to4
+}
+
+// isPublicAddr сообщает, что на адрес можно отправлять уведомления: он не ведёт во внутреннюю сеть или на сам сервер
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() ||
+		addr.IsLoopback() ||
+		addr.IsPrivate() ||
+		addr.IsLinkLocalUnicast() ||
+		addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() ||
+		addr.IsUnspecified() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkWebhookHost проверяет, что все адреса хоста публичные
+func checkWebhookHost(ctx context.Context, host string) error {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !isPublicAddr(addr) {
+			return ErrWebhookAddress
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil || len(addrs) == 0 {
+		return ErrWebhookURL
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return ErrWebhookAddress
+		}
+	}
+	return nil
+}
+
+// NewWebhook регистрирует адрес уведомлений пользователя; в ответе единственный раз возвращается секрет подписи
+func (o *TransactionRepo) NewWebhook(ctx context.Context, userID int64, rawURL string) (*domain.Webhook, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.NewWebhook")
+	defer span.End()
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrWebhookURL
+	}
+	if err = checkWebhookHost(ctx, u.Hostname()); err != nil {
+		return nil, err
+	}
+	secret, err := security.NewSecret(32)
+	if err != nil {
+		return nil, err
+	}
+	webhook := &domain.Webhook{UserID: userID, URL: u.String(), Secret: secret}
+	err = retry.DoWithoutReturn(ctx, 3, o.transactionStorage.AddWebhook, webhook, o.transactionStorage.IsRetryable)
+	if errors.Is(err, domain.ErrAlreadyExists) {
+		return nil, ErrWebhookExists
+	}
+	if errors.Is(err, domain.ErrWebhookLimit) {
+		return nil, ErrWebhookLimit
+	}
+	if err != nil {
+		return nil, fmt.Errorf("add webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+func (o *TransactionRepo) GetWebhooks(ctx context.Context, userID int64) (*[]domain.Webhook, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.GetWebhooks")
+	defer span.End()
+	ret, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetWebhooks, &userID, o.transactionStorage.IsRetryable)
+	if err != nil {
+		return nil, err
+	}
+	if ret == nil {
+		return nil, ErrNotExists
+	}
+	return ret, nil
+}
+
+func (o *TransactionRepo) DeleteWebhook(ctx context.Context, userID int64, webhookID int64) error {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.DeleteWebhook")
+	defer span.End()
+	webhook := &domain.Webhook{ID: webhookID, UserID: userID}
+	err := retry.DoWithoutReturn(ctx, 3, o.transactionStorage.DeleteWebhook, webhook, o.transactionStorage.IsRetryable)
+	if errors.Is(err, domain.ErrNotFound) {
+		return ErrWebhookNotExists
+	}
+	return err
+}
+
+// GetWebhookDeliveries возвращает журнал последних доставок вебхука пользователя; ErrNotExists - если доставок ещё не было,
+// ErrWebhookNotExists - если вебхука нет или он принадлежит другому пользователю
+func (o *TransactionRepo) GetWebhookDeliveries(ctx context.Context, userID int64, webhookID int64) (*[]domain.WebhookDelivery, error) {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.GetWebhookDeliveries")
+	defer span.End()
+	webhook := &domain.Webhook{ID: webhookID, UserID: userID}
+	ret, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetWebhookDeliveries, webhook, o.transactionStorage.IsRetryable)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, ErrWebhookNotExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ret == nil {
+		return nil, ErrNotExists
+	}
+	return ret, nil
+}
+
+// RunWebhookDispatcher отправляет поставленные в очередь уведомления, не более sendLimit запросов одновременно.
+// Неудачные доставки повторяются с экспоненциальной задержкой, после webhookMaxAttempts попыток доставка считается проваленной
+func (o *TransactionRepo) RunWebhookDispatcher(ctx context.Context, batchLimit int, sendLimit int, interval int) error {
+	ticker := time.NewTicker(time.Second * time.Duration(interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := o.dispatchWebhooks(ctx, batchLimit, sendLimit); err != nil {
+				logger.Log.Error("Dispatch webhooks", zap.Error(err))
+			}
+		case <-ctx.Done():
+			logger.Log.Info("Webhook dispatcher shutting down gracefully")
+			return nil
+		}
+	}
+}
+
+// RunWebhookCleanup удаляет из журнала доставленные и проваленные уведомления, созданные больше retention часов назад;
+// ожидающие доставки уведомления не удаляются
+func (o *TransactionRepo) RunWebhookCleanup(ctx context.Context, retention int) error {
+	ticker := time.NewTicker(outboxCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			before := time.Now().Add(-time.Hour * time.Duration(retention))
+			deleted, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.DeleteDeliveries, &before, o.transactionStorage.IsRetryable)
+			if err != nil {
+				logger.Log.Error("Delete webhook deliveries", zap.Error(err))
+				continue
+			}
+			if deleted > 0 {
+				logger.Log.Info("Webhook deliveries cleaned", zap.Int64("deleted", deleted))
+			}
+		case <-ctx.Done():
+			logger.Log.Info("Webhook cleanup shutting down gracefully")
+			return nil
+		}
+	}
+}
+
+func (o *TransactionRepo) dispatchWebhooks(ctx context.Context, batchLimit int, sendLimit int) error {
+	ctx, span := tracing.Start(ctx, "TransactionRepo.dispatchWebhooks")
+	defer span.End()
+	due, err := retry.DoWithReturn(ctx, 3, o.transactionStorage.GetDueDeliveries, &batchLimit, o.transactionStorage.IsRetryable)
+	if err != nil {
+		return fmt.Errorf("get due deliveries: %w", err)
+	}
+	if due == nil {
+		return nil
+	}
+	g := errgroup.Group{}
+	g.SetLimit(sendLimit)
+	for i := range *due {
+		delivery := &(*due)[i]
+		g.Go(func() error {
+			o.deliverWebhook(ctx, delivery)
+			return retry.DoWithoutReturn(ctx, 3, o.transactionStorage.SaveDeliveryResult, delivery, o.transactionStorage.IsRetryable)
+		})
+	}
+	return g.Wait()
+}
+
+// deliverWebhook выполняет одну попытку доставки и записывает её результат в delivery
+func (o *TransactionRepo) deliverWebhook(ctx context.Context, delivery *domain.WebhookDelivery) {
+	timestamp := time.Now().Unix()
+	resp, err := o.webhookClient.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader("X-Loyalty-Event", delivery.EventType).
+		SetHeader("X-Loyalty-Delivery", strconv.FormatInt(delivery.ID, 10)).
+		SetHeader("X-Loyalty-Timestamp", strconv.FormatInt(timestamp, 10)).
+		SetHeader("X-Loyalty-Signature", security.SignPayload(delivery.Secret, timestamp, delivery.Payload)).
+		SetBody([]byte(delivery.Payload)).
+		Post(delivery.URL)
+	if err == nil && !resp.IsSuccess() {
+		err = fmt.Errorf("unexpected status %d", resp.StatusCode())
+	}
+	delivery.Attempts++
+	now := time.Now()
+	if err == nil {
+		delivered := domain.CustomTime(now)
+		delivery.Status = domain.DeliveryDelivered
+		delivery.DeliveredAt = &delivered
+		delivery.LastError = ""
+		return
+	}
+	logger.Log.Warn("Webhook delivery failed",
+		zap.Int64("delivery", delivery.ID),
+		zap.Int("attempt", delivery.Attempts),
+		zap.Error(err))
+	delivery.LastError = err.Error()
+	if delivery.Attempts >= webhookMaxAttempts {
+		delivery.Status = domain.DeliveryFailed
+		return
+	}
+	backoff := webhookBackoff << (delivery.Attempts - 1)
+	if backoff > webhookMaxBackoff {
+		backoff = webhookMaxBackoff
+	}
+	next := domain.CustomTime(now.Add(backoff))
+	delivery.NextAttemptAt = &next
+}
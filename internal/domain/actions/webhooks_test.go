@@ -0,0 +1,80 @@
+package actions
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"2a00:1450:4010::8a", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"224.0.0.1", false},
+		{"ff02::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"0.1.2.3", false},
+		{"100.64.0.1", false},
+		{"100.100.100.200", false},
+		{"100.127.255.255", false},
+		{"100.128.0.1", true},
+		{"192.0.0.170", false},
+		{"198.18.0.1", false},
+		{"198.19.255.255", false},
+		{"198.20.0.1", true},
+		{"240.0.0.1", false},
+		{"255.255.255.255", false},
+		{"64:ff9b::7f00:1", false},
+		{"64:ff9b:1::a00:1", false},
+		{"2001:0:4136:e378::1", false},
+		{"2002:7f00:1::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:100.100.100.200", false},
+		{"::ffff:8.8.8.8", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			if got := isPublicAddr(netip.MustParseAddr(tt.addr)); got != tt.public {
+				t.Errorf("isPublicAddr(%s) = %v, want %v", tt.addr, got, tt.public)
+			}
+		})
+	}
+	if isPublicAddr(netip.Addr{}) {
+		t.Error("isPublicAddr(zero Addr) = true, want false")
+	}
+}
+
+func TestCheckWebhookHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want error
+	}{
+		{"8.8.8.8", nil},
+		{"2a00:1450:4010::8a", nil},
+		{"127.0.0.1", ErrWebhookAddress},
+		{"100.100.100.200", ErrWebhookAddress},
+		{"64:ff9b::a9fe:a9fe", ErrWebhookAddress},
+		{"localhost", ErrWebhookAddress},
+		{"host.invalid", ErrWebhookURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			if err := checkWebhookHost(context.Background(), tt.host); !errors.Is(err, tt.want) {
+				t.Errorf("checkWebhookHost(%s) = %v, want %v", tt.host, err, tt.want)
+			}
+		})
+	}
+}
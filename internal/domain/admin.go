@@ -0,0 +1,69 @@
+package domain
+
+import "encoding/json"
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+const (
+	AuditSearchUsers   = "users.search"
+	AuditViewUser      = "user.view"
+	AuditViewLedger    = "user.view_ledger"
+	AuditLockUser      = "user.lock"
+	AuditUnlockUser    = "user.unlock"
+	AuditResetOrder    = "order.reset"
+	AuditAdjustBalance = "balance.adjust"
+	AuditViewAuditLog  = "audit.view"
+	AuditExport        = "transactions.export"
+)
+
+// UserInfo - сведения о пользователе для администраторов, без хэша пароля
+type UserInfo struct {
+	ID       int64       `json:"id"`
+	Login    string      `json:"login"`
+	Role     string      `json:"role"`
+	LockedAt *CustomTime `json:"locked_at,omitempty"`
+}
+
+type UserSearch struct {
+	Login string
+	Limit int
+}
+
+// UserRole - роль, которую оператор назначает пользователю с логином Login
+type UserRole struct {
+	Login string
+	Role  string
+}
+
+// UserLock - блокировка пользователя; Audit, если задан, сохраняется в той же транзакции
+type UserLock struct {
+	UserID int64
+	Locked bool
+	Audit  *AuditRecord
+}
+
+// OrderReset - возврат заказа в статус NEW для повторного опроса системы начислений;
+// Audit, если задан, сохраняется в той же транзакции
+type OrderReset struct {
+	Number         string
+	UserID         int64
+	PreviousStatus string
+	Audit          *AuditRecord
+}
+
+type AuditRecord struct {
+	ID         int64           `json:"id"`
+	OperatorID int64           `json:"operator_id"`
+	Action     string          `json:"action"`
+	TargetUser *int64          `json:"target_user,omitempty"`
+	Details    json.RawMessage `json:"details,omitempty"`
+	CreatedAt  CustomTime      `json:"created_at"`
+}
+
+type AuditFilter struct {
+	TargetUser int64
+	Limit      int
+}
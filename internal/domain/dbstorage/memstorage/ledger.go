@@ -0,0 +1,163 @@
+package memstorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"loyalty-system/internal/domain"
+)
+
+// AddAdjustment проводит корректировку баланса; корректировка, после которой баланс стал бы отрицательным, отклоняется
+func (ms *MemStorage) AddAdjustment(ctx context.Context, adjustment *domain.Adjustment) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.users[adjustment.UserID]; !ok {
+		return domain.ErrNotFound
+	}
+	due, expired := ms.dueLots(adjustment.UserID)
+	if ms.balance(adjustment.UserID).Current-expired+adjustment.Amount < 0 {
+		return domain.ErrInsufficientFunds
+	}
+	key := transactionKey{typ: domain.TransactionAdjustment, number: adjustment.Number}
+	if _, ok := ms.transactions[key]; ok {
+		return domain.ErrAlreadyExists
+	}
+	if err := ms.expireLots(adjustment.UserID, due); err != nil {
+		return err
+	}
+	t := &transaction{userID: adjustment.UserID, typ: key.typ, number: adjustment.Number, status: "PROCESSED",
+		amount: adjustment.Amount, uploadedAt: time.Time(adjustment.ProcessedAt), reason: adjustment.Reason,
+		operatorID: adjustment.OperatorID, expiresAt: adjustment.ExpiresAt}
+	if adjustment.Amount > 0 {
+		t.remaining = adjustment.Amount
+	}
+	ms.transactions[key] = t
+	if adjustment.Amount < 0 {
+		ms.consumeLots(adjustment.UserID, -adjustment.Amount)
+	}
+	b := ms.balance(adjustment.UserID)
+	b.Current += adjustment.Amount
+	if err := ms.addEvent(domain.EventPointsAdjusted, adjustment.UserID, adjustment); err != nil {
+		return err
+	}
+	if err := ms.addAudit(adjustment.Audit, adjustment.UserID, adjustment); err != nil {
+		return err
+	}
+	balance := *b
+	return ms.notifyBalance(&balance)
+}
+
+func (ms *MemStorage) GetAllAdjustments(ctx context.Context, filter *domain.ListFilter) (*[]domain.Adjustment, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	rows := ms.list(filter, domain.TransactionAdjustment)
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	ret := make([]domain.Adjustment, 0, len(rows))
+	for _, t := range rows {
+		ret = append(ret, domain.Adjustment{UserID: t.userID, Number: t.number, Amount: t.amount, Reason: t.reason,
+			OperatorID: t.operatorID, ProcessedAt: domain.CustomTime(t.uploadedAt)})
+	}
+	return &ret, nil
+}
+
+// ledger возвращает проведённые операции пользователя от старых к новым с остатком после каждой
+func (ms *MemStorage) ledger(userID int64) ([]*transaction, []domain.LedgerEntry) {
+	rows := ms.chronological(userID)
+	entries := make([]domain.LedgerEntry, 0, len(rows))
+	var balance domain.CustomMoney
+	for _, t := range rows {
+		balance += t.amount
+		entries = append(entries, domain.LedgerEntry{Type: t.typ, Number: t.number, Amount: t.amount, Balance: balance,
+			Reason: t.reason, ProcessedAt: domain.CustomTime(t.processedAt())})
+	}
+	return rows, entries
+}
+
+func (ms *MemStorage) GetLedger(ctx context.Context, filter *domain.ListFilter) (*[]domain.LedgerEntry, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	rows, entries := ms.ledger(filter.UserID)
+	ret := make([]domain.LedgerEntry, 0, 10)
+	for i := len(rows) - 1; i >= 0; i-- {
+		t := rows[i]
+		if !inPeriod(t.processedAt(), filter.From, filter.To) {
+			continue
+		}
+		if filter.Cursor != nil && !processedBefore(t, filter.Cursor.At, filter.Cursor.Number) {
+			continue
+		}
+		ret = append(ret, entries[i])
+		if filter.Limit > 0 && len(ret) == filter.Limit {
+			break
+		}
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+// GetStatement строит выписку за период: входящий остаток, операции в хронологическом порядке и исходящий остаток
+func (ms *MemStorage) GetStatement(ctx context.Context, filter *domain.ListFilter) (*domain.Statement, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	statement := &domain.Statement{UserID: filter.UserID, Movements: []domain.LedgerEntry{}}
+	if filter.From != nil {
+		from := domain.CustomTime(*filter.From)
+		statement.From = &from
+	}
+	if filter.To != nil {
+		to := domain.CustomTime(*filter.To)
+		statement.To = &to
+	}
+	rows, entries := ms.ledger(filter.UserID)
+	for i, t := range rows {
+		if filter.From != nil && t.processedAt().Before(*filter.From) {
+			statement.Opening += t.amount
+			continue
+		}
+		if !inPeriod(t.processedAt(), filter.From, filter.To) {
+			continue
+		}
+		if t.amount > 0 {
+			statement.Credited += t.amount
+		} else {
+			statement.Debited -= t.amount
+		}
+		statement.Movements = append(statement.Movements, entries[i])
+	}
+	statement.Closing = statement.Opening + statement.Credited - statement.Debited
+	return statement, nil
+}
+
+// ExportTransactions передаёт операции в write от старых к новым. Строки копируются под блокировкой,
+// а write вызывается уже без неё, чтобы медленный клиент не останавливал остальные запросы
+func (ms *MemStorage) ExportTransactions(ctx context.Context, filter *domain.ExportFilter, write func(*domain.ExportRow) error) error {
+	ms.mu.Lock()
+	rows := make([]domain.ExportRow, 0, 10)
+	for _, t := range ms.transactions {
+		if filter.UserID != 0 && t.userID != filter.UserID || !inPeriod(t.uploadedAt, filter.From, filter.To) {
+			continue
+		}
+		rows = append(rows, domain.ExportRow{UserID: t.userID, Type: t.typ, Number: t.number, Status: t.status,
+			Amount: t.amount, Reason: t.reason, UploadedAt: domain.CustomTime(t.uploadedAt)})
+	}
+	ms.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool {
+		ti, tj := time.Time(rows[i].UploadedAt), time.Time(rows[j].UploadedAt)
+		return ti.Before(tj) || ti.Equal(tj) && rows[i].Number < rows[j].Number
+	})
+	for i := range rows {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("export: %w", err)
+		}
+		if err := write(&rows[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
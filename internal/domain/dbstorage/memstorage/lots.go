@@ -0,0 +1,129 @@
+package memstorage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"loyalty-system/internal/domain"
+)
+
+// lots возвращает начисления пользователя с непогашенным остатком в порядке погашения: сначала те, что сгорают раньше,
+// бессрочные - последними
+func (ms *MemStorage) lots(userID int64) []*transaction {
+	ret := make([]*transaction, 0, 10)
+	for _, t := range ms.transactions {
+		if t.userID == userID && t.remaining > 0 {
+			ret = append(ret, t)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		a, b := ret[i].expiresAt, ret[j].expiresAt
+		switch {
+		case a == nil && b != nil:
+			return false
+		case a != nil && b == nil:
+			return true
+		case a != nil && !a.Equal(*b):
+			return a.Before(*b)
+		}
+		return before(ret[i], ret[j].uploadedAt, ret[j].number)
+	})
+	return ret
+}
+
+// consumeLots погашает amount из остатков начислений пользователя, начиная с тех, что сгорают раньше
+func (ms *MemStorage) consumeLots(userID int64, amount domain.CustomMoney) {
+	for _, t := range ms.lots(userID) {
+		if amount <= 0 {
+			return
+		}
+		take := min(t.remaining, amount)
+		t.remaining -= take
+		amount -= take
+	}
+}
+
+// dueLots возвращает просроченные начисления пользователя и сумму их остатков
+func (ms *MemStorage) dueLots(userID int64) ([]*transaction, domain.CustomMoney) {
+	now := time.Now()
+	var due []*transaction
+	var amount domain.CustomMoney
+	for _, t := range ms.lots(userID) {
+		if t.expiresAt != nil && !t.expiresAt.After(now) {
+			due = append(due, t)
+			amount += t.remaining
+		}
+	}
+	return due, amount
+}
+
+// expireLots списывает остатки просроченных начислений операциями EXPIRED
+func (ms *MemStorage) expireLots(userID int64, due []*transaction) error {
+	if len(due) == 0 {
+		return nil
+	}
+	expiration := &domain.Expiration{UserID: userID}
+	for _, t := range due {
+		key := transactionKey{typ: domain.TransactionExpired, number: t.typ + "-" + t.number}
+		ms.transactions[key] = &transaction{userID: userID, typ: key.typ, number: key.number, status: "PROCESSED",
+			amount: -t.remaining, uploadedAt: *t.expiresAt}
+		expiration.Amount += t.remaining
+		expiration.Lots++
+		t.remaining = 0
+	}
+	b := ms.balance(userID)
+	b.Current -= expiration.Amount
+	if err := ms.addEvent(domain.EventPointsExpired, userID, expiration); err != nil {
+		return err
+	}
+	balance := *b
+	return ms.notifyBalance(&balance)
+}
+
+// ExpirePoints списывает просроченные баллы не более чем limit пользователей
+func (ms *MemStorage) ExpirePoints(ctx context.Context, limit *int) (*[]domain.Expiration, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	users := make(map[int64]struct{})
+	for _, t := range ms.transactions {
+		if len(users) >= *limit {
+			break
+		}
+		if t.remaining > 0 && t.expiresAt != nil && !t.expiresAt.After(now) {
+			users[t.userID] = struct{}{}
+		}
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	ret := make([]domain.Expiration, 0, len(users))
+	for userID := range users {
+		due, amount := ms.dueLots(userID)
+		if err := ms.expireLots(userID, due); err != nil {
+			return &ret, err
+		}
+		ret = append(ret, domain.Expiration{UserID: userID, Amount: amount, Lots: len(due)})
+	}
+	return &ret, nil
+}
+
+func (ms *MemStorage) GetExpiringPoints(ctx context.Context, filter *domain.ExpiryFilter) (*domain.ExpiringPoints, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var ret *domain.ExpiringPoints
+	for _, t := range ms.lots(filter.UserID) {
+		if t.expiresAt == nil || !t.expiresAt.Before(filter.Before) {
+			continue
+		}
+		if ret == nil {
+			ret = &domain.ExpiringPoints{UserID: filter.UserID, Date: domain.CustomTime(*t.expiresAt)}
+		}
+		if t.expiresAt.Before(time.Time(ret.Date)) {
+			ret.Date = domain.CustomTime(*t.expiresAt)
+		}
+		ret.Amount += t.remaining
+	}
+	return ret, nil
+}
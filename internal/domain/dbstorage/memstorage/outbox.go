@@ -0,0 +1,90 @@
+package memstorage
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"loyalty-system/internal/domain"
+)
+
+// outboxLease - время, на которое публикатор забирает события; если он не отчитается о доставке, события заберут снова
+const outboxLease = 30 * time.Second
+
+// GetPendingEvents забирает в аренду до limit недоставленных событий в порядке их возникновения
+func (ms *MemStorage) GetPendingEvents(ctx context.Context, limit *int) (*[]domain.Event, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	ret := make([]domain.Event, 0, *limit)
+	for _, e := range ms.outbox {
+		if len(ret) >= *limit {
+			break
+		}
+		if e.deliveredAt != nil || e.lockedUntil.After(now) {
+			continue
+		}
+		e.lockedUntil = now.Add(outboxLease)
+		ret = append(ret, e.event)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+func (ms *MemStorage) MarkEventsDelivered(ctx context.Context, ids *[]int64) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	for _, id := range *ids {
+		if e := ms.event(id); e != nil {
+			e.deliveredAt = &now
+			e.attempts++
+			e.lockedUntil = time.Time{}
+		}
+	}
+	return nil
+}
+
+// MarkEventsFailed фиксирует неудачную попытку; события останутся в аренде до её истечения и будут отправлены повторно
+func (ms *MemStorage) MarkEventsFailed(ctx context.Context, failure *domain.EventFailure) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, id := range failure.IDs {
+		if e := ms.event(id); e != nil {
+			e.attempts++
+			e.lastError = failure.Error
+		}
+	}
+	return nil
+}
+
+// DeleteEvents удаляет из outbox доставленные и, если задано, устаревшие недоставленные события
+func (ms *MemStorage) DeleteEvents(ctx context.Context, cleanup *domain.EventCleanup) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	kept := ms.outbox[:0]
+	for _, e := range ms.outbox {
+		delivered := e.deliveredAt != nil && e.deliveredAt.Before(cleanup.DeliveredBefore)
+		stale := e.deliveredAt == nil && cleanup.PendingBefore != nil && time.Time(e.event.CreatedAt).Before(*cleanup.PendingBefore)
+		if !delivered && !stale {
+			kept = append(kept, e)
+		}
+	}
+	deleted := int64(len(ms.outbox) - len(kept))
+	clear(ms.outbox[len(kept):])
+	ms.outbox = kept
+	return deleted, nil
+}
+
+// event находит событие по идентификатору; события хранятся по возрастанию идентификаторов
+func (ms *MemStorage) event(id int64) *outboxEvent {
+	i := sort.Search(len(ms.outbox), func(i int) bool {
+		return ms.outbox[i].event.ID >= id
+	})
+	if i == len(ms.outbox) || ms.outbox[i].event.ID != id {
+		return nil
+	}
+	return ms.outbox[i]
+}
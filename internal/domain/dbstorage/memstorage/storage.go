@@ -0,0 +1,174 @@
+// Package memstorage хранит пользователей и операции в памяти процесса.
+// Реализует те же интерфейсы хранилищ, что и PostgreSQL, и предназначен для тестов и локальной разработки:
+// данные не переживают перезапуск и не разделяются между репликами
+package memstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+)
+
+// listenerBuffer - сколько оповещений копится для медленного слушателя, прежде чем новые начнут отбрасываться
+const listenerBuffer = 256
+
+type transactionKey struct {
+	typ    string
+	number string
+}
+
+// transaction - строка таблицы transactions
+type transaction struct {
+	userID     int64
+	typ        string
+	number     string
+	status     string
+	amount     domain.CustomMoney
+	uploadedAt time.Time
+	reason     string
+	operatorID int64
+	expiresAt  *time.Time
+	remaining  domain.CustomMoney
+	creditedAt *time.Time
+}
+
+// account - строка таблицы users
+type account struct {
+	id       int64
+	login    string
+	hash     string
+	role     string
+	lockedAt *time.Time
+}
+
+type outboxEvent struct {
+	event       domain.Event
+	deliveredAt *time.Time
+	lockedUntil time.Time
+	attempts    int
+	lastError   string
+}
+
+type delivery struct {
+	domain.WebhookDelivery
+	nextAttemptAt time.Time
+}
+
+// MemStorage - потокобезопасное хранилище в памяти. Каждый метод выполняется целиком под одной блокировкой,
+// что соответствует транзакции в PostgreSQL: изменения либо применяются полностью, либо не применяются
+type MemStorage struct {
+	mu sync.Mutex
+
+	users      map[int64]*account
+	logins     map[string]*account
+	nextUserID int64
+	revoked    map[string]time.Time
+	audit      []domain.AuditRecord
+
+	transactions map[transactionKey]*transaction
+	balances     map[int64]*domain.Balance
+	outbox       []*outboxEvent
+	nextEventID  int64
+
+	webhooks       map[int64]*domain.Webhook
+	nextWebhookID  int64
+	deliveries     []*delivery
+	nextDeliveryID int64
+
+	listeners map[chan domain.Event]struct{}
+}
+
+func New() *MemStorage {
+	return &MemStorage{
+		users:        make(map[int64]*account),
+		logins:       make(map[string]*account),
+		revoked:      make(map[string]time.Time),
+		transactions: make(map[transactionKey]*transaction),
+		balances:     make(map[int64]*domain.Balance),
+		webhooks:     make(map[int64]*domain.Webhook),
+		listeners:    make(map[chan domain.Event]struct{}),
+	}
+}
+
+func (ms *MemStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (ms *MemStorage) IsRetryable(err error) bool {
+	return false
+}
+
+// Listen передаёт в events оповещения о событиях до отмены ctx
+func (ms *MemStorage) Listen(ctx context.Context, events chan<- domain.Event) error {
+	sub := make(chan domain.Event, listenerBuffer)
+	ms.mu.Lock()
+	ms.listeners[sub] = struct{}{}
+	ms.mu.Unlock()
+	defer func() {
+		ms.mu.Lock()
+		delete(ms.listeners, sub)
+		ms.mu.Unlock()
+	}()
+	for {
+		select {
+		case event := <-sub:
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// notify рассылает оповещение слушателям; вызывается под блокировкой и не ждёт медленных слушателей
+func (ms *MemStorage) notify(event domain.Event) {
+	for sub := range ms.listeners {
+		select {
+		case sub <- event:
+		default:
+			logger.Log.Warn("Listener is too slow, notification dropped", zap.String("type", event.Type))
+		}
+	}
+}
+
+// addEvent записывает событие в outbox и оповещает о нём слушателей
+func (ms *MemStorage) addEvent(eventType string, userID int64, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+	ms.nextEventID++
+	event := domain.Event{
+		ID:        ms.nextEventID,
+		Type:      eventType,
+		UserID:    userID,
+		Payload:   body,
+		CreatedAt: domain.CustomTime(time.Now()),
+	}
+	ms.outbox = append(ms.outbox, &outboxEvent{event: event})
+	ms.notify(event)
+	return nil
+}
+
+func (ms *MemStorage) notifyBalance(balance *domain.Balance) error {
+	body, err := json.Marshal(balance)
+	if err != nil {
+		return fmt.Errorf("marshal balance: %w", err)
+	}
+	ms.notify(domain.Event{
+		Type:      domain.EventBalanceUpdated,
+		UserID:    balance.UserID,
+		Payload:   body,
+		CreatedAt: domain.CustomTime(time.Now()),
+	})
+	return nil
+}
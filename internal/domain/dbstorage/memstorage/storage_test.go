@@ -0,0 +1,15 @@
+package memstorage_test
+
+import (
+	"testing"
+
+	"loyalty-system/internal/domain/dbstorage/memstorage"
+	"loyalty-system/internal/domain/dbstorage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		ms := memstorage.New()
+		return storagetest.Storage{Users: ms, Transactions: ms}
+	})
+}
@@ -0,0 +1,400 @@
+package memstorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"loyalty-system/internal/domain"
+)
+
+func (ms *MemStorage) AddOrder(ctx context.Context, order *domain.Order) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.users[order.UserID]; !ok {
+		return fmt.Errorf("insert: user %d: %w", order.UserID, domain.ErrNotFound)
+	}
+	key := transactionKey{typ: domain.TransactionOrder, number: order.Number}
+	if _, ok := ms.transactions[key]; ok {
+		return domain.ErrAlreadyExists
+	}
+	t := &transaction{userID: order.UserID, typ: key.typ, number: order.Number, status: order.Status, uploadedAt: time.Time(order.UploadedAt)}
+	if order.Accrual != nil {
+		t.amount = *order.Accrual
+	}
+	ms.transactions[key] = t
+	return ms.addEvent(domain.EventOrderAccepted, order.UserID, &domain.OrderEvent{Number: order.Number, Status: order.Status})
+}
+
+// AddOrders добавляет пачку заказов. Для каждого номера возвращается, принят ли он, а для уже существующих - владелец
+func (ms *MemStorage) AddOrders(ctx context.Context, batch *domain.OrderBatch) (*[]domain.BatchOrder, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.users[batch.UserID]; !ok {
+		return nil, fmt.Errorf("insert orders: user %d: %w", batch.UserID, domain.ErrNotFound)
+	}
+	seen := make(map[string]struct{}, len(batch.Numbers))
+	ret := make([]domain.BatchOrder, 0, len(batch.Numbers))
+	for _, number := range batch.Numbers {
+		if _, ok := seen[number]; ok {
+			continue
+		}
+		seen[number] = struct{}{}
+		key := transactionKey{typ: domain.TransactionOrder, number: number}
+		if t, ok := ms.transactions[key]; ok {
+			ret = append(ret, domain.BatchOrder{Number: number, OwnerID: t.userID})
+			continue
+		}
+		ms.transactions[key] = &transaction{userID: batch.UserID, typ: key.typ, number: number, status: "NEW", uploadedAt: time.Time(batch.UploadedAt)}
+		if err := ms.addEvent(domain.EventOrderAccepted, batch.UserID, &domain.OrderEvent{Number: number, Status: "NEW"}); err != nil {
+			return nil, err
+		}
+		ret = append(ret, domain.BatchOrder{Number: number, Accepted: true, OwnerID: batch.UserID})
+	}
+	return &ret, nil
+}
+
+func (ms *MemStorage) GetOrder(ctx context.Context, orderNumber *string) (*domain.Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	t, ok := ms.transactions[transactionKey{typ: domain.TransactionOrder, number: *orderNumber}]
+	if !ok {
+		return nil, nil
+	}
+	order := t.order()
+	if order.Accrual == nil {
+		// как и PostgreSQL, GetOrder возвращает нулевое начисление, а не его отсутствие
+		amount := domain.CustomMoney(0)
+		order.Accrual = &amount
+	}
+	return &order, nil
+}
+
+func (t *transaction) order() domain.Order {
+	order := domain.Order{UserID: t.userID, Number: t.number, Status: t.status, UploadedAt: domain.CustomTime(t.uploadedAt)}
+	if t.amount != 0 {
+		amount := t.amount
+		order.Accrual = &amount
+	}
+	return order
+}
+
+func (t *transaction) withdraw() domain.Withdraw {
+	return domain.Withdraw{UserID: t.userID, Order: t.number, Sum: -t.amount, ProcessedAt: domain.CustomTime(t.uploadedAt)}
+}
+
+func (ms *MemStorage) GetAllOrders(ctx context.Context, filter *domain.ListFilter) (*[]domain.Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	rows := ms.list(filter, domain.TransactionOrder)
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	ret := make([]domain.Order, 0, len(rows))
+	for _, t := range rows {
+		ret = append(ret, t.order())
+	}
+	return &ret, nil
+}
+
+func (ms *MemStorage) GetAllWithdraw(ctx context.Context, filter *domain.ListFilter) (*[]domain.Withdraw, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	rows := ms.list(filter, domain.TransactionWithdraw)
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	ret := make([]domain.Withdraw, 0, len(rows))
+	for _, t := range rows {
+		ret = append(ret, t.withdraw())
+	}
+	return &ret, nil
+}
+
+func (ms *MemStorage) GetWithdraw(ctx context.Context, orderNumber *string) (*domain.Withdraw, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	t, ok := ms.transactions[transactionKey{typ: domain.TransactionWithdraw, number: *orderNumber}]
+	if !ok {
+		return nil, nil
+	}
+	withdraw := t.withdraw()
+	return &withdraw, nil
+}
+
+// list отбирает операции пользователя типа typ (любого при пустом typ) по правилам выборки списков:
+// от новых к старым, с фильтрами по статусу и времени, после курсора и не более filter.Limit
+func (ms *MemStorage) list(filter *domain.ListFilter, typ string) []*transaction {
+	statuses := make(map[string]struct{}, len(filter.Statuses))
+	for _, v := range filter.Statuses {
+		statuses[v] = struct{}{}
+	}
+	ret := make([]*transaction, 0, 10)
+	for _, t := range ms.transactions {
+		if t.userID != filter.UserID || (typ != "" && t.typ != typ) {
+			continue
+		}
+		if _, ok := statuses[t.status]; len(statuses) > 0 && !ok {
+			continue
+		}
+		if !inPeriod(t.uploadedAt, filter.From, filter.To) {
+			continue
+		}
+		if filter.Cursor != nil && !before(t, filter.Cursor.At, filter.Cursor.Number) {
+			continue
+		}
+		ret = append(ret, t)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return before(ret[j], ret[i].uploadedAt, ret[i].number)
+	})
+	if filter.Limit > 0 && len(ret) > filter.Limit {
+		ret = ret[:filter.Limit]
+	}
+	return ret
+}
+
+// before сообщает, предшествует ли операция позиции (at, number) в порядке (uploaded_at, number)
+func before(t *transaction, at time.Time, number string) bool {
+	return t.uploadedAt.Before(at) || t.uploadedAt.Equal(at) && t.number < number
+}
+
+func inPeriod(at time.Time, from, to *time.Time) bool {
+	return (from == nil || !at.Before(*from)) && (to == nil || at.Before(*to))
+}
+
+// processedAt - время проведения операции: для заказа время зачисления баллов, для остальных время создания
+func (t *transaction) processedAt() time.Time {
+	if t.creditedAt != nil {
+		return *t.creditedAt
+	}
+	return t.uploadedAt
+}
+
+// processedBefore сравнивает операции по времени проведения, как before - по времени создания
+func processedBefore(t *transaction, at time.Time, number string) bool {
+	processed := t.processedAt()
+	return processed.Before(at) || processed.Equal(at) && t.number < number
+}
+
+// chronological возвращает проведённые операции пользователя от старых к новым
+func (ms *MemStorage) chronological(userID int64) []*transaction {
+	ret := make([]*transaction, 0, 10)
+	for _, t := range ms.transactions {
+		if t.userID == userID && t.status == "PROCESSED" {
+			ret = append(ret, t)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return processedBefore(ret[i], ret[j].processedAt(), ret[j].number)
+	})
+	return ret
+}
+
+func (ms *MemStorage) GetBalance(ctx context.Context, UserID *int64) (*domain.Balance, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ret := domain.Balance{UserID: *UserID}
+	if b, ok := ms.balances[*UserID]; ok {
+		ret.Current, ret.Withdrawn = b.Current, b.Withdrawn
+	}
+	return &ret, nil
+}
+
+// balance возвращает строку баланса пользователя, при необходимости создавая её
+func (ms *MemStorage) balance(userID int64) *domain.Balance {
+	b, ok := ms.balances[userID]
+	if !ok {
+		b = &domain.Balance{UserID: userID}
+		ms.balances[userID] = b
+	}
+	return b
+}
+
+// AddWithdraw проверяет баланс и списывает средства. Просроченные баллы сгорают до проверки
+func (ms *MemStorage) AddWithdraw(ctx context.Context, withdraw *domain.Withdraw) error {
+	// как ограничение transactions_withdraw_amount_check в PostgreSQL
+	if withdraw.Sum <= 0 {
+		return domain.ErrInvalidAmount
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.users[withdraw.UserID]; !ok {
+		return fmt.Errorf("lock balance: user %d: %w", withdraw.UserID, domain.ErrNotFound)
+	}
+	due, expired := ms.dueLots(withdraw.UserID)
+	if ms.balance(withdraw.UserID).Current-expired < withdraw.Sum {
+		return domain.ErrInsufficientFunds
+	}
+	key := transactionKey{typ: domain.TransactionWithdraw, number: withdraw.Order}
+	if _, ok := ms.transactions[key]; ok {
+		return domain.ErrAlreadyExists
+	}
+	if err := ms.expireLots(withdraw.UserID, due); err != nil {
+		return err
+	}
+	ms.transactions[key] = &transaction{userID: withdraw.UserID, typ: key.typ, number: withdraw.Order, status: "PROCESSED",
+		amount: -withdraw.Sum, uploadedAt: time.Time(withdraw.ProcessedAt)}
+	ms.consumeLots(withdraw.UserID, withdraw.Sum)
+	b := ms.balance(withdraw.UserID)
+	b.Current -= withdraw.Sum
+	b.Withdrawn += withdraw.Sum
+	if err := ms.addEvent(domain.EventPointsWithdrawn, withdraw.UserID, withdraw); err != nil {
+		return err
+	}
+	balance := *b
+	return ms.notifyBalance(&balance)
+}
+
+func (ms *MemStorage) GetUnprocessedOrders(ctx context.Context, batchLimit *int) (*[]domain.Order, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	rows := make([]*transaction, 0, *batchLimit)
+	for _, t := range ms.transactions {
+		if t.typ == domain.TransactionOrder && (t.status == "NEW" || t.status == "PROCESSING" || t.status == "REGISTERED") {
+			rows = append(rows, t)
+		}
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return rows[i].uploadedAt.Before(rows[j].uploadedAt)
+	})
+	if len(rows) > *batchLimit {
+		rows = rows[:*batchLimit]
+	}
+	ret := make([]domain.Order, 0, len(rows))
+	for _, t := range rows {
+		ret = append(ret, domain.Order{UserID: t.userID, Number: t.number, Status: t.status, UploadedAt: domain.CustomTime(t.uploadedAt)})
+	}
+	return &ret, nil
+}
+
+func (ms *MemStorage) SetProcessedAccruals(ctx context.Context, orders *[]domain.Accrual) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for _, v := range *orders {
+		t, ok := ms.transactions[transactionKey{typ: domain.TransactionOrder, number: v.Order}]
+		if !ok || t.status == "PROCESSED" || t.status == "INVALID" {
+			// заказ уже в финальном статусе
+			continue
+		}
+		amount := domain.CustomMoney(0)
+		if v.Sum != nil {
+			amount = *v.Sum
+		}
+		previousStatus := t.status
+		t.status = v.Status
+		t.amount = amount
+		t.remaining, t.creditedAt = 0, nil
+		if v.Status == "PROCESSED" {
+			now := time.Now()
+			t.remaining, t.creditedAt = amount, &now
+		}
+		t.expiresAt = v.ExpiresAt
+		if previousStatus != v.Status {
+			event := &domain.OrderEvent{Number: v.Order, Status: v.Status, PreviousStatus: previousStatus}
+			if err := ms.addEvent(domain.EventOrderStatusChanged, t.userID, event); err != nil {
+				return err
+			}
+			event.Accrual = &amount
+			if err := ms.addWebhookDeliveries(domain.NewStatusChangedEvent(t.userID, *event, time.Now())); err != nil {
+				return err
+			}
+		}
+		if v.Status != "PROCESSED" || amount == 0 {
+			continue
+		}
+		b := ms.balance(t.userID)
+		b.Current += amount
+		event := &domain.OrderEvent{Number: v.Order, Status: v.Status, Accrual: &amount}
+		if err := ms.addEvent(domain.EventOrderAccrued, t.userID, event); err != nil {
+			return err
+		}
+		balance := *b
+		if err := ms.notifyBalance(&balance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ms *MemStorage) CountOrdersByStatus(ctx context.Context) (map[string]int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ret := make(map[string]int64)
+	for _, t := range ms.transactions {
+		if t.typ == domain.TransactionOrder {
+			ret[t.status]++
+		}
+	}
+	return ret, nil
+}
+
+// ReconcileBalances пересчитывает балансы по операциям и возвращает расхождения. При repair расходящиеся балансы исправляются
+func (ms *MemStorage) ReconcileBalances(ctx context.Context, repair *bool) (*[]domain.BalanceDrift, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	actual := make(map[int64]*domain.Balance)
+	for _, t := range ms.transactions {
+		if t.status != "PROCESSED" {
+			continue
+		}
+		b, ok := actual[t.userID]
+		if !ok {
+			b = &domain.Balance{UserID: t.userID}
+			actual[t.userID] = b
+		}
+		b.Current += t.amount
+		if t.typ == domain.TransactionWithdraw {
+			b.Withdrawn -= t.amount
+		}
+	}
+	for userID := range ms.balances {
+		if _, ok := actual[userID]; !ok {
+			actual[userID] = &domain.Balance{UserID: userID}
+		}
+	}
+	ret := make([]domain.BalanceDrift, 0)
+	for userID, a := range actual {
+		stored := domain.Balance{}
+		if b, ok := ms.balances[userID]; ok {
+			stored = *b
+		}
+		if stored.Current == a.Current && stored.Withdrawn == a.Withdrawn {
+			continue
+		}
+		ret = append(ret, domain.BalanceDrift{UserID: userID, StoredCurrent: stored.Current, StoredWithdrawn: stored.Withdrawn,
+			ActualCurrent: a.Current, ActualWithdrawn: a.Withdrawn})
+		if *repair {
+			b := ms.balance(userID)
+			b.Current, b.Withdrawn = a.Current, a.Withdrawn
+		}
+	}
+	return &ret, nil
+}
+
+// ResetOrder возвращает заказ в статус NEW. Заказ PROCESSED не сбрасывается: начисление уже зачислено на баланс
+func (ms *MemStorage) ResetOrder(ctx context.Context, reset *domain.OrderReset) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	t, ok := ms.transactions[transactionKey{typ: domain.TransactionOrder, number: reset.Number}]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	reset.UserID, reset.PreviousStatus = t.userID, t.status
+	if t.status == "PROCESSED" {
+		return domain.ErrOrderProcessed
+	}
+	t.status, t.amount = "NEW", 0
+	if reset.PreviousStatus != "NEW" {
+		event := &domain.OrderEvent{Number: reset.Number, Status: "NEW", PreviousStatus: reset.PreviousStatus}
+		if err := ms.addEvent(domain.EventOrderStatusChanged, reset.UserID, event); err != nil {
+			return err
+		}
+	}
+	details := map[string]string{"number": reset.Number, "previous_status": reset.PreviousStatus}
+	return ms.addAudit(reset.Audit, reset.UserID, details)
+}
@@ -0,0 +1,200 @@
+package memstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"loyalty-system/internal/domain"
+)
+
+func (ms *MemStorage) GetUser(ctx context.Context, login *string) (*domain.User, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	u, ok := ms.logins[*login]
+	if !ok {
+		return nil, nil
+	}
+	return &domain.User{UserID: u.id, Login: u.login, Hash: u.hash, Role: u.role, Locked: u.lockedAt != nil}, nil
+}
+
+func (ms *MemStorage) AddUser(ctx context.Context, user *domain.User) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if _, ok := ms.logins[user.Login]; ok {
+		return domain.ErrAlreadyExists
+	}
+	ms.nextUserID++
+	u := &account{id: ms.nextUserID, login: user.Login, hash: user.Hash, role: user.Role}
+	if u.role == "" {
+		u.role = domain.RoleUser
+	}
+	ms.users[u.id] = u
+	ms.logins[u.login] = u
+	return nil
+}
+
+func (ms *MemStorage) UpdateHash(ctx context.Context, user *domain.User) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if u, ok := ms.users[user.UserID]; ok {
+		u.hash = user.Hash
+	}
+	return nil
+}
+
+func (ms *MemStorage) RevokeToken(ctx context.Context, token *domain.RevokedToken) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	for jti, expiresAt := range ms.revoked {
+		if expiresAt.Before(now) {
+			delete(ms.revoked, jti)
+		}
+	}
+	if _, ok := ms.revoked[token.ID]; ok {
+		return domain.ErrAlreadyExists
+	}
+	ms.revoked[token.ID] = token.ExpiresAt
+	return nil
+}
+
+func (ms *MemStorage) IsTokenRevoked(ctx context.Context, jti *string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	_, ok := ms.revoked[*jti]
+	return ok, nil
+}
+
+func (ms *MemStorage) GetUserInfo(ctx context.Context, userID *int64) (*domain.UserInfo, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	u, ok := ms.users[*userID]
+	if !ok {
+		return nil, nil
+	}
+	return u.info(), nil
+}
+
+// SearchUsers ищет пользователей по подстроке логина без учёта регистра
+func (ms *MemStorage) SearchUsers(ctx context.Context, search *domain.UserSearch) (*[]domain.UserInfo, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	pattern := strings.ToLower(search.Login)
+	ret := make([]domain.UserInfo, 0, search.Limit)
+	for _, u := range ms.users {
+		if strings.Contains(strings.ToLower(u.login), pattern) {
+			ret = append(ret, *u.info())
+		}
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Login < ret[j].Login
+	})
+	if len(ret) > search.Limit {
+		ret = ret[:search.Limit]
+	}
+	return &ret, nil
+}
+
+func (u *account) info() *domain.UserInfo {
+	info := &domain.UserInfo{ID: u.id, Login: u.login, Role: u.role}
+	if u.lockedAt != nil {
+		t := domain.CustomTime(*u.lockedAt)
+		info.LockedAt = &t
+	}
+	return info
+}
+
+func (ms *MemStorage) IsUserLocked(ctx context.Context, userID *int64) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	u, ok := ms.users[*userID]
+	return ok && u.lockedAt != nil, nil
+}
+
+// SetLocked блокирует или разблокирует пользователя; повторная блокировка сохраняет исходное время
+func (ms *MemStorage) SetLocked(ctx context.Context, lock *domain.UserLock) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	u, ok := ms.users[lock.UserID]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	switch {
+	case !lock.Locked:
+		u.lockedAt = nil
+	case u.lockedAt == nil:
+		now := time.Now()
+		u.lockedAt = &now
+	}
+	return ms.addAudit(lock.Audit, lock.UserID, nil)
+}
+
+func (ms *MemStorage) SetRole(ctx context.Context, role *domain.UserRole) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	u, ok := ms.logins[role.Login]
+	if !ok {
+		return domain.ErrNotFound
+	}
+	u.role = role.Role
+	return nil
+}
+
+func (ms *MemStorage) AddAudit(ctx context.Context, record *domain.AuditRecord) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.appendAudit(record)
+	return nil
+}
+
+// addAudit дополняет запись журнала пользователем target и подробностями details и сохраняет её вместе с действием.
+// Без записи ничего не делает
+func (ms *MemStorage) addAudit(record *domain.AuditRecord, target int64, details any) error {
+	if record == nil {
+		return nil
+	}
+	record.TargetUser = &target
+	if details != nil {
+		body, err := json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("marshal audit details: %w", err)
+		}
+		record.Details = body
+	}
+	ms.appendAudit(record)
+	return nil
+}
+
+func (ms *MemStorage) appendAudit(record *domain.AuditRecord) {
+	record.ID = int64(len(ms.audit) + 1)
+	record.CreatedAt = domain.CustomTime(time.Now())
+	if len(record.Details) == 0 {
+		record.Details = []byte("{}")
+	}
+	ms.audit = append(ms.audit, *record)
+}
+
+// GetAudit возвращает последние записи журнала, при filter.TargetUser != 0 - только по этому пользователю
+func (ms *MemStorage) GetAudit(ctx context.Context, filter *domain.AuditFilter) (*[]domain.AuditRecord, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ret := make([]domain.AuditRecord, 0, filter.Limit)
+	for i := len(ms.audit) - 1; i >= 0 && len(ret) < filter.Limit; i-- {
+		record := ms.audit[i]
+		if filter.TargetUser != 0 && (record.TargetUser == nil || *record.TargetUser != filter.TargetUser) {
+			continue
+		}
+		ret = append(ret, record)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
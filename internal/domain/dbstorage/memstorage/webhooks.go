@@ -0,0 +1,212 @@
+package memstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"loyalty-system/internal/domain"
+)
+
+// deliveryLease - время, на которое рассыльщик забирает доставку; если он не сохранит результат, доставку заберут снова
+const deliveryLease = time.Minute
+
+// deliveryLogLimit - сколько последних доставок показывается в журнале вебхука
+const deliveryLogLimit = 50
+
+func (ms *MemStorage) AddWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	registered := 0
+	for _, v := range ms.webhooks {
+		if v.UserID != webhook.UserID {
+			continue
+		}
+		if v.URL == webhook.URL {
+			return domain.ErrAlreadyExists
+		}
+		registered++
+	}
+	if registered >= domain.MaxWebhooksPerUser {
+		return domain.ErrWebhookLimit
+	}
+	ms.nextWebhookID++
+	webhook.ID = ms.nextWebhookID
+	webhook.CreatedAt = domain.CustomTime(time.Now())
+	stored := *webhook
+	ms.webhooks[stored.ID] = &stored
+	return nil
+}
+
+func (ms *MemStorage) GetWebhooks(ctx context.Context, userID *int64) (*[]domain.Webhook, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ret := make([]domain.Webhook, 0, 10)
+	for _, v := range ms.webhooks {
+		if v.UserID == *userID {
+			webhook := *v
+			webhook.Secret = ""
+			ret = append(ret, webhook)
+		}
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return &ret, nil
+}
+
+func (ms *MemStorage) DeleteWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	v, ok := ms.webhooks[webhook.ID]
+	if !ok || v.UserID != webhook.UserID {
+		return domain.ErrNotFound
+	}
+	delete(ms.webhooks, webhook.ID)
+	kept := ms.deliveries[:0]
+	for _, d := range ms.deliveries {
+		if d.WebhookID != webhook.ID {
+			kept = append(kept, d)
+		}
+	}
+	ms.deliveries = kept
+	return nil
+}
+
+// GetWebhookDeliveries возвращает последние доставки вебхука, принадлежащего webhook.UserID;
+// domain.ErrNotFound - если такого вебхука у пользователя нет
+func (ms *MemStorage) GetWebhookDeliveries(ctx context.Context, webhook *domain.Webhook) (*[]domain.WebhookDelivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if v, ok := ms.webhooks[webhook.ID]; !ok || v.UserID != webhook.UserID {
+		return nil, domain.ErrNotFound
+	}
+	ret := make([]domain.WebhookDelivery, 0, deliveryLogLimit)
+	for i := len(ms.deliveries) - 1; i >= 0 && len(ret) < deliveryLogLimit; i-- {
+		d := ms.deliveries[i]
+		if d.WebhookID != webhook.ID {
+			continue
+		}
+		delivery := d.WebhookDelivery
+		delivery.URL, delivery.Secret = "", ""
+		if delivery.Status == domain.DeliveryPending {
+			next := domain.CustomTime(d.nextAttemptAt)
+			delivery.NextAttemptAt = &next
+		}
+		ret = append(ret, delivery)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+// addWebhookDeliveries ставит событие в очередь доставки на каждый вебхук его пользователя; вызывается под ms.mu
+// вместе со сменой статуса заказа
+func (ms *MemStorage) addWebhookDeliveries(event *domain.WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal webhook event: %w", err)
+	}
+	for _, webhook := range ms.webhooks {
+		if webhook.UserID != event.UserID {
+			continue
+		}
+		ms.nextDeliveryID++
+		ms.deliveries = append(ms.deliveries, &delivery{
+			WebhookDelivery: domain.WebhookDelivery{
+				ID:        ms.nextDeliveryID,
+				WebhookID: webhook.ID,
+				EventType: event.Event,
+				Payload:   body,
+				Status:    domain.DeliveryPending,
+				CreatedAt: event.OccurredAt,
+			},
+			nextAttemptAt: time.Time(event.OccurredAt),
+		})
+	}
+	return nil
+}
+
+// GetDueDeliveries забирает в аренду до limit доставок, время попытки которых наступило
+func (ms *MemStorage) GetDueDeliveries(ctx context.Context, limit *int) (*[]domain.WebhookDelivery, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	due := make([]*delivery, 0, *limit)
+	for _, d := range ms.deliveries {
+		if d.Status == domain.DeliveryPending && !d.nextAttemptAt.After(now) {
+			due = append(due, d)
+		}
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].nextAttemptAt.Before(due[j].nextAttemptAt)
+	})
+	if len(due) > *limit {
+		due = due[:*limit]
+	}
+	ret := make([]domain.WebhookDelivery, 0, len(due))
+	for _, d := range due {
+		d.nextAttemptAt = now.Add(deliveryLease)
+		delivery := d.WebhookDelivery
+		webhook := ms.webhooks[d.WebhookID]
+		delivery.URL, delivery.Secret = webhook.URL, webhook.Secret
+		ret = append(ret, delivery)
+	}
+	return &ret, nil
+}
+
+// SaveDeliveryResult сохраняет результат попытки доставки и обновляет статистику вебхука
+func (ms *MemStorage) SaveDeliveryResult(ctx context.Context, result *domain.WebhookDelivery) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := domain.CustomTime(time.Now())
+	for _, d := range ms.deliveries {
+		if d.ID != result.ID {
+			continue
+		}
+		d.Status, d.Attempts, d.LastError, d.DeliveredAt = result.Status, result.Attempts, result.LastError, result.DeliveredAt
+		if result.NextAttemptAt != nil {
+			d.nextAttemptAt = time.Time(*result.NextAttemptAt)
+		}
+		break
+	}
+	webhook, ok := ms.webhooks[result.WebhookID]
+	if !ok {
+		return nil
+	}
+	if result.Status == domain.DeliveryDelivered {
+		webhook.LastSuccessAt = &now
+		webhook.Failures = 0
+		return nil
+	}
+	webhook.LastErrorAt = &now
+	webhook.LastError = result.LastError
+	webhook.Failures++
+	return nil
+}
+
+// DeleteDeliveries удаляет доставленные и проваленные уведомления, созданные раньше olderThan
+func (ms *MemStorage) DeleteDeliveries(ctx context.Context, olderThan *time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	kept := ms.deliveries[:0]
+	for _, d := range ms.deliveries {
+		done := d.Status == domain.DeliveryDelivered || d.Status == domain.DeliveryFailed
+		if !done || !time.Time(d.CreatedAt).Before(*olderThan) {
+			kept = append(kept, d)
+		}
+	}
+	deleted := int64(len(ms.deliveries) - len(kept))
+	clear(ms.deliveries[len(kept):])
+	ms.deliveries = kept
+	return deleted, nil
+}
@@ -0,0 +1,285 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/pkg/logger"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// lockID - ключ advisory lock, под которым применяются миграции, чтобы несколько реплик не мигрировали БД одновременно
+const lockID = 4_263_911_072
+
+var ErrUnknownVersion = errors.New("database has migrations unknown to this build")
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+func Load() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %q", name)
+		}
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionStr, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file %q has no name", name)
+		}
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %q: parse version: %w", name, err)
+		}
+		body, err := files.ReadFile(path.Join("sql", name))
+		if err != nil {
+			return nil, fmt.Errorf("read %q: %w", name, err)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+		if m.Name != title {
+			return nil, fmt.Errorf("migration %d has different names: %q and %q", version, m.Name, title)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+	ret := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up script", m.Version)
+		}
+		ret = append(ret, *m)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Version < ret[j].Version
+	})
+	return ret, nil
+}
+
+// Up применяет все ещё не применённые миграции и возвращает их количество
+func Up(ctx context.Context, db *sql.DB) (int, error) {
+	migrations, err := Load()
+	if err != nil {
+		return 0, fmt.Errorf("load migrations: %w", err)
+	}
+	applied := 0
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err = checkKnown(migrations, versions); err != nil {
+			return err
+		}
+		for _, m := range migrations {
+			if _, ok := versions[m.Version]; ok {
+				continue
+			}
+			const insertSQL = `insert into schema_migrations (version, name) values ($1,$2)`
+			if err = apply(ctx, conn, m.Up, insertSQL, m.Version, m.Name); err != nil {
+				return fmt.Errorf("apply %d_%s: %w", m.Version, m.Name, err)
+			}
+			logger.Log.Info("Migration applied", zap.Int64("version", m.Version), zap.String("name", m.Name))
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down откатывает steps последних применённых миграций и возвращает количество откаченных
+func Down(ctx context.Context, db *sql.DB, steps int) (int, error) {
+	migrations, err := Load()
+	if err != nil {
+		return 0, fmt.Errorf("load migrations: %w", err)
+	}
+	reverted := 0
+	err = withLock(ctx, db, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err = checkKnown(migrations, versions); err != nil {
+			return err
+		}
+		for i := len(migrations) - 1; i >= 0 && reverted < steps; i-- {
+			m := migrations[i]
+			if _, ok := versions[m.Version]; !ok {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+			}
+			const deleteSQL = `delete from schema_migrations where version = $1`
+			if err = apply(ctx, conn, m.Down, deleteSQL, m.Version); err != nil {
+				return fmt.Errorf("revert %d_%s: %w", m.Version, m.Name, err)
+			}
+			logger.Log.Info("Migration reverted", zap.Int64("version", m.Version), zap.String("name", m.Name))
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// GetStatus возвращает все известные миграции; у неприменённых AppliedAt == nil
+func GetStatus(ctx context.Context, db *sql.DB) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, fmt.Errorf("load migrations: %w", err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get connection: %w", err)
+	}
+	defer conn.Close()
+	versions, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]Status, 0, len(migrations))
+	for _, m := range migrations {
+		s := Status{Version: m.Version, Name: m.Name}
+		if appliedAt, ok := versions[m.Version]; ok {
+			s.AppliedAt = &appliedAt
+		}
+		ret = append(ret, s)
+	}
+	return ret, checkKnown(migrations, versions)
+}
+
+// Pending возвращает количество известных этой сборке, но ещё не применённых миграций
+func Pending(ctx context.Context, db *sql.DB) (int, error) {
+	statuses, err := GetStatus(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	pending := 0
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func withLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("advisory lock: %w", err)
+	}
+	defer func() {
+		// контекст мог быть уже отменён, а блокировку нужно снять в любом случае
+		if _, err := conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, lockID); err != nil {
+			logger.Log.Error("Advisory unlock failed", zap.Error(err))
+		}
+	}()
+	const createTableSQL = `create table IF NOT EXISTS schema_migrations (
+    							version bigint PRIMARY KEY,
+    							name text not null,
+    							applied_at TIMESTAMP with time zone not null default CURRENT_TIMESTAMP)`
+	if _, err = conn.ExecContext(ctx, createTableSQL); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	const existsSQL = `select to_regclass('schema_migrations') is not null`
+	var exists bool
+	if err := conn.QueryRowContext(ctx, existsSQL).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("check schema_migrations: %w", err)
+	}
+	ret := make(map[int64]time.Time)
+	if !exists {
+		return ret, nil
+	}
+	rows, err := conn.QueryContext(ctx, `select version, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("select schema_migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("scan schema_migrations: %w", err)
+		}
+		ret[version] = appliedAt
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("select schema_migrations: %w", err)
+	}
+	return ret, nil
+}
+
+func checkKnown(migrations []Migration, versions map[int64]time.Time) error {
+	known := make(map[int64]struct{}, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = struct{}{}
+	}
+	for v := range versions {
+		if _, ok := known[v]; !ok {
+			return fmt.Errorf("version %d: %w", v, ErrUnknownVersion)
+		}
+	}
+	return nil
+}
+
+func apply(ctx context.Context, conn *sql.Conn, script string, bookkeepingSQL string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("open transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return fmt.Errorf("exec script: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, bookkeepingSQL, args...); err != nil {
+		return fmt.Errorf("update schema_migrations: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,2 @@
+drop table IF EXISTS transactions;
+drop table IF EXISTS users;
@@ -0,0 +1,18 @@
+create table IF NOT EXISTS users (
+    id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    login text not null unique,
+    hash text not null
+);
+
+create table IF NOT EXISTS transactions (
+    userid int references users(id) not null,
+    type text not null,
+    number text not null,
+    status text not null,
+    amount bigint not null default 0,
+    uploaded_at TIMESTAMP with time zone not null default CURRENT_TIMESTAMP
+);
+
+CREATE index IF NOT EXISTS user_status_ix ON transactions (userid,status);
+CREATE unique index IF NOT EXISTS number_type_uix ON transactions (number,type);
+CREATE index IF NOT EXISTS user_type_ix ON transactions (userid,type);
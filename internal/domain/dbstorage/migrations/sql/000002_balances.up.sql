@@ -0,0 +1,15 @@
+create table IF NOT EXISTS balances (
+    userid int PRIMARY KEY references users(id),
+    current bigint not null default 0,
+    withdrawn bigint not null default 0,
+    updated_at TIMESTAMP with time zone not null default CURRENT_TIMESTAMP
+);
+
+insert into balances (userid, current, withdrawn)
+select userid,
+       COALESCE(sum(amount),0),
+       COALESCE(sum(case when type = 'WITHDRAW' then -1*amount else 0 end),0)
+from transactions
+where status = 'PROCESSED'
+group by userid
+on conflict (userid) do nothing;
@@ -0,0 +1 @@
+drop table IF EXISTS revoked_tokens;
@@ -0,0 +1,8 @@
+create table IF NOT EXISTS revoked_tokens (
+    jti text PRIMARY KEY,
+    userid int references users(id) not null,
+    expires_at TIMESTAMP with time zone not null,
+    revoked_at TIMESTAMP with time zone not null default CURRENT_TIMESTAMP
+);
+
+CREATE index IF NOT EXISTS revoked_tokens_expires_ix ON revoked_tokens (expires_at);
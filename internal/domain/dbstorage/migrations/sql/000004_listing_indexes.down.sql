@@ -0,0 +1 @@
+drop index IF EXISTS user_type_uploaded_ix;
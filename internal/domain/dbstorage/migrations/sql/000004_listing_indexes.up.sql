@@ -0,0 +1 @@
+CREATE index IF NOT EXISTS user_type_uploaded_ix ON transactions (userid,type,uploaded_at DESC,number DESC);
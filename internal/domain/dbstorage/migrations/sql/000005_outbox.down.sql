@@ -0,0 +1 @@
+drop table IF EXISTS outbox;
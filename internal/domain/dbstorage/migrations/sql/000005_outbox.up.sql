@@ -0,0 +1,13 @@
+create table IF NOT EXISTS outbox (
+    id bigint GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    event_type text not null,
+    userid int not null,
+    payload jsonb not null,
+    created_at TIMESTAMP with time zone not null default CURRENT_TIMESTAMP,
+    attempts int not null default 0,
+    last_error text,
+    locked_until TIMESTAMP with time zone,
+    delivered_at TIMESTAMP with time zone
+);
+
+CREATE index IF NOT EXISTS outbox_pending_ix ON outbox (id) WHERE delivered_at IS NULL;
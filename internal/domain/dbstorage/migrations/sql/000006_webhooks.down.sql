@@ -0,0 +1,2 @@
+drop table IF EXISTS webhook_deliveries;
+drop table IF EXISTS webhooks;
@@ -0,0 +1,28 @@
+create table IF NOT EXISTS webhooks (
+    id bigint GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    userid int not null,
+    url text not null,
+    secret text not null,
+    created_at TIMESTAMP with time zone not null default CURRENT_TIMESTAMP,
+    last_success_at TIMESTAMP with time zone,
+    last_error_at TIMESTAMP with time zone,
+    last_error text,
+    failures int not null default 0,
+    UNIQUE (userid, url)
+);
+
+create table IF NOT EXISTS webhook_deliveries (
+    id bigint GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    webhook_id bigint not null REFERENCES webhooks (id) ON DELETE CASCADE,
+    event_type text not null,
+    payload jsonb not null,
+    status text not null default 'PENDING',
+    attempts int not null default 0,
+    next_attempt_at TIMESTAMP with time zone not null default CURRENT_TIMESTAMP,
+    last_error text,
+    created_at TIMESTAMP with time zone not null default CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP with time zone
+);
+
+CREATE index IF NOT EXISTS webhook_deliveries_pending_ix ON webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+CREATE index IF NOT EXISTS webhook_deliveries_webhook_ix ON webhook_deliveries (webhook_id, id DESC);
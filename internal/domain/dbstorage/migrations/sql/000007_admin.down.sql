@@ -0,0 +1,3 @@
+drop table IF EXISTS admin_audit;
+alter table users drop column IF EXISTS locked_at;
+alter table users drop column IF EXISTS role;
@@ -0,0 +1,13 @@
+alter table users add column IF NOT EXISTS role text not null default 'user';
+alter table users add column IF NOT EXISTS locked_at TIMESTAMP with time zone;
+
+create table IF NOT EXISTS admin_audit (
+    id bigint GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    operator_id int not null,
+    action text not null,
+    target_user int,
+    details jsonb not null default '{}'::jsonb,
+    created_at TIMESTAMP with time zone not null default CURRENT_TIMESTAMP
+);
+
+CREATE index IF NOT EXISTS admin_audit_target_ix ON admin_audit (target_user, id DESC);
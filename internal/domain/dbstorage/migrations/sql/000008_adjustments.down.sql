@@ -0,0 +1,9 @@
+delete from transactions where type = 'ADJUSTMENT';
+-- без удалённых корректировок балансы пересчитываются по оставшимся операциям, как при создании balances
+update balances b
+set current = COALESCE((select sum(amount) from transactions t where t.userid = b.userid and t.status = 'PROCESSED'),0),
+    withdrawn = COALESCE((select sum(-1*amount) from transactions t
+                          where t.userid = b.userid and t.status = 'PROCESSED' and t.type = 'WITHDRAW'),0),
+    updated_at = CURRENT_TIMESTAMP;
+alter table transactions drop column IF EXISTS operator_id;
+alter table transactions drop column IF EXISTS reason;
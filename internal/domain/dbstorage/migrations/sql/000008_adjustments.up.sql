@@ -0,0 +1,2 @@
+alter table transactions add column IF NOT EXISTS reason text;
+alter table transactions add column IF NOT EXISTS operator_id int references users(id);
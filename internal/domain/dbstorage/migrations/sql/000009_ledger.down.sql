@@ -0,0 +1 @@
+drop index IF EXISTS user_status_uploaded_ix;
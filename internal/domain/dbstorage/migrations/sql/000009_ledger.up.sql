@@ -0,0 +1 @@
+CREATE index IF NOT EXISTS user_status_uploaded_ix ON transactions (userid,status,uploaded_at,number);
@@ -0,0 +1 @@
+drop index IF EXISTS uploaded_ix;
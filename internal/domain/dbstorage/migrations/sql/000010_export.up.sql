@@ -0,0 +1 @@
+CREATE index IF NOT EXISTS uploaded_ix ON transactions (uploaded_at,number);
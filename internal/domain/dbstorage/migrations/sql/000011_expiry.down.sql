@@ -0,0 +1,11 @@
+delete from transactions where type = 'EXPIRED';
+-- без удалённых операций балансы пересчитываются по оставшимся, как при создании balances
+update balances b
+set current = COALESCE((select sum(amount) from transactions t where t.userid = b.userid and t.status = 'PROCESSED'),0),
+    withdrawn = COALESCE((select sum(-1*amount) from transactions t
+                          where t.userid = b.userid and t.status = 'PROCESSED' and t.type = 'WITHDRAW'),0),
+    updated_at = CURRENT_TIMESTAMP;
+drop index IF EXISTS lots_expiry_ix;
+drop index IF EXISTS lots_user_ix;
+alter table transactions drop column IF EXISTS remaining;
+alter table transactions drop column IF EXISTS expires_at;
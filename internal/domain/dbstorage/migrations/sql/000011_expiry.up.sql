@@ -0,0 +1,22 @@
+alter table transactions add column IF NOT EXISTS expires_at TIMESTAMP with time zone;
+alter table transactions add column IF NOT EXISTS remaining bigint not null default 0;
+
+-- остатки существующих начислений: списания погашают начисления в порядке поступления.
+-- Срок действия у них не задаётся, такие баллы не сгорают
+with lots as (
+    select type, number, userid, amount,
+           sum(amount) over (partition by userid order by uploaded_at, number rows unbounded preceding) total
+    from transactions
+    where status = 'PROCESSED' and amount > 0),
+spent as (
+    select userid, -1*sum(amount) spent
+    from transactions
+    where status = 'PROCESSED' and amount < 0
+    group by userid)
+update transactions t
+set remaining = greatest(0, least(l.amount, l.total - COALESCE(s.spent,0)))
+from lots l left join spent s on s.userid = l.userid
+where t.type = l.type and t.number = l.number;
+
+CREATE index IF NOT EXISTS lots_user_ix ON transactions (userid,uploaded_at,number) where remaining > 0;
+CREATE index IF NOT EXISTS lots_expiry_ix ON transactions (expires_at) where remaining > 0;
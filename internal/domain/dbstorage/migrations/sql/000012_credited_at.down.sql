@@ -0,0 +1 @@
+alter table transactions drop column IF EXISTS credited_at;
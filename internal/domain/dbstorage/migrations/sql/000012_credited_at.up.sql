@@ -0,0 +1,3 @@
+-- время зачисления баллов по заказу: журнал и выписка датируют начисление им, а не временем загрузки заказа.
+-- Для заказов, рассчитанных раньше, время зачисления неизвестно - они датируются временем загрузки
+alter table transactions add column IF NOT EXISTS credited_at TIMESTAMP with time zone;
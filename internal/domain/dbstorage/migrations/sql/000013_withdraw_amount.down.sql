@@ -0,0 +1 @@
+alter table transactions drop constraint IF EXISTS transactions_withdraw_amount_check;
@@ -0,0 +1,3 @@
+-- списание хранится с отрицательной суммой: нулевое или положительное списание увеличило бы баланс.
+-- NOT VALID - ограничение проверяет новые строки, не перечитывая уже сохранённые
+alter table transactions add constraint transactions_withdraw_amount_check check (type <> 'WITHDRAW' or amount < 0) NOT VALID;
@@ -0,0 +1,119 @@
+package pgtransactions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+// AddAdjustment проводит корректировку баланса. Как и при списании, строка баланса блокируется;
+// корректировка, после которой текущий баланс стал бы отрицательным, отклоняется
+func (ms *PGOrdersStorage) AddAdjustment(ctx context.Context, adjustment *domain.Adjustment) error {
+	defer metrics.ObserveQuery("AddAdjustment", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.AddAdjustment")
+	defer span.End()
+	tx, err := ms.dbConnections.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("open transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := lockBalance(ctx, tx, adjustment.UserID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.ForeignKeyViolation {
+			return domain.ErrNotFound
+		}
+		return err
+	}
+	expiration, err := expireLots(ctx, tx, adjustment.UserID)
+	if err != nil {
+		return err
+	}
+	current -= expiration.Amount
+	if current+adjustment.Amount < 0 {
+		return domain.ErrInsufficientFunds
+	}
+	// положительная корректировка становится новым начислением со своим сроком действия
+	const insertSQL = `insert into transactions (userid,type,number,status,amount,uploaded_at,reason,operator_id,remaining,expires_at)
+                       values ($1,'ADJUSTMENT',$2,'PROCESSED',$3,$4,$5,$6,greatest($3::bigint,0),$7)`
+	_, err = tx.ExecContext(ctx, insertSQL, adjustment.UserID, adjustment.Number, adjustment.Amount,
+		time.Time(adjustment.ProcessedAt), adjustment.Reason, adjustment.OperatorID, adjustment.ExpiresAt)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return domain.ErrAlreadyExists
+		}
+		logger.Log.Error("Insert adjustment failed", zap.Error(err))
+		return fmt.Errorf("insert: %w", err)
+	}
+	if adjustment.Amount < 0 {
+		if err = consumeLots(ctx, tx, adjustment.UserID, -adjustment.Amount); err != nil {
+			return err
+		}
+	}
+	const updateBalanceSQL = `update balances set current = current + $2, updated_at = CURRENT_TIMESTAMP where userid = $1
+                              returning current, withdrawn`
+	balance := &domain.Balance{UserID: adjustment.UserID}
+	err = tx.QueryRowContext(ctx, updateBalanceSQL, adjustment.UserID, adjustment.Amount).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		logger.Log.Error("Update balance failed", zap.Error(err))
+		return fmt.Errorf("update balance: %w", err)
+	}
+	err = addEvent(ctx, tx, domain.EventPointsAdjusted, adjustment.UserID, adjustment)
+	if err != nil {
+		return err
+	}
+	err = addAudit(ctx, tx, adjustment.Audit, adjustment.UserID, adjustment)
+	if err != nil {
+		return err
+	}
+	err = notifyBalance(ctx, tx, balance)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ms *PGOrdersStorage) GetAllAdjustments(ctx context.Context, filter *domain.ListFilter) (*[]domain.Adjustment, error) {
+	defer metrics.ObserveQuery("GetAllAdjustments", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetAllAdjustments")
+	defer span.End()
+	const selectSQL = `select userid,number,amount,coalesce(reason,''),coalesce(operator_id,0),uploaded_at
+                       from transactions where userid = $1 and type = 'ADJUSTMENT'`
+	query, args := listQuery(selectSQL, filter)
+	rows, err := ms.dbConnections.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Log.Error("Select all adjustments", zap.Error(err))
+		return nil, fmt.Errorf("select all adjustments: %w", err)
+	}
+	defer rows.Close()
+	adjustment := domain.Adjustment{}
+	ret := make([]domain.Adjustment, 0, 10)
+	for rows.Next() {
+		err = rows.Scan(&adjustment.UserID, &adjustment.Number, &adjustment.Amount, &adjustment.Reason,
+			&adjustment.OperatorID, &adjustment.ProcessedAt)
+		if err != nil {
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return nil, err
+		}
+		ret = append(ret, adjustment)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select all adjustments", zap.Error(err))
+		return nil, fmt.Errorf("select all adjustments: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
@@ -0,0 +1,37 @@
+package pgtransactions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+)
+
+// addAudit дополняет запись журнала администратора пользователем target и подробностями details и сохраняет её
+// в транзакции действия, чтобы действие не выполнилось без записи в журнале. Без записи ничего не делает
+func addAudit(ctx context.Context, tx *sql.Tx, record *domain.AuditRecord, target int64, details any) error {
+	if record == nil {
+		return nil
+	}
+	body, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("marshal audit details: %w", err)
+	}
+	record.TargetUser, record.Details = &target, body
+	// журнал ведёт хранилище пользователей, но таблица в той же БД
+	const insertSQL = `insert into admin_audit (operator_id,action,target_user,details) values ($1,$2,$3,$4) returning id, created_at`
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, insertSQL, record.OperatorID, record.Action, record.TargetUser, string(body)).
+		Scan(&record.ID, &createdAt)
+	if err != nil {
+		logger.Log.Error("Insert audit failed", zap.Error(err))
+		return fmt.Errorf("insert audit: %w", err)
+	}
+	record.CreatedAt = domain.CustomTime(createdAt)
+	return nil
+}
@@ -0,0 +1,169 @@
+package pgtransactions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+// Начисления (заказы и положительные корректировки) хранят непогашенный остаток в remaining и срок действия в expires_at.
+// Списания погашают сначала остатки, которые сгорают раньше, бессрочные - последними; просроченные остатки списываются
+// операциями EXPIRED.
+// Все изменения остатков выполняются под блокировкой строки баланса, поэтому sum(remaining) совпадает с balances.current
+
+// consumeLots погашает amount из остатков начислений пользователя, начиная с тех, что сгорают раньше
+func consumeLots(ctx context.Context, tx *sql.Tx, userID int64, amount domain.CustomMoney) error {
+	const updateSQL = `update transactions t set remaining = t.remaining - c.take
+                       from (select type, number,
+                                    least(remaining, greatest(0, $2::bigint - (sum(remaining) over (order by expires_at nulls last, uploaded_at, number rows unbounded preceding) - remaining))) take
+                             from transactions
+                             where userid = $1 and remaining > 0) c
+                       where t.type = c.type and t.number = c.number and c.take > 0`
+	_, err := tx.ExecContext(ctx, updateSQL, userID, amount)
+	if err != nil {
+		logger.Log.Error("Consume lots failed", zap.Error(err))
+		return fmt.Errorf("consume lots: %w", err)
+	}
+	return nil
+}
+
+// expireLots списывает просроченные остатки начислений пользователя. Строка баланса должна быть заблокирована
+func expireLots(ctx context.Context, tx *sql.Tx, userID int64) (*domain.Expiration, error) {
+	const expireSQL = `with due as (
+                           select type, number, remaining, expires_at from transactions
+                           where userid = $1 and remaining > 0 and expires_at <= CURRENT_TIMESTAMP
+                           for update),
+                       spent as (
+                           update transactions t set remaining = 0 from due
+                           where t.type = due.type and t.number = due.number)
+                       insert into transactions (userid,type,number,status,amount,uploaded_at)
+                       select $1,'EXPIRED',due.type || '-' || due.number,'PROCESSED',-1*due.remaining,due.expires_at
+                       from due
+                       returning -1*amount`
+	rows, err := tx.QueryContext(ctx, expireSQL, userID)
+	if err != nil {
+		logger.Log.Error("Expire lots failed", zap.Error(err))
+		return nil, fmt.Errorf("expire lots: %w", err)
+	}
+	defer rows.Close()
+	expiration := &domain.Expiration{UserID: userID}
+	for rows.Next() {
+		var amount domain.CustomMoney
+		if err = rows.Scan(&amount); err != nil {
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		expiration.Amount += amount
+		expiration.Lots++
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Expire lots failed", zap.Error(err))
+		return nil, fmt.Errorf("expire lots: %w", err)
+	}
+	if expiration.Lots == 0 {
+		return expiration, nil
+	}
+	const updateBalanceSQL = `update balances set current = current - $2, updated_at = CURRENT_TIMESTAMP where userid = $1
+                              returning current, withdrawn`
+	balance := &domain.Balance{UserID: userID}
+	err = tx.QueryRowContext(ctx, updateBalanceSQL, userID, expiration.Amount).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		logger.Log.Error("Update balance failed", zap.Error(err))
+		return nil, fmt.Errorf("update balance: %w", err)
+	}
+	if err = addEvent(ctx, tx, domain.EventPointsExpired, userID, expiration); err != nil {
+		return nil, err
+	}
+	if err = notifyBalance(ctx, tx, balance); err != nil {
+		return nil, err
+	}
+	return expiration, nil
+}
+
+// ExpirePoints списывает просроченные баллы не более чем limit пользователей, каждого в своей транзакции
+func (ms *PGOrdersStorage) ExpirePoints(ctx context.Context, limit *int) (*[]domain.Expiration, error) {
+	defer metrics.ObserveQuery("ExpirePoints", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.ExpirePoints")
+	defer span.End()
+	const selectSQL = `select distinct userid from transactions
+                       where remaining > 0 and expires_at <= CURRENT_TIMESTAMP
+                       limit $1`
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, limit)
+	if err != nil {
+		logger.Log.Error("Select expired lots", zap.Error(err))
+		return nil, fmt.Errorf("select expired lots: %w", err)
+	}
+	users := make([]int64, 0, *limit)
+	for rows.Next() {
+		var userID int64
+		if err = rows.Scan(&userID); err != nil {
+			rows.Close()
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		users = append(users, userID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select expired lots", zap.Error(err))
+		return nil, fmt.Errorf("select expired lots: %w", err)
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	ret := make([]domain.Expiration, 0, len(users))
+	for _, userID := range users {
+		expiration, err := ms.expireUser(ctx, userID)
+		if err != nil {
+			return &ret, err
+		}
+		if expiration.Lots > 0 {
+			ret = append(ret, *expiration)
+		}
+	}
+	return &ret, nil
+}
+
+func (ms *PGOrdersStorage) expireUser(ctx context.Context, userID int64) (*domain.Expiration, error) {
+	tx, err := ms.dbConnections.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = lockBalance(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	expiration, err := expireLots(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return expiration, tx.Commit()
+}
+
+func (ms *PGOrdersStorage) GetExpiringPoints(ctx context.Context, filter *domain.ExpiryFilter) (*domain.ExpiringPoints, error) {
+	defer metrics.ObserveQuery("GetExpiringPoints", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetExpiringPoints")
+	defer span.End()
+	const selectSQL = `select COALESCE(sum(remaining),0)::bigint, min(expires_at) from transactions
+                       where userid = $1 and remaining > 0 and expires_at < $2`
+	ret := domain.ExpiringPoints{UserID: filter.UserID}
+	var date sql.NullTime
+	err := ms.dbConnections.QueryRowContext(ctx, selectSQL, filter.UserID, filter.Before).Scan(&ret.Amount, &date)
+	if err != nil {
+		logger.Log.Error("Select expiring points", zap.Error(err))
+		return nil, fmt.Errorf("select expiring points: %w", err)
+	}
+	if !date.Valid {
+		return nil, nil
+	}
+	ret.Date = domain.CustomTime(date.Time)
+	return &ret, nil
+}
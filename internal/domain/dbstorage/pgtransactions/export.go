@@ -0,0 +1,62 @@
+package pgtransactions
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+// ExportTransactions передаёт операции в write по одной по мере чтения из курсора, не загружая выборку в память.
+// Ошибка write прерывает выгрузку
+func (ms *PGOrdersStorage) ExportTransactions(ctx context.Context, filter *domain.ExportFilter, write func(*domain.ExportRow) error) error {
+	defer metrics.ObserveQuery("ExportTransactions", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.ExportTransactions")
+	defer span.End()
+	args := []any{}
+	var query strings.Builder
+	query.WriteString(`select userid,type,number,status,amount,coalesce(reason,''),uploaded_at from transactions where true`)
+	if filter.UserID != 0 {
+		args = append(args, filter.UserID)
+		fmt.Fprintf(&query, " and userid = $%d", len(args))
+	}
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		fmt.Fprintf(&query, " and uploaded_at >= $%d", len(args))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		fmt.Fprintf(&query, " and uploaded_at < $%d", len(args))
+	}
+	query.WriteString(" order by uploaded_at, number")
+	rows, err := ms.dbConnections.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		logger.Log.Error("Select export", zap.Error(err))
+		return fmt.Errorf("select export: %w", err)
+	}
+	defer rows.Close()
+	row := domain.ExportRow{}
+	for rows.Next() {
+		var uploadedAt time.Time
+		err = rows.Scan(&row.UserID, &row.Type, &row.Number, &row.Status, &row.Amount, &row.Reason, &uploadedAt)
+		if err != nil {
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return fmt.Errorf("scan rows: %w", err)
+		}
+		row.UploadedAt = domain.CustomTime(uploadedAt)
+		if err = write(&row); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select export", zap.Error(err))
+		return fmt.Errorf("select export: %w", err)
+	}
+	return nil
+}
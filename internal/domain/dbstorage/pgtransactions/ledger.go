@@ -0,0 +1,127 @@
+package pgtransactions
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+// ledgerSQL - проведённые операции пользователя с нарастающим итогом. Итог считается по всем операциям,
+// поэтому фильтры и курсор накладываются снаружи и не меняют баланс в строках. Заказ датируется временем
+// зачисления баллов, а рассчитанный до появления credited_at - временем загрузки
+const ledgerSQL = `select type,number,amount,balance,reason,uploaded_at from (
+                       select type,number,amount,reason,uploaded_at,
+                              (sum(amount) over (order by uploaded_at, number rows unbounded preceding))::bigint balance
+                       from (select type,number,amount,coalesce(reason,'') reason,coalesce(credited_at,uploaded_at) uploaded_at
+                             from transactions where userid = $1 and status = 'PROCESSED') p) l
+                   where true`
+
+func (ms *PGOrdersStorage) GetLedger(ctx context.Context, filter *domain.ListFilter) (*[]domain.LedgerEntry, error) {
+	defer metrics.ObserveQuery("GetLedger", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetLedger")
+	defer span.End()
+	query, args := listQuery(ledgerSQL, filter)
+	rows, err := ms.dbConnections.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Log.Error("Select ledger", zap.Error(err))
+		return nil, fmt.Errorf("select ledger: %w", err)
+	}
+	defer rows.Close()
+	ret, err := scanLedger(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+// GetStatement строит выписку за период: входящий остаток, операции в хронологическом порядке и исходящий остаток.
+// Оба запроса выполняются в одном снимке данных, чтобы остатки сходились с операциями
+func (ms *PGOrdersStorage) GetStatement(ctx context.Context, filter *domain.ListFilter) (*domain.Statement, error) {
+	defer metrics.ObserveQuery("GetStatement", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetStatement")
+	defer span.End()
+	tx, err := ms.dbConnections.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("open transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	statement := &domain.Statement{UserID: filter.UserID, Movements: []domain.LedgerEntry{}}
+	if filter.From != nil {
+		from := domain.CustomTime(*filter.From)
+		statement.From = &from
+		const openingSQL = `select coalesce(sum(amount),0)::bigint from transactions
+                            where userid = $1 and status = 'PROCESSED' and coalesce(credited_at,uploaded_at) < $2`
+		err = tx.QueryRowContext(ctx, openingSQL, filter.UserID, *filter.From).Scan(&statement.Opening)
+		if err != nil {
+			logger.Log.Error("Select opening balance", zap.Error(err))
+			return nil, fmt.Errorf("select opening balance: %w", err)
+		}
+	}
+	args := []any{filter.UserID}
+	query := ledgerSQL
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		query += fmt.Sprintf(" and uploaded_at >= $%d", len(args))
+	}
+	if filter.To != nil {
+		to := domain.CustomTime(*filter.To)
+		statement.To = &to
+		args = append(args, *filter.To)
+		query += fmt.Sprintf(" and uploaded_at < $%d", len(args))
+	}
+	query += " order by uploaded_at, number"
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Log.Error("Select statement", zap.Error(err))
+		return nil, fmt.Errorf("select statement: %w", err)
+	}
+	defer rows.Close()
+	movements, err := scanLedger(rows)
+	if err != nil {
+		return nil, err
+	}
+	statement.Closing = statement.Opening
+	for _, entry := range movements {
+		if entry.Amount > 0 {
+			statement.Credited += entry.Amount
+		} else {
+			statement.Debited -= entry.Amount
+		}
+		statement.Closing += entry.Amount
+	}
+	if len(movements) > 0 {
+		statement.Movements = movements
+	}
+	return statement, tx.Commit()
+}
+
+func scanLedger(rows *sql.Rows) ([]domain.LedgerEntry, error) {
+	ret := make([]domain.LedgerEntry, 0, 10)
+	for rows.Next() {
+		entry := domain.LedgerEntry{}
+		var processedAt time.Time
+		err := rows.Scan(&entry.Type, &entry.Number, &entry.Amount, &entry.Balance, &entry.Reason, &processedAt)
+		if err != nil {
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		entry.ProcessedAt = domain.CustomTime(processedAt)
+		ret = append(ret, entry)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Log.Error("Select ledger", zap.Error(err))
+		return nil, fmt.Errorf("select ledger: %w", err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,77 @@
+package pgtransactions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+)
+
+// notifyChannel - канал NOTIFY, через который реплики узнают о событиях, зафиксированных любой из них
+const notifyChannel = "loyalty_events"
+
+// notify отправляет событие в notifyChannel; PostgreSQL доставит его слушателям только после фиксации транзакции
+func notify(ctx context.Context, tx *sql.Tx, event *domain.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal notification: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, `select pg_notify($1, $2)`, notifyChannel, string(body))
+	if err != nil {
+		logger.Log.Error("Notify failed", zap.Error(err))
+		return fmt.Errorf("notify: %w", err)
+	}
+	return nil
+}
+
+func notifyBalance(ctx context.Context, tx *sql.Tx, balance *domain.Balance) error {
+	body, err := json.Marshal(balance)
+	if err != nil {
+		return fmt.Errorf("marshal balance: %w", err)
+	}
+	event := &domain.Event{
+		Type:      domain.EventBalanceUpdated,
+		UserID:    balance.UserID,
+		Payload:   body,
+		CreatedAt: domain.CustomTime(time.Now()),
+	}
+	return notify(ctx, tx, event)
+}
+
+// Listen подписывается на notifyChannel отдельным соединением и передаёт полученные события в events.
+// Возвращает управление при отмене ctx или обрыве соединения
+func (ms *PGOrdersStorage) Listen(ctx context.Context, events chan<- domain.Event) error {
+	conn, err := pgx.Connect(ctx, ms.dsn)
+	if err != nil {
+		return fmt.Errorf("connect: %w", err)
+	}
+	defer conn.Close(context.Background())
+	if _, err = conn.Exec(ctx, "listen "+notifyChannel); err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("wait for notification: %w", err)
+		}
+		event := domain.Event{}
+		if err = json.Unmarshal([]byte(n.Payload), &event); err != nil {
+			logger.Log.Error("Unmarshal notification", zap.Error(err))
+			continue
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
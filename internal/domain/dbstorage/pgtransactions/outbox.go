@@ -0,0 +1,130 @@
+package pgtransactions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+// outboxLease - время, на которое публикатор забирает события; если он не отчитается о доставке, события заберут снова
+const outboxLease = 30 * time.Second
+
+// addEvent записывает событие в outbox в той же транзакции, что и изменение состояния,
+// и оповещает о нём подписчиков LISTEN после фиксации транзакции
+func addEvent(ctx context.Context, tx *sql.Tx, eventType string, userID int64, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+	const insertSQL = `insert into outbox (event_type,userid,payload) values ($1,$2,$3) returning id, created_at`
+	event := &domain.Event{Type: eventType, UserID: userID, Payload: body}
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, insertSQL, eventType, userID, string(body)).Scan(&event.ID, &createdAt)
+	if err != nil {
+		logger.Log.Error("Insert event failed", zap.Error(err))
+		return fmt.Errorf("insert event: %w", err)
+	}
+	event.CreatedAt = domain.CustomTime(createdAt)
+	return notify(ctx, tx, event)
+}
+
+// GetPendingEvents забирает в аренду до limit недоставленных событий в порядке их возникновения.
+// Параллельные публикаторы (в том числе на других репликах) получают разные события
+func (ms *PGOrdersStorage) GetPendingEvents(ctx context.Context, limit *int) (*[]domain.Event, error) {
+	defer metrics.ObserveQuery("GetPendingEvents", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetPendingEvents")
+	defer span.End()
+	const updateSQL = `update outbox set locked_until = CURRENT_TIMESTAMP + $2 * interval '1 millisecond'
+                       where id in (select id from outbox
+                                    where delivered_at is null and (locked_until is null or locked_until < CURRENT_TIMESTAMP)
+                                    order by id
+                                    limit $1
+                                    for update skip locked)
+                       returning id,event_type,userid,payload,created_at`
+	rows, err := ms.dbConnections.QueryContext(ctx, updateSQL, limit, outboxLease.Milliseconds())
+	if err != nil {
+		logger.Log.Error("Select events", zap.Error(err))
+		return nil, fmt.Errorf("select events: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.Event, 0, *limit)
+	for rows.Next() {
+		event := domain.Event{}
+		var payload string
+		var createdAt time.Time
+		err = rows.Scan(&event.ID, &event.Type, &event.UserID, &payload, &createdAt)
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		event.Payload = json.RawMessage(payload)
+		event.CreatedAt = domain.CustomTime(createdAt)
+		ret = append(ret, event)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select events", zap.Error(err))
+		return nil, fmt.Errorf("select events: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	// returning не гарантирует порядок строк
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return &ret, nil
+}
+
+func (ms *PGOrdersStorage) MarkEventsDelivered(ctx context.Context, ids *[]int64) error {
+	defer metrics.ObserveQuery("MarkEventsDelivered", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.MarkEventsDelivered")
+	defer span.End()
+	const updateSQL = `update outbox set delivered_at = CURRENT_TIMESTAMP, attempts = attempts + 1, locked_until = null where id = any($1)`
+	_, err := ms.dbConnections.ExecContext(ctx, updateSQL, *ids)
+	if err != nil {
+		logger.Log.Error("Update events failed", zap.Error(err))
+		return fmt.Errorf("update events: %w", err)
+	}
+	return nil
+}
+
+// DeleteEvents удаляет из outbox доставленные и, если задано, устаревшие недоставленные события
+func (ms *PGOrdersStorage) DeleteEvents(ctx context.Context, cleanup *domain.EventCleanup) (int64, error) {
+	defer metrics.ObserveQuery("DeleteEvents", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.DeleteEvents")
+	defer span.End()
+	const deleteSQL = `delete from outbox where delivered_at < $1 or (delivered_at is null and created_at < $2)`
+	res, err := ms.dbConnections.ExecContext(ctx, deleteSQL, cleanup.DeliveredBefore, cleanup.PendingBefore)
+	if err != nil {
+		logger.Log.Error("Delete events failed", zap.Error(err))
+		return 0, fmt.Errorf("delete events: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	return deleted, nil
+}
+
+// MarkEventsFailed фиксирует неудачную попытку; события останутся в аренде до её истечения и будут отправлены повторно
+func (ms *PGOrdersStorage) MarkEventsFailed(ctx context.Context, failure *domain.EventFailure) error {
+	defer metrics.ObserveQuery("MarkEventsFailed", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.MarkEventsFailed")
+	defer span.End()
+	const updateSQL = `update outbox set attempts = attempts + 1, last_error = $2 where id = any($1)`
+	_, err := ms.dbConnections.ExecContext(ctx, updateSQL, failure.IDs, failure.Error)
+	if err != nil {
+		logger.Log.Error("Update events failed", zap.Error(err))
+		return fmt.Errorf("update events: %w", err)
+	}
+	return nil
+}
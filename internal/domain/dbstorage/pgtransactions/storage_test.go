@@ -0,0 +1,68 @@
+package pgtransactions_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+
+	"loyalty-system/internal/domain/dbstorage/migrations"
+	"loyalty-system/internal/domain/dbstorage/pgtransactions"
+	"loyalty-system/internal/domain/dbstorage/pgusers"
+	"loyalty-system/internal/domain/dbstorage/storagetest"
+	"loyalty-system/pkg/postgresql"
+)
+
+// TestConformance проверяет хранилище на базе из TEST_DATABASE_URI; все таблицы базы очищаются перед каждой проверкой
+func TestConformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URI")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URI не задан")
+	}
+	ctx := context.Background()
+	db, err := postgresql.NewConn(dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err = migrations.Up(ctx, db); err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+	users, err := pgusers.NewUserStorage(ctx, dsn)
+	if err != nil {
+		t.Fatalf("user storage: %v", err)
+	}
+	transactions, err := pgtransactions.NewOrdersStorage(ctx, dsn)
+	if err != nil {
+		t.Fatalf("orders storage: %v", err)
+	}
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		truncate(t, db)
+		return storagetest.Storage{Users: users, Transactions: transactions}
+	})
+}
+
+func truncate(t *testing.T, db *sql.DB) {
+	t.Helper()
+	const selectSQL = `select tablename from pg_tables where schemaname = current_schema() and tablename <> 'schema_migrations'`
+	rows, err := db.Query(selectSQL)
+	if err != nil {
+		t.Fatalf("select tables: %v", err)
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			t.Fatalf("scan tables: %v", err)
+		}
+		tables = append(tables, table)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatalf("select tables: %v", err)
+	}
+	if _, err = db.Exec(`truncate ` + strings.Join(tables, ",") + ` restart identity cascade`); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+}
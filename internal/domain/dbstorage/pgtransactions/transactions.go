@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgerrcode"
@@ -12,11 +13,14 @@ import (
 	"go.uber.org/zap"
 	"loyalty-system/internal/domain"
 	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
 	"loyalty-system/pkg/postgresql"
+	"loyalty-system/pkg/tracing"
 )
 
 type PGOrdersStorage struct {
 	dbConnections *sql.DB
+	dsn           string
 }
 
 func NewOrdersStorage(ctx context.Context, dsn string) (*PGOrdersStorage, error) {
@@ -25,51 +29,98 @@ func NewOrdersStorage(ctx context.Context, dsn string) (*PGOrdersStorage, error)
 		logger.Log.Error("Get db connection failed", zap.Error(err))
 		return nil, err
 	}
-	s := &PGOrdersStorage{dbConnections: dbCon}
-	const createTableSQL = `create table IF NOT EXISTS transactions (
-    							userid int references users(id) not null, 
-    							type text not null, 
-    							number text not null ,
-    							status text not null, 
-    							amount bigint not null default 0, 
-    							uploaded_at TIMESTAMP with time zone not null default CURRENT_TIMESTAMP)`
-	_, err = s.dbConnections.ExecContext(ctx, createTableSQL)
-	if err != nil {
-		logger.Log.Error("Create table failed", zap.Error(err))
-		return nil, err
-	}
-	const createIndexUserStatusSQL = `CREATE index IF NOT EXISTS user_status_ix ON transactions (userid,status)`
-	_, err = s.dbConnections.ExecContext(ctx, createIndexUserStatusSQL)
+	return &PGOrdersStorage{dbConnections: dbCon, dsn: dsn}, nil
+}
+
+// DB возвращает пул соединений хранилища, через который применяются и проверяются миграции
+func (ms *PGOrdersStorage) DB() *sql.DB {
+	return ms.dbConnections
+}
+
+func (ms *PGOrdersStorage) AddOrder(ctx context.Context, order *domain.Order) error {
+	defer metrics.ObserveQuery("AddOrder", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.AddOrder")
+	defer span.End()
+	tx, err := ms.dbConnections.BeginTx(ctx, nil)
 	if err != nil {
-		logger.Log.Error("Create ix_id_orders failed", zap.Error(err))
-		return nil, err
+		return fmt.Errorf("open transaction: %w", err)
 	}
-	const createIndexNumberTypeSQL = `CREATE unique index IF NOT EXISTS number_type_uix ON transactions (number,type)`
-	_, err = s.dbConnections.ExecContext(ctx, createIndexNumberTypeSQL)
+	defer tx.Rollback()
+
+	const insertSQL = `insert into transactions (userid,type,number,status,amount,uploaded_at) values ($1,'ORDER',$2,$3,$4,$5)`
+	_, err = tx.ExecContext(ctx, insertSQL, order.UserID, order.Number, order.Status, order.Accrual, time.Time(order.UploadedAt))
 	if err != nil {
-		logger.Log.Error("Create ix_id_orders failed", zap.Error(err))
-		return nil, err
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return domain.ErrAlreadyExists
+		}
+		logger.Log.Error("Insert order failed", zap.Error(err))
+		return fmt.Errorf("insert: %w", err)
 	}
-	const createIndexUserTypeSQL = `CREATE index IF NOT EXISTS user_type_ix ON transactions (userid,type)`
-	_, err = s.dbConnections.ExecContext(ctx, createIndexUserTypeSQL)
+	err = addEvent(ctx, tx, domain.EventOrderAccepted, order.UserID, &domain.OrderEvent{Number: order.Number, Status: order.Status})
 	if err != nil {
-		logger.Log.Error("Create ix_id_orders failed", zap.Error(err))
-		return nil, err
+		return err
 	}
-	return s, nil
+	return tx.Commit()
 }
 
-func (ms *PGOrdersStorage) AddOrder(ctx context.Context, order *domain.Order) error {
-	const insertSQL = `insert into transactions (userid,type,number,status,amount,uploaded_at) values ($1,'ORDER',$2,$3,$4,$5)`
-	_, err := ms.dbConnections.ExecContext(ctx, insertSQL, order.UserID, order.Number, order.Status, order.Accrual, time.Time(order.UploadedAt))
+// AddOrders вставляет пачку заказов одним запросом вместе с событиями outbox и оповещениями о них.
+// Для каждого номера возвращается, принят ли он, а для уже существующих - владелец
+func (ms *PGOrdersStorage) AddOrders(ctx context.Context, batch *domain.OrderBatch) (*[]domain.BatchOrder, error) {
+	defer metrics.ObserveQuery("AddOrders", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.AddOrders")
+	defer span.End()
+	// CTE без ссылок на неё не выполняется, поэтому notified присоединяется к итоговой выборке
+	const insertSQL = `with input as (
+                           select distinct number from unnest($1::text[]) as number
+                       ), inserted as (
+                           insert into transactions (userid,type,number,status,amount,uploaded_at)
+                           select $2::int,'ORDER',number,'NEW',0,$3::timestamptz from input
+                           on conflict do nothing
+                           returning number
+                       ), events as (
+                           insert into outbox (event_type,userid,payload)
+                           select $4::text, $2::int, jsonb_build_object('number', number, 'status', 'NEW') from inserted
+                           returning id,event_type,userid,payload,created_at
+                       ), notified as (
+                           select count(*) as n from (
+                               select pg_notify($5::text, json_build_object('id', id, 'type', event_type, 'user_id', userid,
+                                                                            'payload', payload, 'created_at', created_at)::text)
+                               from events) s
+                       )
+                       select i.number, ins.number is not null, coalesce(t.userid, 0)
+                       from input i
+                       left join inserted ins on ins.number = i.number
+                       left join transactions t on t.type = 'ORDER' and t.number = i.number
+                       cross join notified`
+	rows, err := ms.dbConnections.QueryContext(ctx, insertSQL, batch.Numbers, batch.UserID, time.Time(batch.UploadedAt),
+		domain.EventOrderAccepted, notifyChannel)
 	if err != nil {
-		logger.Log.Error("Insert user failed", zap.Error(err))
-		return fmt.Errorf("insert: %w", err)
+		logger.Log.Error("Insert orders failed", zap.Error(err))
+		return nil, fmt.Errorf("insert orders: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.BatchOrder, 0, len(batch.Numbers))
+	for rows.Next() {
+		order := domain.BatchOrder{}
+		err = rows.Scan(&order.Number, &order.Accepted, &order.OwnerID)
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		ret = append(ret, order)
 	}
-	return nil
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Insert orders failed", zap.Error(err))
+		return nil, fmt.Errorf("insert orders: %w", err)
+	}
+	return &ret, nil
 }
 
 func (ms *PGOrdersStorage) GetOrder(ctx context.Context, order *string) (*domain.Order, error) {
+	defer metrics.ObserveQuery("GetOrder", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetOrder")
+	defer span.End()
 	const selectSQL = `select userid,number,status,amount,uploaded_at from transactions where number = $1 and type = 'ORDER'`
 	row := ms.dbConnections.QueryRowContext(ctx, selectSQL, order)
 	ret := domain.Order{}
@@ -86,9 +137,13 @@ func (ms *PGOrdersStorage) GetOrder(ctx context.Context, order *string) (*domain
 	return &ret, nil
 }
 
-func (ms *PGOrdersStorage) GetAllOrders(ctx context.Context, UserID *int64) (*[]domain.Order, error) {
+func (ms *PGOrdersStorage) GetAllOrders(ctx context.Context, filter *domain.ListFilter) (*[]domain.Order, error) {
+	defer metrics.ObserveQuery("GetAllOrders", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetAllOrders")
+	defer span.End()
 	const selectSQL = `select userid,number,status,amount,uploaded_at from transactions where userid = $1 and type = 'ORDER'`
-	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, UserID)
+	query, args := listQuery(selectSQL, filter)
+	rows, err := ms.dbConnections.QueryContext(ctx, query, args...)
 	if err != nil {
 		logger.Log.Error("Select all orders", zap.Error(err))
 		return nil, fmt.Errorf("select all orders: %w", err)
@@ -116,9 +171,13 @@ func (ms *PGOrdersStorage) GetAllOrders(ctx context.Context, UserID *int64) (*[]
 	}
 	return &ret, nil
 }
-func (ms *PGOrdersStorage) GetAllWithdraw(ctx context.Context, UserID *int64) (*[]domain.Withdraw, error) {
+func (ms *PGOrdersStorage) GetAllWithdraw(ctx context.Context, filter *domain.ListFilter) (*[]domain.Withdraw, error) {
+	defer metrics.ObserveQuery("GetAllWithdraw", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetAllWithdraw")
+	defer span.End()
 	const selectSQL = `select userid,number,-1*amount,uploaded_at from transactions where userid = $1 and type = 'WITHDRAW'`
-	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, UserID)
+	query, args := listQuery(selectSQL, filter)
+	rows, err := ms.dbConnections.QueryContext(ctx, query, args...)
 	if err != nil {
 		logger.Log.Error("Select all withdraws", zap.Error(err))
 		return nil, fmt.Errorf("select all withdraws: %w", err)
@@ -143,14 +202,48 @@ func (ms *PGOrdersStorage) GetAllWithdraw(ctx context.Context, UserID *int64) (*
 	}
 	return &ret, nil
 }
+
+// listQuery дополняет выборку операций пользователя условиями фильтра, сортировкой от новых к старым и ограничением.
+// Первым параметром запроса base должен быть идентификатор пользователя
+func listQuery(base string, filter *domain.ListFilter) (string, []any) {
+	args := []any{filter.UserID}
+	var query strings.Builder
+	query.WriteString(base)
+	if len(filter.Statuses) > 0 {
+		args = append(args, filter.Statuses)
+		fmt.Fprintf(&query, " and status = any($%d)", len(args))
+	}
+	if filter.From != nil {
+		args = append(args, *filter.From)
+		fmt.Fprintf(&query, " and uploaded_at >= $%d", len(args))
+	}
+	if filter.To != nil {
+		args = append(args, *filter.To)
+		fmt.Fprintf(&query, " and uploaded_at < $%d", len(args))
+	}
+	if filter.Cursor != nil {
+		args = append(args, filter.Cursor.At, filter.Cursor.Number)
+		fmt.Fprintf(&query, " and (uploaded_at,number) < ($%d,$%d)", len(args)-1, len(args))
+	}
+	query.WriteString(" order by uploaded_at desc, number desc")
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		fmt.Fprintf(&query, " limit $%d", len(args))
+	}
+	return query.String(), args
+}
+
 func (ms *PGOrdersStorage) GetBalance(ctx context.Context, UserID *int64) (*domain.Balance, error) {
-	const selectSQL = `select COALESCE(sum(amount),0) current ,
-       						  COALESCE(sum(case when type = 'WITHDRAW' then -1*amount else 0 end),0) withdrawn  
-						from transactions 
-						where userid = $1 and status = 'PROCESSED'`
+	defer metrics.ObserveQuery("GetBalance", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetBalance")
+	defer span.End()
+	const selectSQL = `select current, withdrawn from balances where userid = $1`
 	row := ms.dbConnections.QueryRowContext(ctx, selectSQL, UserID)
 	ret := domain.Balance{UserID: *UserID, Current: 0, Withdrawn: 0}
 	err := row.Scan(&ret.Current, &ret.Withdrawn)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return &ret, nil
+	}
 	if err != nil {
 		logger.Log.Error("Select balance", zap.Error(err))
 		return nil, fmt.Errorf("select balance: %w", err)
@@ -158,16 +251,89 @@ func (ms *PGOrdersStorage) GetBalance(ctx context.Context, UserID *int64) (*doma
 	return &ret, nil
 }
 
+// AddWithdraw проверяет баланс и списывает средства в одной транзакции БД.
+// Строка баланса блокируется, поэтому параллельные списания (в том числе с разных реплик) выполняются по очереди
 func (ms *PGOrdersStorage) AddWithdraw(ctx context.Context, withdraw *domain.Withdraw) error {
+	defer metrics.ObserveQuery("AddWithdraw", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.AddWithdraw")
+	defer span.End()
+	tx, err := ms.dbConnections.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("open transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	current, err := lockBalance(ctx, tx, withdraw.UserID)
+	if err != nil {
+		return err
+	}
+	// просроченные баллы списываются до проверки, чтобы их нельзя было потратить
+	expiration, err := expireLots(ctx, tx, withdraw.UserID)
+	if err != nil {
+		return err
+	}
+	current -= expiration.Amount
+	if current < withdraw.Sum {
+		return domain.ErrInsufficientFunds
+	}
 	const insertSQL = `insert into transactions (userid,type,number,status,amount,uploaded_at) values ($1,'WITHDRAW',$2,'PROCESSED',-1*$3,$4)`
-	_, err := ms.dbConnections.ExecContext(ctx, insertSQL, withdraw.UserID, withdraw.Order, withdraw.Sum, time.Time(withdraw.ProcessedAt))
+	_, err = tx.ExecContext(ctx, insertSQL, withdraw.UserID, withdraw.Order, withdraw.Sum, time.Time(withdraw.ProcessedAt))
 	if err != nil {
-		logger.Log.Error("Insert user failed", zap.Error(err))
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return domain.ErrAlreadyExists
+		}
+		// ограничение transactions_withdraw_amount_check: сумма списания должна быть положительной
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.CheckViolation {
+			return domain.ErrInvalidAmount
+		}
+		logger.Log.Error("Insert withdraw failed", zap.Error(err))
 		return fmt.Errorf("insert: %w", err)
 	}
-	return nil
+	if err = consumeLots(ctx, tx, withdraw.UserID, withdraw.Sum); err != nil {
+		return err
+	}
+	const updateBalanceSQL = `update balances set current = current - $2, withdrawn = withdrawn + $2, updated_at = CURRENT_TIMESTAMP where userid = $1
+                              returning current, withdrawn`
+	balance := &domain.Balance{UserID: withdraw.UserID}
+	err = tx.QueryRowContext(ctx, updateBalanceSQL, withdraw.UserID, withdraw.Sum).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		logger.Log.Error("Update balance failed", zap.Error(err))
+		return fmt.Errorf("update balance: %w", err)
+	}
+	err = addEvent(ctx, tx, domain.EventPointsWithdrawn, withdraw.UserID, withdraw)
+	if err != nil {
+		return err
+	}
+	err = notifyBalance(ctx, tx, balance)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockBalance блокирует строку баланса пользователя до конца транзакции, при необходимости создавая её
+func lockBalance(ctx context.Context, tx *sql.Tx, userID int64) (domain.CustomMoney, error) {
+	const insertSQL = `insert into balances (userid) values ($1) on conflict (userid) do nothing`
+	_, err := tx.ExecContext(ctx, insertSQL, userID)
+	if err != nil {
+		logger.Log.Error("Insert balance failed", zap.Error(err))
+		return 0, fmt.Errorf("insert balance: %w", err)
+	}
+	const lockSQL = `select current from balances where userid = $1 for update`
+	var current domain.CustomMoney
+	err = tx.QueryRowContext(ctx, lockSQL, userID).Scan(&current)
+	if err != nil {
+		logger.Log.Error("Lock balance failed", zap.Error(err))
+		return 0, fmt.Errorf("lock balance: %w", err)
+	}
+	return current, nil
 }
+
 func (ms *PGOrdersStorage) GetWithdraw(ctx context.Context, orderNumber *string) (*domain.Withdraw, error) {
+	defer metrics.ObserveQuery("GetWithdraw", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetWithdraw")
+	defer span.End()
 	const selectSQL = `select userid,number,-1*amount,uploaded_at from transactions where number = $1 and type = 'WITHDRAW'`
 	row := ms.dbConnections.QueryRowContext(ctx, selectSQL, orderNumber)
 	ret := domain.Withdraw{}
@@ -184,6 +350,15 @@ func (ms *PGOrdersStorage) GetWithdraw(ctx context.Context, orderNumber *string)
 	return &ret, nil
 }
 
+func (ms *PGOrdersStorage) Ping(ctx context.Context) error {
+	return ms.dbConnections.PingContext(ctx)
+}
+
+// Close закрывает пул соединений с БД
+func (ms *PGOrdersStorage) Close() error {
+	return ms.dbConnections.Close()
+}
+
 func (ms *PGOrdersStorage) IsRetryable(err error) bool {
 	if err == nil {
 		return false
@@ -193,7 +368,10 @@ func (ms *PGOrdersStorage) IsRetryable(err error) bool {
 }
 
 func (ms *PGOrdersStorage) GetUnprocessedOrders(ctx context.Context, batchLimit *int) (*[]domain.Order, error) {
-	const selectSQL = `select number from transactions where status in ('NEW','PROCESSING','REGISTERED') and type = 'ORDER' limit $1`
+	defer metrics.ObserveQuery("GetUnprocessedOrders", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetUnprocessedOrders")
+	defer span.End()
+	const selectSQL = `select userid,number,status,uploaded_at from transactions where status in ('NEW','PROCESSING','REGISTERED') and type = 'ORDER' order by uploaded_at limit $1`
 	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, batchLimit)
 	if err != nil {
 		logger.Log.Error("Select orders", zap.Error(err))
@@ -207,7 +385,7 @@ func (ms *PGOrdersStorage) GetUnprocessedOrders(ctx context.Context, batchLimit
 	order := domain.Order{}
 	ret := make([]domain.Order, 0, 10)
 	for rows.Next() {
-		err = rows.Scan(&order.Number)
+		err = rows.Scan(&order.UserID, &order.Number, &order.Status, &order.UploadedAt)
 		if err != nil {
 			logger.Log.Error("Scan rows", zap.Error(err))
 			return nil, fmt.Errorf("scan rows: %w", err)
@@ -220,30 +398,226 @@ func (ms *PGOrdersStorage) GetUnprocessedOrders(ctx context.Context, batchLimit
 	return &ret, nil
 }
 
+func (ms *PGOrdersStorage) CountOrdersByStatus(ctx context.Context) (map[string]int64, error) {
+	defer metrics.ObserveQuery("CountOrdersByStatus", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.CountOrdersByStatus")
+	defer span.End()
+	const selectSQL = `select status, count(*) from transactions where type = 'ORDER' group by status`
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL)
+	if err != nil {
+		logger.Log.Error("Select order statuses", zap.Error(err))
+		return nil, fmt.Errorf("select order statuses: %w", err)
+	}
+	defer rows.Close()
+	ret := make(map[string]int64)
+	for rows.Next() {
+		var status string
+		var count int64
+		if err = rows.Scan(&status, &count); err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		ret[status] = count
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select order statuses", zap.Error(err))
+		return nil, fmt.Errorf("select order statuses: %w", err)
+	}
+	return ret, nil
+}
+
 func (ms *PGOrdersStorage) SetProcessedAccruals(ctx context.Context, orders *[]domain.Accrual) error {
+	defer metrics.ObserveQuery("SetProcessedAccruals", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.SetProcessedAccruals")
+	defer span.End()
 	tx, err := ms.dbConnections.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("open transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	const updateSQL = `update transactions set status = $1 , amount = $2 where type = 'ORDER' and number = $3`
+	const updateSQL = `update transactions t set status = $1 , amount = $2,
+                           remaining = case when $1 = 'PROCESSED' then $2 else 0 end, expires_at = $4,
+                           credited_at = case when $1 = 'PROCESSED' then CURRENT_TIMESTAMP end
+                       from (select number, status from transactions
+                             where type = 'ORDER' and number = $3 and status not in ('PROCESSED','INVALID')
+                             for update) old
+                       where t.type = 'ORDER' and t.number = old.number
+                       returning t.userid, old.status`
 	stmt, err := tx.PrepareContext(ctx, updateSQL)
 	if err != nil {
 		return fmt.Errorf("prepare sql: %w", err)
 	}
 	defer stmt.Close()
 
+	const creditSQL = `insert into balances (userid, current) values ($1,$2)
+                       on conflict (userid) do update set current = balances.current + excluded.current, updated_at = CURRENT_TIMESTAMP
+                       returning current, withdrawn`
+	creditStmt, err := tx.PrepareContext(ctx, creditSQL)
+	if err != nil {
+		return fmt.Errorf("prepare sql: %w", err)
+	}
+	defer creditStmt.Close()
+
 	for _, v := range *orders {
 		if v.Sum == nil {
 			amount := domain.CustomMoney(0)
 			v.Sum = &amount
 		}
-		_, err = stmt.ExecContext(ctx, v.Status, v.Sum, v.Order)
+		var userID int64
+		var previousStatus string
+		err = stmt.QueryRowContext(ctx, v.Status, v.Sum, v.Order, v.ExpiresAt).Scan(&userID, &previousStatus)
+		if err != nil && errors.Is(err, sql.ErrNoRows) {
+			// заказ уже в финальном статусе (например, его обработала другая реплика)
+			continue
+		}
 		if err != nil {
 			return fmt.Errorf("exec sql: %w", err)
 		}
+		if previousStatus != v.Status {
+			event := &domain.OrderEvent{Number: v.Order, Status: v.Status, PreviousStatus: previousStatus}
+			if err = addEvent(ctx, tx, domain.EventOrderStatusChanged, userID, event); err != nil {
+				return err
+			}
+			event.Accrual = v.Sum
+			if err = addWebhookDeliveries(ctx, tx, domain.NewStatusChangedEvent(userID, *event, time.Now())); err != nil {
+				return err
+			}
+		}
+		if v.Status != "PROCESSED" || *v.Sum == 0 {
+			continue
+		}
+		balance := &domain.Balance{UserID: userID}
+		err = creditStmt.QueryRowContext(ctx, userID, v.Sum).Scan(&balance.Current, &balance.Withdrawn)
+		if err != nil {
+			return fmt.Errorf("credit balance: %w", err)
+		}
+		event := &domain.OrderEvent{Number: v.Order, Status: v.Status, Accrual: v.Sum}
+		if err = addEvent(ctx, tx, domain.EventOrderAccrued, userID, event); err != nil {
+			return err
+		}
+		if err = notifyBalance(ctx, tx, balance); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReconcileBalances пересчитывает балансы по таблице transactions и возвращает расхождения с таблицей balances.
+// При repair расходящиеся балансы исправляются
+func (ms *PGOrdersStorage) ReconcileBalances(ctx context.Context, repair *bool) (*[]domain.BalanceDrift, error) {
+	defer metrics.ObserveQuery("ReconcileBalances", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.ReconcileBalances")
+	defer span.End()
+	const selectSQL = `with actual as (
+                           select userid,
+                                  COALESCE(sum(amount),0) current,
+                                  COALESCE(sum(case when type = 'WITHDRAW' then -1*amount else 0 end),0) withdrawn
+                           from transactions
+                           where status = 'PROCESSED'
+                           group by userid)
+                       select COALESCE(a.userid,b.userid),
+                              COALESCE(b.current,0), COALESCE(b.withdrawn,0),
+                              COALESCE(a.current,0), COALESCE(a.withdrawn,0)
+                       from actual a full join balances b on a.userid = b.userid
+                       where COALESCE(a.current,0) <> COALESCE(b.current,0) or COALESCE(a.withdrawn,0) <> COALESCE(b.withdrawn,0)`
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL)
+	if err != nil {
+		logger.Log.Error("Select balance drift", zap.Error(err))
+		return nil, fmt.Errorf("select balance drift: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.BalanceDrift, 0)
+	for rows.Next() {
+		drift := domain.BalanceDrift{}
+		err = rows.Scan(&drift.UserID, &drift.StoredCurrent, &drift.StoredWithdrawn, &drift.ActualCurrent, &drift.ActualWithdrawn)
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		ret = append(ret, drift)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select balance drift", zap.Error(err))
+		return nil, fmt.Errorf("select balance drift: %w", err)
+	}
+	if !*repair {
+		return &ret, nil
+	}
+	for _, v := range ret {
+		if err = ms.repairBalance(ctx, v.UserID); err != nil {
+			return &ret, fmt.Errorf("repair balance: %w", err)
+		}
+	}
+	return &ret, nil
+}
+
+func (ms *PGOrdersStorage) repairBalance(ctx context.Context, userID int64) error {
+	tx, err := ms.dbConnections.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("open transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// пока строка баланса заблокирована, списания и начисления этого пользователя ждут,
+	// поэтому пересчёт ниже видит согласованное состояние
+	if _, err = lockBalance(ctx, tx, userID); err != nil {
+		return err
+	}
+	const updateSQL = `update balances b
+                       set current = a.current, withdrawn = a.withdrawn, updated_at = CURRENT_TIMESTAMP
+                       from (select COALESCE(sum(amount),0) current,
+                                    COALESCE(sum(case when type = 'WITHDRAW' then -1*amount else 0 end),0) withdrawn
+                             from transactions
+                             where userid = $1 and status = 'PROCESSED') a
+                       where b.userid = $1`
+	if _, err = tx.ExecContext(ctx, updateSQL, userID); err != nil {
+		logger.Log.Error("Repair balance failed", zap.Error(err))
+		return fmt.Errorf("update balance: %w", err)
 	}
+	return tx.Commit()
+}
 
+// ResetOrder возвращает заказ в статус NEW, чтобы цикл обработки снова запросил начисление.
+// Заказ PROCESSED не сбрасывается: начисление уже зачислено на баланс и было бы зачислено повторно
+func (ms *PGOrdersStorage) ResetOrder(ctx context.Context, reset *domain.OrderReset) error {
+	defer metrics.ObserveQuery("ResetOrder", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.ResetOrder")
+	defer span.End()
+	tx, err := ms.dbConnections.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("open transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	const selectSQL = `select userid,status from transactions where type = 'ORDER' and number = $1 for update`
+	err = tx.QueryRowContext(ctx, selectSQL, reset.Number).Scan(&reset.UserID, &reset.PreviousStatus)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return domain.ErrNotFound
+	}
+	if err != nil {
+		logger.Log.Error("Select order failed", zap.Error(err))
+		return fmt.Errorf("select: %w", err)
+	}
+	if reset.PreviousStatus == "PROCESSED" {
+		return domain.ErrOrderProcessed
+	}
+	const updateSQL = `update transactions set status = 'NEW', amount = 0 where type = 'ORDER' and number = $1`
+	_, err = tx.ExecContext(ctx, updateSQL, reset.Number)
+	if err != nil {
+		logger.Log.Error("Reset order failed", zap.Error(err))
+		return fmt.Errorf("update: %w", err)
+	}
+	if reset.PreviousStatus != "NEW" {
+		event := &domain.OrderEvent{Number: reset.Number, Status: "NEW", PreviousStatus: reset.PreviousStatus}
+		if err = addEvent(ctx, tx, domain.EventOrderStatusChanged, reset.UserID, event); err != nil {
+			return err
+		}
+	}
+	details := map[string]string{"number": reset.Number, "previous_status": reset.PreviousStatus}
+	if err = addAudit(ctx, tx, reset.Audit, reset.UserID, details); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
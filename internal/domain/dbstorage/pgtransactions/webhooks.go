@@ -0,0 +1,305 @@
+package pgtransactions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+// deliveryLease - время, на которое рассыльщик забирает доставку; если он не сохранит результат, доставку заберут снова
+const deliveryLease = time.Minute
+
+// deliveryLogLimit - сколько последних доставок показывается в журнале вебхука
+const deliveryLogLimit = 50
+
+func (ms *PGOrdersStorage) AddWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	defer metrics.ObserveQuery("AddWebhook", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.AddWebhook")
+	defer span.End()
+	tx, err := ms.dbConnections.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("open transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// блокировка баланса упорядочивает регистрации одного пользователя, поэтому лимит не превысить параллельными запросами
+	if _, err = lockBalance(ctx, tx, webhook.UserID); err != nil {
+		return err
+	}
+	const insertSQL = `insert into webhooks (userid,url,secret) select $1,$2,$3
+                       where (select count(*) from webhooks where userid = $1) < $4
+                       returning id, created_at`
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, insertSQL, webhook.UserID, webhook.URL, webhook.Secret, domain.MaxWebhooksPerUser).Scan(&webhook.ID, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.ErrWebhookLimit
+	}
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			return domain.ErrAlreadyExists
+		}
+		logger.Log.Error("Insert webhook failed", zap.Error(err))
+		return fmt.Errorf("insert webhook: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	webhook.CreatedAt = domain.CustomTime(createdAt)
+	return nil
+}
+
+func (ms *PGOrdersStorage) GetWebhooks(ctx context.Context, userID *int64) (*[]domain.Webhook, error) {
+	defer metrics.ObserveQuery("GetWebhooks", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetWebhooks")
+	defer span.End()
+	const selectSQL = `select id,userid,url,created_at,last_success_at,last_error_at,coalesce(last_error,''),failures
+                       from webhooks where userid = $1 order by id`
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, userID)
+	if err != nil {
+		logger.Log.Error("Select webhooks", zap.Error(err))
+		return nil, fmt.Errorf("select webhooks: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.Webhook, 0, 10)
+	for rows.Next() {
+		webhook := domain.Webhook{}
+		var createdAt time.Time
+		var lastSuccessAt, lastErrorAt sql.NullTime
+		err = rows.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &createdAt, &lastSuccessAt, &lastErrorAt, &webhook.LastError, &webhook.Failures)
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		webhook.CreatedAt = domain.CustomTime(createdAt)
+		webhook.LastSuccessAt = nullTime(lastSuccessAt)
+		webhook.LastErrorAt = nullTime(lastErrorAt)
+		ret = append(ret, webhook)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select webhooks", zap.Error(err))
+		return nil, fmt.Errorf("select webhooks: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+func (ms *PGOrdersStorage) DeleteWebhook(ctx context.Context, webhook *domain.Webhook) error {
+	defer metrics.ObserveQuery("DeleteWebhook", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.DeleteWebhook")
+	defer span.End()
+	const deleteSQL = `delete from webhooks where id = $1 and userid = $2`
+	res, err := ms.dbConnections.ExecContext(ctx, deleteSQL, webhook.ID, webhook.UserID)
+	if err != nil {
+		logger.Log.Error("Delete webhook failed", zap.Error(err))
+		return fmt.Errorf("delete webhook: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if deleted == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+// GetWebhookDeliveries возвращает последние доставки вебхука, принадлежащего webhook.UserID;
+// domain.ErrNotFound - если такого вебхука у пользователя нет
+func (ms *PGOrdersStorage) GetWebhookDeliveries(ctx context.Context, webhook *domain.Webhook) (*[]domain.WebhookDelivery, error) {
+	defer metrics.ObserveQuery("GetWebhookDeliveries", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetWebhookDeliveries")
+	defer span.End()
+	const existsSQL = `select exists(select 1 from webhooks where id = $1 and userid = $2)`
+	var exists bool
+	if err := ms.dbConnections.QueryRowContext(ctx, existsSQL, webhook.ID, webhook.UserID).Scan(&exists); err != nil {
+		logger.Log.Error("Select webhook", zap.Error(err))
+		return nil, fmt.Errorf("select webhook: %w", err)
+	}
+	if !exists {
+		return nil, domain.ErrNotFound
+	}
+	const selectSQL = `select d.id,d.webhook_id,d.event_type,d.payload,d.status,d.attempts,d.next_attempt_at,
+                              coalesce(d.last_error,''),d.created_at,d.delivered_at
+                       from webhook_deliveries d join webhooks w on w.id = d.webhook_id
+                       where d.webhook_id = $1 and w.userid = $2
+                       order by d.id desc limit $3`
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, webhook.ID, webhook.UserID, deliveryLogLimit)
+	if err != nil {
+		logger.Log.Error("Select deliveries", zap.Error(err))
+		return nil, fmt.Errorf("select deliveries: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.WebhookDelivery, 0, deliveryLogLimit)
+	for rows.Next() {
+		delivery := domain.WebhookDelivery{}
+		var payload string
+		var nextAttemptAt, createdAt time.Time
+		var deliveredAt sql.NullTime
+		err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventType, &payload, &delivery.Status, &delivery.Attempts,
+			&nextAttemptAt, &delivery.LastError, &createdAt, &deliveredAt)
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		delivery.Payload = json.RawMessage(payload)
+		delivery.CreatedAt = domain.CustomTime(createdAt)
+		delivery.DeliveredAt = nullTime(deliveredAt)
+		if delivery.Status == domain.DeliveryPending {
+			next := domain.CustomTime(nextAttemptAt)
+			delivery.NextAttemptAt = &next
+		}
+		ret = append(ret, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select deliveries", zap.Error(err))
+		return nil, fmt.Errorf("select deliveries: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+// addWebhookDeliveries ставит событие в очередь доставки на каждый вебхук его пользователя в транзакции tx,
+// в которой меняется статус заказа
+func addWebhookDeliveries(ctx context.Context, tx *sql.Tx, event *domain.WebhookEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal webhook event: %w", err)
+	}
+	const insertSQL = `insert into webhook_deliveries (webhook_id,event_type,payload)
+                       select id, $2, $3 from webhooks where userid = $1`
+	_, err = tx.ExecContext(ctx, insertSQL, event.UserID, event.Event, string(body))
+	if err != nil {
+		logger.Log.Error("Insert deliveries failed", zap.Error(err))
+		return fmt.Errorf("insert deliveries: %w", err)
+	}
+	return nil
+}
+
+// GetDueDeliveries забирает в аренду до limit доставок, время попытки которых наступило
+func (ms *PGOrdersStorage) GetDueDeliveries(ctx context.Context, limit *int) (*[]domain.WebhookDelivery, error) {
+	defer metrics.ObserveQuery("GetDueDeliveries", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.GetDueDeliveries")
+	defer span.End()
+	const updateSQL = `update webhook_deliveries d set next_attempt_at = CURRENT_TIMESTAMP + $2 * interval '1 millisecond'
+                       from webhooks w
+                       where w.id = d.webhook_id and d.id in (select id from webhook_deliveries
+                                    where status = 'PENDING' and next_attempt_at <= CURRENT_TIMESTAMP
+                                    order by next_attempt_at
+                                    limit $1
+                                    for update skip locked)
+                       returning d.id,d.webhook_id,w.url,w.secret,d.event_type,d.payload,d.attempts,d.created_at`
+	rows, err := ms.dbConnections.QueryContext(ctx, updateSQL, limit, deliveryLease.Milliseconds())
+	if err != nil {
+		logger.Log.Error("Select deliveries", zap.Error(err))
+		return nil, fmt.Errorf("select deliveries: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.WebhookDelivery, 0, *limit)
+	for rows.Next() {
+		delivery := domain.WebhookDelivery{Status: domain.DeliveryPending}
+		var payload string
+		var createdAt time.Time
+		err = rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.URL, &delivery.Secret, &delivery.EventType, &payload,
+			&delivery.Attempts, &createdAt)
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		delivery.Payload = json.RawMessage(payload)
+		delivery.CreatedAt = domain.CustomTime(createdAt)
+		ret = append(ret, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select deliveries", zap.Error(err))
+		return nil, fmt.Errorf("select deliveries: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+// SaveDeliveryResult сохраняет результат попытки доставки и обновляет статистику вебхука
+func (ms *PGOrdersStorage) SaveDeliveryResult(ctx context.Context, delivery *domain.WebhookDelivery) error {
+	defer metrics.ObserveQuery("SaveDeliveryResult", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.SaveDeliveryResult")
+	defer span.End()
+	tx, err := ms.dbConnections.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("open transaction: %w", err)
+	}
+	defer tx.Rollback()
+	var nextAttemptAt, deliveredAt *time.Time
+	if delivery.NextAttemptAt != nil {
+		t := time.Time(*delivery.NextAttemptAt)
+		nextAttemptAt = &t
+	}
+	if delivery.DeliveredAt != nil {
+		t := time.Time(*delivery.DeliveredAt)
+		deliveredAt = &t
+	}
+	const updateDeliverySQL = `update webhook_deliveries
+                               set status = $2, attempts = $3, next_attempt_at = coalesce($4, next_attempt_at),
+                                   last_error = nullif($5,''), delivered_at = $6
+                               where id = $1`
+	_, err = tx.ExecContext(ctx, updateDeliverySQL, delivery.ID, delivery.Status, delivery.Attempts, nextAttemptAt,
+		delivery.LastError, deliveredAt)
+	if err != nil {
+		logger.Log.Error("Update delivery failed", zap.Error(err))
+		return fmt.Errorf("update delivery: %w", err)
+	}
+	updateWebhookSQL := `update webhooks set last_success_at = CURRENT_TIMESTAMP, failures = 0 where id = $1`
+	args := []any{delivery.WebhookID}
+	if delivery.Status != domain.DeliveryDelivered {
+		updateWebhookSQL = `update webhooks set last_error_at = CURRENT_TIMESTAMP, last_error = $2, failures = failures + 1 where id = $1`
+		args = append(args, delivery.LastError)
+	}
+	_, err = tx.ExecContext(ctx, updateWebhookSQL, args...)
+	if err != nil {
+		logger.Log.Error("Update webhook failed", zap.Error(err))
+		return fmt.Errorf("update webhook: %w", err)
+	}
+	return tx.Commit()
+}
+
+func nullTime(t sql.NullTime) *domain.CustomTime {
+	if !t.Valid {
+		return nil
+	}
+	ret := domain.CustomTime(t.Time)
+	return &ret
+}
+
+// DeleteDeliveries удаляет доставленные и проваленные уведомления, созданные раньше olderThan
+func (ms *PGOrdersStorage) DeleteDeliveries(ctx context.Context, olderThan *time.Time) (int64, error) {
+	defer metrics.ObserveQuery("DeleteDeliveries", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGOrdersStorage.DeleteDeliveries")
+	defer span.End()
+	const deleteSQL = `delete from webhook_deliveries where status in ($2,$3) and created_at < $1`
+	res, err := ms.dbConnections.ExecContext(ctx, deleteSQL, *olderThan, domain.DeliveryDelivered, domain.DeliveryFailed)
+	if err != nil {
+		logger.Log.Error("Delete deliveries failed", zap.Error(err))
+		return 0, fmt.Errorf("delete deliveries: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,235 @@
+package pgusers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+func (ms *PGUserStorage) GetUserInfo(ctx context.Context, userID *int64) (*domain.UserInfo, error) {
+	defer metrics.ObserveQuery("GetUserInfo", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.GetUserInfo")
+	defer span.End()
+	const selectSQL = `select id,login,role,locked_at from users where id = $1`
+	user, err := scanUserInfo(ms.dbConnections.QueryRowContext(ctx, selectSQL, userID))
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Log.Error("Select user failed", zap.Error(err))
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return user, nil
+}
+
+// SearchUsers ищет пользователей по подстроке логина без учёта регистра
+func (ms *PGUserStorage) SearchUsers(ctx context.Context, search *domain.UserSearch) (*[]domain.UserInfo, error) {
+	defer metrics.ObserveQuery("SearchUsers", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.SearchUsers")
+	defer span.End()
+	const selectSQL = `select id,login,role,locked_at from users where login ilike $1 escape '\' order by login limit $2`
+	pattern := "%" + likeEscaper.Replace(search.Login) + "%"
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, pattern, search.Limit)
+	if err != nil {
+		logger.Log.Error("Select users failed", zap.Error(err))
+		return nil, fmt.Errorf("select users: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.UserInfo, 0, search.Limit)
+	for rows.Next() {
+		user, err := scanUserInfo(rows)
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		ret = append(ret, *user)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select users failed", zap.Error(err))
+		return nil, fmt.Errorf("select users: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func scanUserInfo(row interface{ Scan(dest ...any) error }) (*domain.UserInfo, error) {
+	user := domain.UserInfo{}
+	var lockedAt sql.NullTime
+	if err := row.Scan(&user.ID, &user.Login, &user.Role, &lockedAt); err != nil {
+		return nil, err
+	}
+	if lockedAt.Valid {
+		t := domain.CustomTime(lockedAt.Time)
+		user.LockedAt = &t
+	}
+	return &user, nil
+}
+
+func (ms *PGUserStorage) IsUserLocked(ctx context.Context, userID *int64) (bool, error) {
+	defer metrics.ObserveQuery("IsUserLocked", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.IsUserLocked")
+	defer span.End()
+	const selectSQL = `select exists(select 1 from users where id = $1 and locked_at is not null)`
+	var locked bool
+	err := ms.dbConnections.QueryRowContext(ctx, selectSQL, userID).Scan(&locked)
+	if err != nil {
+		logger.Log.Error("Select user lock failed", zap.Error(err))
+		return false, fmt.Errorf("select: %w", err)
+	}
+	return locked, nil
+}
+
+// SetLocked блокирует или разблокирует пользователя; повторная блокировка сохраняет исходное время
+func (ms *PGUserStorage) SetLocked(ctx context.Context, lock *domain.UserLock) error {
+	defer metrics.ObserveQuery("SetLocked", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.SetLocked")
+	defer span.End()
+	tx, err := ms.dbConnections.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("open transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	updateSQL := `update users set locked_at = coalesce(locked_at, CURRENT_TIMESTAMP) where id = $1`
+	if !lock.Locked {
+		updateSQL = `update users set locked_at = null where id = $1`
+	}
+	res, err := tx.ExecContext(ctx, updateSQL, lock.UserID)
+	if err != nil {
+		logger.Log.Error("Update user lock failed", zap.Error(err))
+		return fmt.Errorf("update: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if updated == 0 {
+		return domain.ErrNotFound
+	}
+	if err = addAudit(ctx, tx, lock.Audit, lock.UserID, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ms *PGUserStorage) SetRole(ctx context.Context, role *domain.UserRole) error {
+	defer metrics.ObserveQuery("SetRole", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.SetRole")
+	defer span.End()
+	const updateSQL = `update users set role = $1 where login = $2`
+	res, err := ms.dbConnections.ExecContext(ctx, updateSQL, role.Role, role.Login)
+	if err != nil {
+		logger.Log.Error("Update user role failed", zap.Error(err))
+		return fmt.Errorf("update: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if updated == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (ms *PGUserStorage) AddAudit(ctx context.Context, record *domain.AuditRecord) error {
+	defer metrics.ObserveQuery("AddAudit", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.AddAudit")
+	defer span.End()
+	return insertAudit(ctx, ms.dbConnections, record)
+}
+
+// addAudit дополняет запись журнала пользователем target и подробностями details и сохраняет её в транзакции действия.
+// Без записи ничего не делает
+func addAudit(ctx context.Context, tx *sql.Tx, record *domain.AuditRecord, target int64, details any) error {
+	if record == nil {
+		return nil
+	}
+	record.TargetUser = &target
+	if details != nil {
+		body, err := json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("marshal audit details: %w", err)
+		}
+		record.Details = body
+	}
+	return insertAudit(ctx, tx, record)
+}
+
+// queryRower - соединение с БД или транзакция
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertAudit(ctx context.Context, q queryRower, record *domain.AuditRecord) error {
+	details := "{}"
+	if len(record.Details) > 0 {
+		details = string(record.Details)
+	}
+	const insertSQL = `insert into admin_audit (operator_id,action,target_user,details) values ($1,$2,$3,$4) returning id, created_at`
+	var createdAt time.Time
+	err := q.QueryRowContext(ctx, insertSQL, record.OperatorID, record.Action, record.TargetUser, details).
+		Scan(&record.ID, &createdAt)
+	if err != nil {
+		logger.Log.Error("Insert audit failed", zap.Error(err))
+		return fmt.Errorf("insert audit: %w", err)
+	}
+	record.CreatedAt = domain.CustomTime(createdAt)
+	return nil
+}
+
+// GetAudit возвращает последние записи журнала, при filter.TargetUser != 0 - только по этому пользователю
+func (ms *PGUserStorage) GetAudit(ctx context.Context, filter *domain.AuditFilter) (*[]domain.AuditRecord, error) {
+	defer metrics.ObserveQuery("GetAudit", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.GetAudit")
+	defer span.End()
+	const selectSQL = `select id,operator_id,action,target_user,details,created_at from admin_audit
+                       where $1::int = 0 or target_user = $1::int
+                       order by id desc limit $2`
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, filter.TargetUser, filter.Limit)
+	if err != nil {
+		logger.Log.Error("Select audit failed", zap.Error(err))
+		return nil, fmt.Errorf("select audit: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.AuditRecord, 0, filter.Limit)
+	for rows.Next() {
+		record := domain.AuditRecord{}
+		var targetUser sql.NullInt64
+		var details string
+		var createdAt time.Time
+		err = rows.Scan(&record.ID, &record.OperatorID, &record.Action, &targetUser, &details, &createdAt)
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		if targetUser.Valid {
+			record.TargetUser = &targetUser.Int64
+		}
+		record.Details = json.RawMessage(details)
+		record.CreatedAt = domain.CustomTime(createdAt)
+		ret = append(ret, record)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select audit failed", zap.Error(err))
+		return nil, fmt.Errorf("select audit: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
@@ -5,13 +5,16 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	"go.uber.org/zap"
 	"loyalty-system/internal/domain"
 	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
 	"loyalty-system/pkg/postgresql"
+	"loyalty-system/pkg/tracing"
 )
 
 type PGUserStorage struct {
@@ -24,21 +27,17 @@ func NewUserStorage(ctx context.Context, dsn string) (*PGUserStorage, error) {
 		logger.Log.Error("Get db connection failed", zap.Error(err))
 		return nil, err
 	}
-	s := &PGUserStorage{dbConnections: dbCon}
-	const createTableSQL = `create table IF NOT EXISTS users (id int GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,login text not null unique , hash text not null)`
-	_, err = s.dbConnections.ExecContext(ctx, createTableSQL)
-	if err != nil {
-		logger.Log.Error("Create table failed", zap.Error(err))
-		return nil, err
-	}
-	return s, nil
+	return &PGUserStorage{dbConnections: dbCon}, nil
 }
 
 func (ms *PGUserStorage) GetUser(ctx context.Context, login *string) (*domain.User, error) {
-	const selectSQL = `select id,hash from users where login = $1`
+	defer metrics.ObserveQuery("GetUser", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.GetUser")
+	defer span.End()
+	const selectSQL = `select id,login,hash,role,locked_at is not null from users where login = $1`
 	row := ms.dbConnections.QueryRowContext(ctx, selectSQL, login)
 	user := domain.User{}
-	err := row.Scan(&user.UserID, &user.Hash)
+	err := row.Scan(&user.UserID, &user.Login, &user.Hash, &user.Role, &user.Locked)
 	if err != nil && errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -50,8 +49,15 @@ func (ms *PGUserStorage) GetUser(ctx context.Context, login *string) (*domain.Us
 }
 
 func (ms *PGUserStorage) AddUser(ctx context.Context, user *domain.User) error {
-	const insertSQL = `insert into users (login, hash) VALUES ($1,$2)`
-	_, err := ms.dbConnections.ExecContext(ctx, insertSQL, user.Login, user.Hash)
+	defer metrics.ObserveQuery("AddUser", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.AddUser")
+	defer span.End()
+	role := user.Role
+	if role == "" {
+		role = domain.RoleUser
+	}
+	const insertSQL = `insert into users (login, hash, role) VALUES ($1,$2,$3)`
+	_, err := ms.dbConnections.ExecContext(ctx, insertSQL, user.Login, user.Hash, role)
 	if err != nil {
 		logger.Log.Error("Insert user failed", zap.Error(err))
 		return fmt.Errorf("insert: %w", err)
@@ -59,6 +65,15 @@ func (ms *PGUserStorage) AddUser(ctx context.Context, user *domain.User) error {
 	return nil
 }
 
+func (ms *PGUserStorage) Ping(ctx context.Context) error {
+	return ms.dbConnections.PingContext(ctx)
+}
+
+// Close закрывает пул соединений с БД
+func (ms *PGUserStorage) Close() error {
+	return ms.dbConnections.Close()
+}
+
 func (ms *PGUserStorage) IsRetryable(err error) bool {
 	if err == nil {
 		return false
@@ -66,3 +81,56 @@ func (ms *PGUserStorage) IsRetryable(err error) bool {
 	var pgErr *pgconn.PgError
 	return errors.As(err, &pgErr) && pgerrcode.IsConnectionException(pgErr.Code)
 }
+
+func (ms *PGUserStorage) RevokeToken(ctx context.Context, token *domain.RevokedToken) error {
+	defer metrics.ObserveQuery("RevokeToken", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.RevokeToken")
+	defer span.End()
+	const deleteSQL = `delete from revoked_tokens where expires_at < CURRENT_TIMESTAMP`
+	_, err := ms.dbConnections.ExecContext(ctx, deleteSQL)
+	if err != nil {
+		logger.Log.Error("Delete expired tokens failed", zap.Error(err))
+		return fmt.Errorf("delete expired: %w", err)
+	}
+	const insertSQL = `insert into revoked_tokens (jti, userid, expires_at) values ($1,$2,$3) on conflict (jti) do nothing`
+	res, err := ms.dbConnections.ExecContext(ctx, insertSQL, token.ID, token.UserID, token.ExpiresAt)
+	if err != nil {
+		logger.Log.Error("Insert revoked token failed", zap.Error(err))
+		return fmt.Errorf("insert: %w", err)
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if inserted == 0 {
+		return domain.ErrAlreadyExists
+	}
+	return nil
+}
+
+func (ms *PGUserStorage) IsTokenRevoked(ctx context.Context, jti *string) (bool, error) {
+	defer metrics.ObserveQuery("IsTokenRevoked", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.IsTokenRevoked")
+	defer span.End()
+	const selectSQL = `select exists(select 1 from revoked_tokens where jti = $1)`
+	var revoked bool
+	err := ms.dbConnections.QueryRowContext(ctx, selectSQL, jti).Scan(&revoked)
+	if err != nil {
+		logger.Log.Error("Select revoked token failed", zap.Error(err))
+		return false, fmt.Errorf("select: %w", err)
+	}
+	return revoked, nil
+}
+
+func (ms *PGUserStorage) UpdateHash(ctx context.Context, user *domain.User) error {
+	defer metrics.ObserveQuery("UpdateHash", time.Now())
+	ctx, span := tracing.StartDB(ctx, "PGUserStorage.UpdateHash")
+	defer span.End()
+	const updateSQL = `update users set hash = $2 where id = $1`
+	_, err := ms.dbConnections.ExecContext(ctx, updateSQL, user.UserID, user.Hash)
+	if err != nil {
+		logger.Log.Error("Update hash failed", zap.Error(err))
+		return fmt.Errorf("update: %w", err)
+	}
+	return nil
+}
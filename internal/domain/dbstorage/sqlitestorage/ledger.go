@@ -0,0 +1,273 @@
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+// AddAdjustment проводит корректировку баланса; корректировка, после которой текущий баланс стал бы отрицательным, отклоняется
+func (ms *SQLiteStorage) AddAdjustment(ctx context.Context, adjustment *domain.Adjustment) error {
+	defer metrics.ObserveQuery("AddAdjustment", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.AddAdjustment")
+	defer span.End()
+	tx, err := ms.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockBalance(ctx, tx, adjustment.UserID)
+	if err != nil {
+		if isForeignKeyViolation(err) {
+			return domain.ErrNotFound
+		}
+		return err
+	}
+	expiration, err := expireLots(ctx, tx, adjustment.UserID)
+	if err != nil {
+		return err
+	}
+	current -= expiration.Amount
+	if current+adjustment.Amount < 0 {
+		return domain.ErrInsufficientFunds
+	}
+	// положительная корректировка становится новым начислением со своим сроком действия
+	const insertSQL = `insert into transactions (userid,type,number,status,amount,uploaded_at,reason,operator_id,remaining,expires_at)
+                       values (?1,'ADJUSTMENT',?2,'PROCESSED',?3,?4,?5,?6,max(?3,0),?7)`
+	_, err = tx.ExecContext(ctx, insertSQL, adjustment.UserID, adjustment.Number, adjustment.Amount,
+		micros(time.Time(adjustment.ProcessedAt)), adjustment.Reason, adjustment.OperatorID, nullMicros(adjustment.ExpiresAt))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrAlreadyExists
+		}
+		logger.Log.Error("Insert adjustment failed", zap.Error(err))
+		return fmt.Errorf("insert: %w", err)
+	}
+	if adjustment.Amount < 0 {
+		if err = consumeLots(ctx, tx, adjustment.UserID, -adjustment.Amount); err != nil {
+			return err
+		}
+	}
+	const updateBalanceSQL = `update balances set current = current + ?2, updated_at = ?3 where userid = ?1
+                              returning current, withdrawn`
+	balance := &domain.Balance{UserID: adjustment.UserID}
+	err = tx.QueryRowContext(ctx, updateBalanceSQL, adjustment.UserID, adjustment.Amount, micros(time.Now())).
+		Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		logger.Log.Error("Update balance failed", zap.Error(err))
+		return fmt.Errorf("update balance: %w", err)
+	}
+	err = addEvent(ctx, tx, domain.EventPointsAdjusted, adjustment.UserID, adjustment)
+	if err != nil {
+		return err
+	}
+	err = addAudit(ctx, tx, adjustment.Audit, adjustment.UserID, adjustment)
+	if err != nil {
+		return err
+	}
+	err = notifyBalance(tx, balance)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ms *SQLiteStorage) GetAllAdjustments(ctx context.Context, filter *domain.ListFilter) (*[]domain.Adjustment, error) {
+	defer metrics.ObserveQuery("GetAllAdjustments", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetAllAdjustments")
+	defer span.End()
+	const selectSQL = `select userid,number,amount,coalesce(reason,''),coalesce(operator_id,0),uploaded_at
+                       from transactions where userid = ? and type = 'ADJUSTMENT'`
+	query, args := listQuery(selectSQL, filter)
+	rows, err := ms.dbConnections.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Log.Error("Select all adjustments", zap.Error(err))
+		return nil, fmt.Errorf("select all adjustments: %w", err)
+	}
+	defer rows.Close()
+	adjustment := domain.Adjustment{}
+	ret := make([]domain.Adjustment, 0, 10)
+	for rows.Next() {
+		err = rows.Scan(&adjustment.UserID, &adjustment.Number, &adjustment.Amount, &adjustment.Reason,
+			&adjustment.OperatorID, (*timestamp)(&adjustment.ProcessedAt))
+		if err != nil {
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return nil, err
+		}
+		ret = append(ret, adjustment)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select all adjustments", zap.Error(err))
+		return nil, fmt.Errorf("select all adjustments: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+// ledgerSQL - проведённые операции пользователя с нарастающим итогом. Итог считается по всем операциям,
+// поэтому фильтры и курсор накладываются снаружи и не меняют баланс в строках. Заказ датируется временем
+// зачисления баллов, а рассчитанный до появления credited_at - временем загрузки
+const ledgerSQL = `select type,number,amount,balance,reason,uploaded_at from (
+                       select type,number,amount,reason,uploaded_at,
+                              sum(amount) over (order by uploaded_at, number rows unbounded preceding) balance
+                       from (select type,number,amount,coalesce(reason,'') reason,coalesce(credited_at,uploaded_at) uploaded_at
+                             from transactions where userid = ? and status = 'PROCESSED') p) l
+                   where true`
+
+func (ms *SQLiteStorage) GetLedger(ctx context.Context, filter *domain.ListFilter) (*[]domain.LedgerEntry, error) {
+	defer metrics.ObserveQuery("GetLedger", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetLedger")
+	defer span.End()
+	query, args := listQuery(ledgerSQL, filter)
+	rows, err := ms.dbConnections.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Log.Error("Select ledger", zap.Error(err))
+		return nil, fmt.Errorf("select ledger: %w", err)
+	}
+	defer rows.Close()
+	ret, err := scanLedger(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+// GetStatement строит выписку за период: входящий остаток, операции в хронологическом порядке и исходящий остаток.
+// Оба запроса выполняются в одной читающей транзакции, чтобы остатки сходились с операциями
+func (ms *SQLiteStorage) GetStatement(ctx context.Context, filter *domain.ListFilter) (*domain.Statement, error) {
+	defer metrics.ObserveQuery("GetStatement", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetStatement")
+	defer span.End()
+	tx, err := ms.dbConnections.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("open transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	statement := &domain.Statement{UserID: filter.UserID, Movements: []domain.LedgerEntry{}}
+	if filter.From != nil {
+		from := domain.CustomTime(*filter.From)
+		statement.From = &from
+		const openingSQL = `select coalesce(sum(amount),0) from transactions
+                            where userid = ? and status = 'PROCESSED' and coalesce(credited_at,uploaded_at) < ?`
+		err = tx.QueryRowContext(ctx, openingSQL, filter.UserID, micros(*filter.From)).Scan(&statement.Opening)
+		if err != nil {
+			logger.Log.Error("Select opening balance", zap.Error(err))
+			return nil, fmt.Errorf("select opening balance: %w", err)
+		}
+	}
+	args := []any{filter.UserID}
+	query := ledgerSQL
+	if filter.From != nil {
+		args = append(args, micros(*filter.From))
+		query += " and uploaded_at >= ?"
+	}
+	if filter.To != nil {
+		to := domain.CustomTime(*filter.To)
+		statement.To = &to
+		args = append(args, micros(*filter.To))
+		query += " and uploaded_at < ?"
+	}
+	query += " order by uploaded_at, number"
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Log.Error("Select statement", zap.Error(err))
+		return nil, fmt.Errorf("select statement: %w", err)
+	}
+	defer rows.Close()
+	movements, err := scanLedger(rows)
+	if err != nil {
+		return nil, err
+	}
+	statement.Closing = statement.Opening
+	for _, entry := range movements {
+		if entry.Amount > 0 {
+			statement.Credited += entry.Amount
+		} else {
+			statement.Debited -= entry.Amount
+		}
+		statement.Closing += entry.Amount
+	}
+	if len(movements) > 0 {
+		statement.Movements = movements
+	}
+	return statement, tx.Commit()
+}
+
+func scanLedger(rows *sql.Rows) ([]domain.LedgerEntry, error) {
+	ret := make([]domain.LedgerEntry, 0, 10)
+	for rows.Next() {
+		entry := domain.LedgerEntry{}
+		err := rows.Scan(&entry.Type, &entry.Number, &entry.Amount, &entry.Balance, &entry.Reason, (*timestamp)(&entry.ProcessedAt))
+		if err != nil {
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		ret = append(ret, entry)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Log.Error("Select ledger", zap.Error(err))
+		return nil, fmt.Errorf("select ledger: %w", err)
+	}
+	return ret, nil
+}
+
+// ExportTransactions передаёт операции в write по одной по мере чтения, не загружая выборку в память.
+// Ошибка write прерывает выгрузку
+func (ms *SQLiteStorage) ExportTransactions(ctx context.Context, filter *domain.ExportFilter, write func(*domain.ExportRow) error) error {
+	defer metrics.ObserveQuery("ExportTransactions", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.ExportTransactions")
+	defer span.End()
+	args := []any{}
+	var query strings.Builder
+	query.WriteString(`select userid,type,number,status,amount,coalesce(reason,''),uploaded_at from transactions where true`)
+	if filter.UserID != 0 {
+		args = append(args, filter.UserID)
+		query.WriteString(" and userid = ?")
+	}
+	if filter.From != nil {
+		args = append(args, micros(*filter.From))
+		query.WriteString(" and uploaded_at >= ?")
+	}
+	if filter.To != nil {
+		args = append(args, micros(*filter.To))
+		query.WriteString(" and uploaded_at < ?")
+	}
+	query.WriteString(" order by uploaded_at, number")
+	rows, err := ms.dbConnections.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		logger.Log.Error("Select export", zap.Error(err))
+		return fmt.Errorf("select export: %w", err)
+	}
+	defer rows.Close()
+	row := domain.ExportRow{}
+	for rows.Next() {
+		err = rows.Scan(&row.UserID, &row.Type, &row.Number, &row.Status, &row.Amount, &row.Reason, (*timestamp)(&row.UploadedAt))
+		if err != nil {
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return fmt.Errorf("scan rows: %w", err)
+		}
+		if err = write(&row); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select export", zap.Error(err))
+		return fmt.Errorf("select export: %w", err)
+	}
+	return nil
+}
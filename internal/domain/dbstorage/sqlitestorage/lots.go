@@ -0,0 +1,202 @@
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+// Начисления хранят непогашенный остаток в remaining и срок действия в expires_at, как и в PostgreSQL.
+// В SQLite нет изменяющих CTE, поэтому погашение и сгорание остатков выполняются построчно внутри транзакции
+
+// lot - остаток начисления
+type lot struct {
+	typ       string
+	number    string
+	remaining domain.CustomMoney
+	expiresAt int64
+}
+
+// selectLots читает остатки начислений пользователя в порядке погашения: сначала те, что сгорают раньше, бессрочные -
+// последними; при due - только просроченные на момент now
+func selectLots(ctx context.Context, tx *txn, userID int64, due bool, now int64) ([]lot, error) {
+	selectSQL := `select type,number,remaining,coalesce(expires_at,0) from transactions
+                  where userid = ? and remaining > 0`
+	args := []any{userID}
+	if due {
+		selectSQL += ` and expires_at <= ?`
+		args = append(args, now)
+	}
+	selectSQL += ` order by expires_at is null, expires_at, uploaded_at, number`
+	rows, err := tx.QueryContext(ctx, selectSQL, args...)
+	if err != nil {
+		logger.Log.Error("Select lots failed", zap.Error(err))
+		return nil, fmt.Errorf("select lots: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]lot, 0)
+	for rows.Next() {
+		l := lot{}
+		if err = rows.Scan(&l.typ, &l.number, &l.remaining, &l.expiresAt); err != nil {
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		ret = append(ret, l)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select lots failed", zap.Error(err))
+		return nil, fmt.Errorf("select lots: %w", err)
+	}
+	return ret, nil
+}
+
+// consumeLots погашает amount из остатков начислений пользователя, начиная с тех, что сгорают раньше
+func consumeLots(ctx context.Context, tx *txn, userID int64, amount domain.CustomMoney) error {
+	lots, err := selectLots(ctx, tx, userID, false, 0)
+	if err != nil {
+		return err
+	}
+	const updateSQL = `update transactions set remaining = remaining - ? where type = ? and number = ?`
+	for _, l := range lots {
+		if amount <= 0 {
+			break
+		}
+		take := min(l.remaining, amount)
+		if _, err = tx.ExecContext(ctx, updateSQL, take, l.typ, l.number); err != nil {
+			logger.Log.Error("Consume lots failed", zap.Error(err))
+			return fmt.Errorf("consume lots: %w", err)
+		}
+		amount -= take
+	}
+	return nil
+}
+
+// expireLots списывает просроченные остатки начислений пользователя. Вызывается внутри пишущей транзакции
+func expireLots(ctx context.Context, tx *txn, userID int64) (*domain.Expiration, error) {
+	now := time.Now()
+	lots, err := selectLots(ctx, tx, userID, true, micros(now))
+	if err != nil {
+		return nil, err
+	}
+	expiration := &domain.Expiration{UserID: userID}
+	if len(lots) == 0 {
+		return expiration, nil
+	}
+	const spendSQL = `update transactions set remaining = 0 where type = ? and number = ?`
+	const insertSQL = `insert into transactions (userid,type,number,status,amount,uploaded_at) values (?,'EXPIRED',?,'PROCESSED',-1*?,?)`
+	for _, l := range lots {
+		if _, err = tx.ExecContext(ctx, spendSQL, l.typ, l.number); err != nil {
+			logger.Log.Error("Expire lots failed", zap.Error(err))
+			return nil, fmt.Errorf("expire lots: %w", err)
+		}
+		if _, err = tx.ExecContext(ctx, insertSQL, userID, l.typ+"-"+l.number, l.remaining, l.expiresAt); err != nil {
+			logger.Log.Error("Expire lots failed", zap.Error(err))
+			return nil, fmt.Errorf("expire lots: %w", err)
+		}
+		expiration.Amount += l.remaining
+		expiration.Lots++
+	}
+	const updateBalanceSQL = `update balances set current = current - ?2, updated_at = ?3 where userid = ?1
+                              returning current, withdrawn`
+	balance := &domain.Balance{UserID: userID}
+	err = tx.QueryRowContext(ctx, updateBalanceSQL, userID, expiration.Amount, micros(now)).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		logger.Log.Error("Update balance failed", zap.Error(err))
+		return nil, fmt.Errorf("update balance: %w", err)
+	}
+	if err = addEvent(ctx, tx, domain.EventPointsExpired, userID, expiration); err != nil {
+		return nil, err
+	}
+	if err = notifyBalance(tx, balance); err != nil {
+		return nil, err
+	}
+	return expiration, nil
+}
+
+// ExpirePoints списывает просроченные баллы не более чем limit пользователей, каждого в своей транзакции
+func (ms *SQLiteStorage) ExpirePoints(ctx context.Context, limit *int) (*[]domain.Expiration, error) {
+	defer metrics.ObserveQuery("ExpirePoints", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.ExpirePoints")
+	defer span.End()
+	const selectSQL = `select distinct userid from transactions
+                       where remaining > 0 and expires_at <= ?
+                       limit ?`
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, micros(time.Now()), limit)
+	if err != nil {
+		logger.Log.Error("Select expired lots", zap.Error(err))
+		return nil, fmt.Errorf("select expired lots: %w", err)
+	}
+	users := make([]int64, 0, *limit)
+	for rows.Next() {
+		var userID int64
+		if err = rows.Scan(&userID); err != nil {
+			rows.Close()
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		users = append(users, userID)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select expired lots", zap.Error(err))
+		return nil, fmt.Errorf("select expired lots: %w", err)
+	}
+	if len(users) == 0 {
+		return nil, nil
+	}
+	ret := make([]domain.Expiration, 0, len(users))
+	for _, userID := range users {
+		expiration, err := ms.expireUser(ctx, userID)
+		if err != nil {
+			return &ret, err
+		}
+		if expiration.Lots > 0 {
+			ret = append(ret, *expiration)
+		}
+	}
+	return &ret, nil
+}
+
+func (ms *SQLiteStorage) expireUser(ctx context.Context, userID int64) (*domain.Expiration, error) {
+	tx, err := ms.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err = lockBalance(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	expiration, err := expireLots(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return expiration, tx.Commit()
+}
+
+func (ms *SQLiteStorage) GetExpiringPoints(ctx context.Context, filter *domain.ExpiryFilter) (*domain.ExpiringPoints, error) {
+	defer metrics.ObserveQuery("GetExpiringPoints", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetExpiringPoints")
+	defer span.End()
+	const selectSQL = `select COALESCE(sum(remaining),0), min(expires_at) from transactions
+                       where userid = ? and remaining > 0 and expires_at < ?`
+	ret := domain.ExpiringPoints{UserID: filter.UserID}
+	var date sql.NullInt64
+	err := ms.dbConnections.QueryRowContext(ctx, selectSQL, filter.UserID, micros(filter.Before)).Scan(&ret.Amount, &date)
+	if err != nil {
+		logger.Log.Error("Select expiring points", zap.Error(err))
+		return nil, fmt.Errorf("select expiring points: %w", err)
+	}
+	if !date.Valid {
+		return nil, nil
+	}
+	ret.Date = *nullTime(date)
+	return &ret, nil
+}
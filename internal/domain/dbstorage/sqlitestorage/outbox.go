@@ -0,0 +1,121 @@
+package sqlitestorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+// outboxLease - время, на которое публикатор забирает события; если он не отчитается о доставке, события заберут снова
+const outboxLease = 30 * time.Second
+
+// GetPendingEvents забирает в аренду до limit недоставленных событий в порядке их возникновения
+func (ms *SQLiteStorage) GetPendingEvents(ctx context.Context, limit *int) (*[]domain.Event, error) {
+	defer metrics.ObserveQuery("GetPendingEvents", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetPendingEvents")
+	defer span.End()
+	now := time.Now()
+	const updateSQL = `update outbox set locked_until = ?1
+                       where id in (select id from outbox
+                                    where delivered_at is null and (locked_until is null or locked_until < ?2)
+                                    order by id
+                                    limit ?3)
+                       returning id,event_type,userid,payload,created_at`
+	rows, err := ms.dbConnections.QueryContext(ctx, updateSQL, micros(now.Add(outboxLease)), micros(now), limit)
+	if err != nil {
+		logger.Log.Error("Select events", zap.Error(err))
+		return nil, fmt.Errorf("select events: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.Event, 0, *limit)
+	for rows.Next() {
+		event := domain.Event{}
+		var payload string
+		err = rows.Scan(&event.ID, &event.Type, &event.UserID, &payload, (*timestamp)(&event.CreatedAt))
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		event.Payload = json.RawMessage(payload)
+		ret = append(ret, event)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select events", zap.Error(err))
+		return nil, fmt.Errorf("select events: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	// returning не гарантирует порядок строк
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return &ret, nil
+}
+
+func (ms *SQLiteStorage) MarkEventsDelivered(ctx context.Context, ids *[]int64) error {
+	defer metrics.ObserveQuery("MarkEventsDelivered", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.MarkEventsDelivered")
+	defer span.End()
+	if len(*ids) == 0 {
+		return nil
+	}
+	updateSQL := `update outbox set delivered_at = ?, attempts = attempts + 1, locked_until = null where id in (` + placeholders(len(*ids)) + `)`
+	args := []any{micros(time.Now())}
+	for _, id := range *ids {
+		args = append(args, id)
+	}
+	_, err := ms.dbConnections.ExecContext(ctx, updateSQL, args...)
+	if err != nil {
+		logger.Log.Error("Update events failed", zap.Error(err))
+		return fmt.Errorf("update events: %w", err)
+	}
+	return nil
+}
+
+// DeleteEvents удаляет из outbox доставленные и, если задано, устаревшие недоставленные события
+func (ms *SQLiteStorage) DeleteEvents(ctx context.Context, cleanup *domain.EventCleanup) (int64, error) {
+	defer metrics.ObserveQuery("DeleteEvents", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.DeleteEvents")
+	defer span.End()
+	const deleteSQL = `delete from outbox where delivered_at < ?1 or (delivered_at is null and created_at < ?2)`
+	res, err := ms.dbConnections.ExecContext(ctx, deleteSQL, micros(cleanup.DeliveredBefore), nullMicros(cleanup.PendingBefore))
+	if err != nil {
+		logger.Log.Error("Delete events failed", zap.Error(err))
+		return 0, fmt.Errorf("delete events: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("rows affected: %w", err)
+	}
+	return deleted, nil
+}
+
+// MarkEventsFailed фиксирует неудачную попытку; события останутся в аренде до её истечения и будут отправлены повторно
+func (ms *SQLiteStorage) MarkEventsFailed(ctx context.Context, failure *domain.EventFailure) error {
+	defer metrics.ObserveQuery("MarkEventsFailed", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.MarkEventsFailed")
+	defer span.End()
+	if len(failure.IDs) == 0 {
+		return nil
+	}
+	updateSQL := `update outbox set attempts = attempts + 1, last_error = ? where id in (` + placeholders(len(failure.IDs)) + `)`
+	args := []any{failure.Error}
+	for _, id := range failure.IDs {
+		args = append(args, id)
+	}
+	_, err := ms.dbConnections.ExecContext(ctx, updateSQL, args...)
+	if err != nil {
+		logger.Log.Error("Update events failed", zap.Error(err))
+		return fmt.Errorf("update events: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+-- схема соответствует миграциям PostgreSQL; время хранится в микросекундах Unix
+create table IF NOT EXISTS users (
+    id integer PRIMARY KEY,
+    login text not null unique,
+    hash text not null,
+    role text not null default 'user',
+    locked_at integer
+);
+
+create table IF NOT EXISTS transactions (
+    userid integer not null references users(id),
+    type text not null,
+    number text not null,
+    status text not null,
+    amount integer not null default 0,
+    uploaded_at integer not null,
+    reason text,
+    operator_id integer references users(id),
+    expires_at integer,
+    remaining integer not null default 0
+);
+
+CREATE unique index IF NOT EXISTS number_type_uix ON transactions (number,type);
+CREATE index IF NOT EXISTS user_type_uploaded_ix ON transactions (userid,type,uploaded_at DESC,number DESC);
+CREATE index IF NOT EXISTS user_status_uploaded_ix ON transactions (userid,status,uploaded_at,number);
+CREATE index IF NOT EXISTS uploaded_ix ON transactions (uploaded_at,number);
+CREATE index IF NOT EXISTS lots_user_ix ON transactions (userid,uploaded_at,number) where remaining > 0;
+CREATE index IF NOT EXISTS lots_expiry_ix ON transactions (expires_at) where remaining > 0;
+
+create table IF NOT EXISTS balances (
+    userid integer PRIMARY KEY references users(id),
+    current integer not null default 0,
+    withdrawn integer not null default 0,
+    updated_at integer not null
+);
+
+create table IF NOT EXISTS revoked_tokens (
+    jti text PRIMARY KEY,
+    userid integer not null references users(id),
+    expires_at integer not null,
+    revoked_at integer not null
+);
+
+CREATE index IF NOT EXISTS revoked_tokens_expires_ix ON revoked_tokens (expires_at);
+
+create table IF NOT EXISTS outbox (
+    id integer PRIMARY KEY,
+    event_type text not null,
+    userid integer not null,
+    payload text not null,
+    created_at integer not null,
+    attempts integer not null default 0,
+    last_error text,
+    locked_until integer,
+    delivered_at integer
+);
+
+CREATE index IF NOT EXISTS outbox_pending_ix ON outbox (id) WHERE delivered_at IS NULL;
+
+create table IF NOT EXISTS webhooks (
+    id integer PRIMARY KEY,
+    userid integer not null,
+    url text not null,
+    secret text not null,
+    created_at integer not null,
+    last_success_at integer,
+    last_error_at integer,
+    last_error text,
+    failures integer not null default 0,
+    UNIQUE (userid, url)
+);
+
+create table IF NOT EXISTS webhook_deliveries (
+    id integer PRIMARY KEY,
+    webhook_id integer not null REFERENCES webhooks (id) ON DELETE CASCADE,
+    event_type text not null,
+    payload text not null,
+    status text not null default 'PENDING',
+    attempts integer not null default 0,
+    next_attempt_at integer not null,
+    last_error text,
+    created_at integer not null,
+    delivered_at integer
+);
+
+CREATE index IF NOT EXISTS webhook_deliveries_pending_ix ON webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+CREATE index IF NOT EXISTS webhook_deliveries_webhook_ix ON webhook_deliveries (webhook_id, id DESC);
+
+create table IF NOT EXISTS admin_audit (
+    id integer PRIMARY KEY,
+    operator_id integer not null,
+    action text not null,
+    target_user integer,
+    details text not null default '{}',
+    created_at integer not null
+);
+
+CREATE index IF NOT EXISTS admin_audit_target_ix ON admin_audit (target_user, id DESC);
@@ -0,0 +1,21 @@
+-- идентификаторы событий не должны повторяться после удаления старых событий из outbox:
+-- получатели отбрасывают дубли по идентификатору
+create table outbox_new (
+    id integer PRIMARY KEY AUTOINCREMENT,
+    event_type text not null,
+    userid integer not null,
+    payload text not null,
+    created_at integer not null,
+    attempts integer not null default 0,
+    last_error text,
+    locked_until integer,
+    delivered_at integer
+);
+
+insert into outbox_new select id, event_type, userid, payload, created_at, attempts, last_error, locked_until, delivered_at from outbox;
+
+drop table outbox;
+
+alter table outbox_new rename to outbox;
+
+CREATE index IF NOT EXISTS outbox_pending_ix ON outbox (id) WHERE delivered_at IS NULL;
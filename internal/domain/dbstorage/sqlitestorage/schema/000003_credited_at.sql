@@ -0,0 +1,2 @@
+-- время зачисления баллов по заказу, как в миграции 000012_credited_at PostgreSQL
+alter table transactions add column credited_at integer;
@@ -0,0 +1,7 @@
+-- списание хранится с отрицательной суммой, как в ограничении миграции 000013_withdraw_amount PostgreSQL;
+-- SQLite не добавляет CHECK к существующей таблице, поэтому проверку выполняет триггер
+create trigger transactions_withdraw_amount_check before insert on transactions
+when new.type = 'WITHDRAW' and new.amount >= 0
+begin
+    select raise(ABORT, 'withdraw amount must be negative');
+end;
@@ -0,0 +1,284 @@
+// Package sqlitestorage хранит пользователей и операции во встроенной БД SQLite (драйвер modernc.org/sqlite без cgo)
+// для развёртывания на одном узле без PostgreSQL. Запись выполняется транзакциями BEGIN IMMEDIATE, поэтому
+// изменения балансов идут по очереди так же, как под блокировкой строки в PostgreSQL.
+// Время хранится целым числом микросекунд Unix
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// connParams включают внешние ключи, журнал WAL (чтение не ждёт записи) и ожидание блокировки вместо ошибки SQLITE_BUSY
+const connParams = "_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate"
+
+// listenerBuffer - сколько оповещений копится для медленного слушателя, прежде чем новые начнут отбрасываться
+const listenerBuffer = 256
+
+//go:embed schema/*.sql
+var schema embed.FS
+
+type SQLiteStorage struct {
+	dbConnections *sql.DB
+	mu            sync.Mutex
+	listeners     map[chan domain.Event]struct{}
+}
+
+// New открывает файл БД по пути file (после ? можно передать дополнительные параметры драйвера) и применяет схему
+func New(ctx context.Context, file string) (*SQLiteStorage, error) {
+	file, query, _ := strings.Cut(file, "?")
+	if file == "" {
+		return nil, errors.New("sqlite: database path is empty")
+	}
+	source := "file:" + file + "?" + connParams
+	if query != "" {
+		source += "&" + query
+	}
+	dbCon, err := sql.Open("sqlite", source)
+	if err != nil {
+		logger.Log.Error("Open sqlite failed", zap.Error(err))
+		return nil, err
+	}
+	if err = migrate(ctx, dbCon); err != nil {
+		dbCon.Close()
+		return nil, fmt.Errorf("sqlite schema: %w", err)
+	}
+	return &SQLiteStorage{dbConnections: dbCon, listeners: make(map[chan domain.Event]struct{})}, nil
+}
+
+// migrate применяет файлы schema/NNNNNN_*.sql с версией больше user_version
+func migrate(ctx context.Context, db *sql.DB) error {
+	entries, err := fs.ReadDir(schema, "schema")
+	if err != nil {
+		return fmt.Errorf("read dir: %w", err)
+	}
+	var current int64
+	if err = db.QueryRowContext(ctx, `pragma user_version`).Scan(&current); err != nil {
+		return fmt.Errorf("select version: %w", err)
+	}
+	for _, e := range entries {
+		versionStr, _, _ := strings.Cut(e.Name(), "_")
+		version, err := strconv.ParseInt(versionStr, 10, 64)
+		if err != nil {
+			return fmt.Errorf("schema file %q: parse version: %w", e.Name(), err)
+		}
+		if version <= current {
+			continue
+		}
+		body, err := schema.ReadFile(path.Join("schema", e.Name()))
+		if err != nil {
+			return fmt.Errorf("read %q: %w", e.Name(), err)
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("open transaction: %w", err)
+		}
+		if _, err = tx.ExecContext(ctx, string(body)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("apply %q: %w", e.Name(), err)
+		}
+		if _, err = tx.ExecContext(ctx, fmt.Sprintf(`pragma user_version = %d`, version)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("set version: %w", err)
+		}
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("commit %q: %w", e.Name(), err)
+		}
+		logger.Log.Info("SQLite schema applied", zap.String("file", e.Name()))
+	}
+	return nil
+}
+
+func (ms *SQLiteStorage) Ping(ctx context.Context) error {
+	return ms.dbConnections.PingContext(ctx)
+}
+
+// Close закрывает пул соединений с БД
+func (ms *SQLiteStorage) Close() error {
+	return ms.dbConnections.Close()
+}
+
+// IsRetryable считает повторяемыми ошибки занятой БД, оставшиеся после busy_timeout
+func (ms *SQLiteStorage) IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+func isConstraint(err error, codes ...int) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	for _, code := range codes {
+		if sqliteErr.Code() == code {
+			return true
+		}
+	}
+	return false
+}
+
+func isUniqueViolation(err error) bool {
+	return isConstraint(err, sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY)
+}
+
+func isForeignKeyViolation(err error) bool {
+	return isConstraint(err, sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY)
+}
+
+func isTriggerViolation(err error) bool {
+	return isConstraint(err, sqlite3.SQLITE_CONSTRAINT_TRIGGER)
+}
+
+// timestamp читает время, сохранённое в микросекундах Unix
+type timestamp time.Time
+
+func (t *timestamp) Scan(v any) error {
+	micros, ok := v.(int64)
+	if !ok {
+		return fmt.Errorf("timestamp: unexpected type %T", v)
+	}
+	*t = timestamp(time.UnixMicro(micros))
+	return nil
+}
+
+func micros(t time.Time) int64 {
+	return t.UnixMicro()
+}
+
+// nullMicros возвращает время в микросекундах или NULL
+func nullMicros(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UnixMicro()
+}
+
+func nullTime(v sql.NullInt64) *domain.CustomTime {
+	if !v.Valid {
+		return nil
+	}
+	ret := domain.CustomTime(time.UnixMicro(v.Int64))
+	return &ret
+}
+
+// placeholders возвращает список из n параметров для выражения in (...)
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// txn - транзакция, оповещения которой рассылаются слушателям только после фиксации, как NOTIFY в PostgreSQL
+type txn struct {
+	*sql.Tx
+	storage *SQLiteStorage
+	events  []domain.Event
+}
+
+func (ms *SQLiteStorage) begin(ctx context.Context) (*txn, error) {
+	tx, err := ms.dbConnections.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open transaction: %w", err)
+	}
+	return &txn{Tx: tx, storage: ms}, nil
+}
+
+func (tx *txn) Commit() error {
+	if err := tx.Tx.Commit(); err != nil {
+		return err
+	}
+	for _, event := range tx.events {
+		tx.storage.publish(event)
+	}
+	return nil
+}
+
+func (ms *SQLiteStorage) publish(event domain.Event) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	for sub := range ms.listeners {
+		select {
+		case sub <- event:
+		default:
+			logger.Log.Warn("Listener is too slow, notification dropped", zap.String("type", event.Type))
+		}
+	}
+}
+
+// Listen передаёт в events оповещения о событиях, зафиксированных этим процессом, до отмены ctx
+func (ms *SQLiteStorage) Listen(ctx context.Context, events chan<- domain.Event) error {
+	sub := make(chan domain.Event, listenerBuffer)
+	ms.mu.Lock()
+	ms.listeners[sub] = struct{}{}
+	ms.mu.Unlock()
+	defer func() {
+		ms.mu.Lock()
+		delete(ms.listeners, sub)
+		ms.mu.Unlock()
+	}()
+	for {
+		select {
+		case event := <-sub:
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// addEvent записывает событие в outbox в той же транзакции, что и изменение состояния
+func addEvent(ctx context.Context, tx *txn, eventType string, userID int64, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+	const insertSQL = `insert into outbox (event_type,userid,payload,created_at) values (?,?,?,?) returning id`
+	now := time.Now()
+	event := domain.Event{Type: eventType, UserID: userID, Payload: body, CreatedAt: domain.CustomTime(now)}
+	err = tx.QueryRowContext(ctx, insertSQL, eventType, userID, string(body), micros(now)).Scan(&event.ID)
+	if err != nil {
+		logger.Log.Error("Insert event failed", zap.Error(err))
+		return fmt.Errorf("insert event: %w", err)
+	}
+	tx.events = append(tx.events, event)
+	return nil
+}
+
+func notifyBalance(tx *txn, balance *domain.Balance) error {
+	body, err := json.Marshal(balance)
+	if err != nil {
+		return fmt.Errorf("marshal balance: %w", err)
+	}
+	tx.events = append(tx.events, domain.Event{
+		Type:      domain.EventBalanceUpdated,
+		UserID:    balance.UserID,
+		Payload:   body,
+		CreatedAt: domain.CustomTime(time.Now()),
+	})
+	return nil
+}
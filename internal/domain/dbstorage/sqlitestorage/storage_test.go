@@ -0,0 +1,20 @@
+package sqlitestorage
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"loyalty-system/internal/domain/dbstorage/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Storage {
+		ms, err := New(context.Background(), filepath.Join(t.TempDir(), "gophermart.db"))
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { ms.Close() })
+		return storagetest.Storage{Users: ms, Transactions: ms}
+	})
+}
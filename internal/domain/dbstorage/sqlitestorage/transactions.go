@@ -0,0 +1,596 @@
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+func (ms *SQLiteStorage) AddOrder(ctx context.Context, order *domain.Order) error {
+	defer metrics.ObserveQuery("AddOrder", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.AddOrder")
+	defer span.End()
+	tx, err := ms.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const insertSQL = `insert into transactions (userid,type,number,status,amount,uploaded_at) values (?,'ORDER',?,?,coalesce(?,0),?)`
+	_, err = tx.ExecContext(ctx, insertSQL, order.UserID, order.Number, order.Status, order.Accrual, micros(time.Time(order.UploadedAt)))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrAlreadyExists
+		}
+		logger.Log.Error("Insert order failed", zap.Error(err))
+		return fmt.Errorf("insert: %w", err)
+	}
+	err = addEvent(ctx, tx, domain.EventOrderAccepted, order.UserID, &domain.OrderEvent{Number: order.Number, Status: order.Status})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddOrders вставляет пачку заказов в одной транзакции вместе с событиями outbox.
+// Для каждого номера возвращается, принят ли он, а для уже существующих - владелец
+func (ms *SQLiteStorage) AddOrders(ctx context.Context, batch *domain.OrderBatch) (*[]domain.BatchOrder, error) {
+	defer metrics.ObserveQuery("AddOrders", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.AddOrders")
+	defer span.End()
+	tx, err := ms.begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	const insertSQL = `insert into transactions (userid,type,number,status,amount,uploaded_at) values (?,'ORDER',?,'NEW',0,?)
+                       on conflict do nothing`
+	insertStmt, err := tx.PrepareContext(ctx, insertSQL)
+	if err != nil {
+		return nil, fmt.Errorf("prepare sql: %w", err)
+	}
+	defer insertStmt.Close()
+	const ownerSQL = `select userid from transactions where type = 'ORDER' and number = ?`
+	ownerStmt, err := tx.PrepareContext(ctx, ownerSQL)
+	if err != nil {
+		return nil, fmt.Errorf("prepare sql: %w", err)
+	}
+	defer ownerStmt.Close()
+
+	uploadedAt := micros(time.Time(batch.UploadedAt))
+	seen := make(map[string]struct{}, len(batch.Numbers))
+	ret := make([]domain.BatchOrder, 0, len(batch.Numbers))
+	for _, number := range batch.Numbers {
+		if _, ok := seen[number]; ok {
+			continue
+		}
+		seen[number] = struct{}{}
+		res, err := insertStmt.ExecContext(ctx, batch.UserID, number, uploadedAt)
+		if err != nil {
+			logger.Log.Error("Insert orders failed", zap.Error(err))
+			return nil, fmt.Errorf("insert orders: %w", err)
+		}
+		inserted, err := res.RowsAffected()
+		if err != nil {
+			return nil, fmt.Errorf("rows affected: %w", err)
+		}
+		order := domain.BatchOrder{Number: number}
+		if inserted > 0 {
+			order.Accepted = true
+			order.OwnerID = batch.UserID
+			err = addEvent(ctx, tx, domain.EventOrderAccepted, batch.UserID, &domain.OrderEvent{Number: number, Status: "NEW"})
+			if err != nil {
+				return nil, err
+			}
+		} else if err = ownerStmt.QueryRowContext(ctx, number).Scan(&order.OwnerID); err != nil {
+			logger.Log.Error("Select order owner failed", zap.Error(err))
+			return nil, fmt.Errorf("select owner: %w", err)
+		}
+		ret = append(ret, order)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit: %w", err)
+	}
+	return &ret, nil
+}
+
+func (ms *SQLiteStorage) GetOrder(ctx context.Context, order *string) (*domain.Order, error) {
+	defer metrics.ObserveQuery("GetOrder", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetOrder")
+	defer span.End()
+	const selectSQL = `select userid,number,status,amount,uploaded_at from transactions where number = ? and type = 'ORDER'`
+	row := ms.dbConnections.QueryRowContext(ctx, selectSQL, order)
+	ret := domain.Order{}
+	err := row.Scan(&ret.UserID, &ret.Number, &ret.Status, &ret.Accrual, (*timestamp)(&ret.UploadedAt))
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Log.Error("Select user", zap.Error(err))
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return &ret, nil
+}
+
+func (ms *SQLiteStorage) GetAllOrders(ctx context.Context, filter *domain.ListFilter) (*[]domain.Order, error) {
+	defer metrics.ObserveQuery("GetAllOrders", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetAllOrders")
+	defer span.End()
+	const selectSQL = `select userid,number,status,amount,uploaded_at from transactions where userid = ? and type = 'ORDER'`
+	query, args := listQuery(selectSQL, filter)
+	rows, err := ms.dbConnections.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Log.Error("Select all orders", zap.Error(err))
+		return nil, fmt.Errorf("select all orders: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.Order, 0, 10)
+	for rows.Next() {
+		order := domain.Order{}
+		err = rows.Scan(&order.UserID, &order.Number, &order.Status, &order.Accrual, (*timestamp)(&order.UploadedAt))
+		if err != nil {
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return nil, err
+		}
+		if *order.Accrual == 0 {
+			order.Accrual = nil
+		}
+		ret = append(ret, order)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select all orders", zap.Error(err))
+		return nil, fmt.Errorf("select all orders: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+func (ms *SQLiteStorage) GetAllWithdraw(ctx context.Context, filter *domain.ListFilter) (*[]domain.Withdraw, error) {
+	defer metrics.ObserveQuery("GetAllWithdraw", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetAllWithdraw")
+	defer span.End()
+	const selectSQL = `select userid,number,-1*amount,uploaded_at from transactions where userid = ? and type = 'WITHDRAW'`
+	query, args := listQuery(selectSQL, filter)
+	rows, err := ms.dbConnections.QueryContext(ctx, query, args...)
+	if err != nil {
+		logger.Log.Error("Select all withdraws", zap.Error(err))
+		return nil, fmt.Errorf("select all withdraws: %w", err)
+	}
+	defer rows.Close()
+	withdraw := domain.Withdraw{}
+	ret := make([]domain.Withdraw, 0, 10)
+	for rows.Next() {
+		err = rows.Scan(&withdraw.UserID, &withdraw.Order, &withdraw.Sum, (*timestamp)(&withdraw.ProcessedAt))
+		if err != nil {
+			logger.Log.Error("Scan rows failed", zap.Error(err))
+			return nil, err
+		}
+		ret = append(ret, withdraw)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select all withdraws", zap.Error(err))
+		return nil, fmt.Errorf("select all withdraws: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+// listQuery дополняет выборку операций пользователя условиями фильтра, сортировкой от новых к старым и ограничением.
+// Первым параметром запроса base должен быть идентификатор пользователя
+func listQuery(base string, filter *domain.ListFilter) (string, []any) {
+	args := []any{filter.UserID}
+	var query strings.Builder
+	query.WriteString(base)
+	if len(filter.Statuses) > 0 {
+		for _, status := range filter.Statuses {
+			args = append(args, status)
+		}
+		fmt.Fprintf(&query, " and status in (%s)", placeholders(len(filter.Statuses)))
+	}
+	if filter.From != nil {
+		args = append(args, micros(*filter.From))
+		query.WriteString(" and uploaded_at >= ?")
+	}
+	if filter.To != nil {
+		args = append(args, micros(*filter.To))
+		query.WriteString(" and uploaded_at < ?")
+	}
+	if filter.Cursor != nil {
+		args = append(args, micros(filter.Cursor.At), filter.Cursor.Number)
+		query.WriteString(" and (uploaded_at,number) < (?,?)")
+	}
+	query.WriteString(" order by uploaded_at desc, number desc")
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		query.WriteString(" limit ?")
+	}
+	return query.String(), args
+}
+
+func (ms *SQLiteStorage) GetBalance(ctx context.Context, UserID *int64) (*domain.Balance, error) {
+	defer metrics.ObserveQuery("GetBalance", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetBalance")
+	defer span.End()
+	const selectSQL = `select current, withdrawn from balances where userid = ?`
+	row := ms.dbConnections.QueryRowContext(ctx, selectSQL, UserID)
+	ret := domain.Balance{UserID: *UserID, Current: 0, Withdrawn: 0}
+	err := row.Scan(&ret.Current, &ret.Withdrawn)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return &ret, nil
+	}
+	if err != nil {
+		logger.Log.Error("Select balance", zap.Error(err))
+		return nil, fmt.Errorf("select balance: %w", err)
+	}
+	return &ret, nil
+}
+
+// AddWithdraw проверяет баланс и списывает средства в одной транзакции БД.
+// Транзакция с самого начала держит блокировку записи, поэтому параллельные списания выполняются по очереди
+func (ms *SQLiteStorage) AddWithdraw(ctx context.Context, withdraw *domain.Withdraw) error {
+	defer metrics.ObserveQuery("AddWithdraw", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.AddWithdraw")
+	defer span.End()
+	tx, err := ms.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := lockBalance(ctx, tx, withdraw.UserID)
+	if err != nil {
+		return err
+	}
+	// просроченные баллы списываются до проверки, чтобы их нельзя было потратить
+	expiration, err := expireLots(ctx, tx, withdraw.UserID)
+	if err != nil {
+		return err
+	}
+	current -= expiration.Amount
+	if current < withdraw.Sum {
+		return domain.ErrInsufficientFunds
+	}
+	const insertSQL = `insert into transactions (userid,type,number,status,amount,uploaded_at) values (?,'WITHDRAW',?,'PROCESSED',-1*?,?)`
+	_, err = tx.ExecContext(ctx, insertSQL, withdraw.UserID, withdraw.Order, withdraw.Sum, micros(time.Time(withdraw.ProcessedAt)))
+	if err != nil {
+		if isUniqueViolation(err) {
+			return domain.ErrAlreadyExists
+		}
+		// триггер transactions_withdraw_amount_check: сумма списания должна быть положительной
+		if isTriggerViolation(err) {
+			return domain.ErrInvalidAmount
+		}
+		logger.Log.Error("Insert withdraw failed", zap.Error(err))
+		return fmt.Errorf("insert: %w", err)
+	}
+	if err = consumeLots(ctx, tx, withdraw.UserID, withdraw.Sum); err != nil {
+		return err
+	}
+	const updateBalanceSQL = `update balances set current = current - ?2, withdrawn = withdrawn + ?2, updated_at = ?3 where userid = ?1
+                              returning current, withdrawn`
+	balance := &domain.Balance{UserID: withdraw.UserID}
+	err = tx.QueryRowContext(ctx, updateBalanceSQL, withdraw.UserID, withdraw.Sum, micros(time.Now())).Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		logger.Log.Error("Update balance failed", zap.Error(err))
+		return fmt.Errorf("update balance: %w", err)
+	}
+	err = addEvent(ctx, tx, domain.EventPointsWithdrawn, withdraw.UserID, withdraw)
+	if err != nil {
+		return err
+	}
+	err = notifyBalance(tx, balance)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockBalance возвращает текущий баланс пользователя, при необходимости создавая его строку.
+// Блокировать строку не нужно: транзакция уже держит блокировку записи всей БД
+func lockBalance(ctx context.Context, tx *txn, userID int64) (domain.CustomMoney, error) {
+	const insertSQL = `insert into balances (userid,updated_at) values (?,?) on conflict (userid) do nothing`
+	_, err := tx.ExecContext(ctx, insertSQL, userID, micros(time.Now()))
+	if err != nil {
+		logger.Log.Error("Insert balance failed", zap.Error(err))
+		return 0, fmt.Errorf("insert balance: %w", err)
+	}
+	const selectSQL = `select current from balances where userid = ?`
+	var current domain.CustomMoney
+	err = tx.QueryRowContext(ctx, selectSQL, userID).Scan(&current)
+	if err != nil {
+		logger.Log.Error("Lock balance failed", zap.Error(err))
+		return 0, fmt.Errorf("lock balance: %w", err)
+	}
+	return current, nil
+}
+
+func (ms *SQLiteStorage) GetWithdraw(ctx context.Context, orderNumber *string) (*domain.Withdraw, error) {
+	defer metrics.ObserveQuery("GetWithdraw", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetWithdraw")
+	defer span.End()
+	const selectSQL = `select userid,number,-1*amount,uploaded_at from transactions where number = ? and type = 'WITHDRAW'`
+	row := ms.dbConnections.QueryRowContext(ctx, selectSQL, orderNumber)
+	ret := domain.Withdraw{}
+	err := row.Scan(&ret.UserID, &ret.Order, &ret.Sum, (*timestamp)(&ret.ProcessedAt))
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Log.Error("Select user", zap.Error(err))
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return &ret, nil
+}
+
+func (ms *SQLiteStorage) GetUnprocessedOrders(ctx context.Context, batchLimit *int) (*[]domain.Order, error) {
+	defer metrics.ObserveQuery("GetUnprocessedOrders", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetUnprocessedOrders")
+	defer span.End()
+	const selectSQL = `select userid,number,status,uploaded_at from transactions where status in ('NEW','PROCESSING','REGISTERED') and type = 'ORDER' order by uploaded_at limit ?`
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, batchLimit)
+	if err != nil {
+		logger.Log.Error("Select orders", zap.Error(err))
+		return nil, fmt.Errorf("select orders: %w", err)
+	}
+	defer rows.Close()
+	order := domain.Order{}
+	ret := make([]domain.Order, 0, 10)
+	for rows.Next() {
+		err = rows.Scan(&order.UserID, &order.Number, &order.Status, (*timestamp)(&order.UploadedAt))
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		ret = append(ret, order)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select orders", zap.Error(err))
+		return nil, fmt.Errorf("select orders: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+func (ms *SQLiteStorage) CountOrdersByStatus(ctx context.Context) (map[string]int64, error) {
+	defer metrics.ObserveQuery("CountOrdersByStatus", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.CountOrdersByStatus")
+	defer span.End()
+	const selectSQL = `select status, count(*) from transactions where type = 'ORDER' group by status`
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL)
+	if err != nil {
+		logger.Log.Error("Select order statuses", zap.Error(err))
+		return nil, fmt.Errorf("select order statuses: %w", err)
+	}
+	defer rows.Close()
+	ret := make(map[string]int64)
+	for rows.Next() {
+		var status string
+		var count int64
+		if err = rows.Scan(&status, &count); err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		ret[status] = count
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select order statuses", zap.Error(err))
+		return nil, fmt.Errorf("select order statuses: %w", err)
+	}
+	return ret, nil
+}
+
+func (ms *SQLiteStorage) SetProcessedAccruals(ctx context.Context, orders *[]domain.Accrual) error {
+	defer metrics.ObserveQuery("SetProcessedAccruals", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.SetProcessedAccruals")
+	defer span.End()
+	tx, err := ms.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const selectSQL = `select userid, status from transactions where type = 'ORDER' and number = ? and status not in ('PROCESSED','INVALID')`
+	selectStmt, err := tx.PrepareContext(ctx, selectSQL)
+	if err != nil {
+		return fmt.Errorf("prepare sql: %w", err)
+	}
+	defer selectStmt.Close()
+
+	const updateSQL = `update transactions set status = ?1, amount = ?2,
+                           remaining = case when ?1 = 'PROCESSED' then ?2 else 0 end, expires_at = ?4,
+                           credited_at = case when ?1 = 'PROCESSED' then ?5 end
+                       where type = 'ORDER' and number = ?3`
+	stmt, err := tx.PrepareContext(ctx, updateSQL)
+	if err != nil {
+		return fmt.Errorf("prepare sql: %w", err)
+	}
+	defer stmt.Close()
+
+	const creditSQL = `insert into balances (userid, current, updated_at) values (?,?,?)
+                       on conflict (userid) do update set current = balances.current + excluded.current, updated_at = excluded.updated_at
+                       returning current, withdrawn`
+	creditStmt, err := tx.PrepareContext(ctx, creditSQL)
+	if err != nil {
+		return fmt.Errorf("prepare sql: %w", err)
+	}
+	defer creditStmt.Close()
+
+	for _, v := range *orders {
+		if v.Sum == nil {
+			amount := domain.CustomMoney(0)
+			v.Sum = &amount
+		}
+		var userID int64
+		var previousStatus string
+		err = selectStmt.QueryRowContext(ctx, v.Order).Scan(&userID, &previousStatus)
+		if err != nil && errors.Is(err, sql.ErrNoRows) {
+			// заказ уже в финальном статусе
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("exec sql: %w", err)
+		}
+		if _, err = stmt.ExecContext(ctx, v.Status, v.Sum, v.Order, nullMicros(v.ExpiresAt), micros(time.Now())); err != nil {
+			return fmt.Errorf("exec sql: %w", err)
+		}
+		if previousStatus != v.Status {
+			event := &domain.OrderEvent{Number: v.Order, Status: v.Status, PreviousStatus: previousStatus}
+			if err = addEvent(ctx, tx, domain.EventOrderStatusChanged, userID, event); err != nil {
+				return err
+			}
+			event.Accrual = v.Sum
+			if err = addWebhookDeliveries(ctx, tx, domain.NewStatusChangedEvent(userID, *event, time.Now())); err != nil {
+				return err
+			}
+		}
+		if v.Status != "PROCESSED" || *v.Sum == 0 {
+			continue
+		}
+		balance := &domain.Balance{UserID: userID}
+		err = creditStmt.QueryRowContext(ctx, userID, v.Sum, micros(time.Now())).Scan(&balance.Current, &balance.Withdrawn)
+		if err != nil {
+			return fmt.Errorf("credit balance: %w", err)
+		}
+		event := &domain.OrderEvent{Number: v.Order, Status: v.Status, Accrual: v.Sum}
+		if err = addEvent(ctx, tx, domain.EventOrderAccrued, userID, event); err != nil {
+			return err
+		}
+		if err = notifyBalance(tx, balance); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ReconcileBalances пересчитывает балансы по таблице transactions и возвращает расхождения с таблицей balances.
+// При repair расходящиеся балансы исправляются
+func (ms *SQLiteStorage) ReconcileBalances(ctx context.Context, repair *bool) (*[]domain.BalanceDrift, error) {
+	defer metrics.ObserveQuery("ReconcileBalances", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.ReconcileBalances")
+	defer span.End()
+	const selectSQL = `with actual as (
+                           select userid,
+                                  COALESCE(sum(amount),0) current,
+                                  COALESCE(sum(case when type = 'WITHDRAW' then -1*amount else 0 end),0) withdrawn
+                           from transactions
+                           where status = 'PROCESSED'
+                           group by userid)
+                       select COALESCE(a.userid,b.userid),
+                              COALESCE(b.current,0), COALESCE(b.withdrawn,0),
+                              COALESCE(a.current,0), COALESCE(a.withdrawn,0)
+                       from actual a full join balances b on a.userid = b.userid
+                       where COALESCE(a.current,0) <> COALESCE(b.current,0) or COALESCE(a.withdrawn,0) <> COALESCE(b.withdrawn,0)`
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL)
+	if err != nil {
+		logger.Log.Error("Select balance drift", zap.Error(err))
+		return nil, fmt.Errorf("select balance drift: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.BalanceDrift, 0)
+	for rows.Next() {
+		drift := domain.BalanceDrift{}
+		err = rows.Scan(&drift.UserID, &drift.StoredCurrent, &drift.StoredWithdrawn, &drift.ActualCurrent, &drift.ActualWithdrawn)
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		ret = append(ret, drift)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select balance drift", zap.Error(err))
+		return nil, fmt.Errorf("select balance drift: %w", err)
+	}
+	rows.Close()
+	if !*repair {
+		return &ret, nil
+	}
+	for _, v := range ret {
+		if err = ms.repairBalance(ctx, v.UserID); err != nil {
+			return &ret, fmt.Errorf("repair balance: %w", err)
+		}
+	}
+	return &ret, nil
+}
+
+func (ms *SQLiteStorage) repairBalance(ctx context.Context, userID int64) error {
+	tx, err := ms.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = lockBalance(ctx, tx, userID); err != nil {
+		return err
+	}
+	const updateSQL = `update balances
+                       set current = (select COALESCE(sum(amount),0) from transactions where userid = ?1 and status = 'PROCESSED'),
+                           withdrawn = (select COALESCE(sum(-1*amount),0) from transactions
+                                        where userid = ?1 and status = 'PROCESSED' and type = 'WITHDRAW'),
+                           updated_at = ?2
+                       where userid = ?1`
+	if _, err = tx.ExecContext(ctx, updateSQL, userID, micros(time.Now())); err != nil {
+		logger.Log.Error("Repair balance failed", zap.Error(err))
+		return fmt.Errorf("update balance: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ResetOrder возвращает заказ в статус NEW, чтобы цикл обработки снова запросил начисление.
+// Заказ PROCESSED не сбрасывается: начисление уже зачислено на баланс и было бы зачислено повторно
+func (ms *SQLiteStorage) ResetOrder(ctx context.Context, reset *domain.OrderReset) error {
+	defer metrics.ObserveQuery("ResetOrder", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.ResetOrder")
+	defer span.End()
+	tx, err := ms.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	const selectSQL = `select userid,status from transactions where type = 'ORDER' and number = ?`
+	err = tx.QueryRowContext(ctx, selectSQL, reset.Number).Scan(&reset.UserID, &reset.PreviousStatus)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return domain.ErrNotFound
+	}
+	if err != nil {
+		logger.Log.Error("Select order failed", zap.Error(err))
+		return fmt.Errorf("select: %w", err)
+	}
+	if reset.PreviousStatus == "PROCESSED" {
+		return domain.ErrOrderProcessed
+	}
+	const updateSQL = `update transactions set status = 'NEW', amount = 0 where type = 'ORDER' and number = ?`
+	_, err = tx.ExecContext(ctx, updateSQL, reset.Number)
+	if err != nil {
+		logger.Log.Error("Reset order failed", zap.Error(err))
+		return fmt.Errorf("update: %w", err)
+	}
+	if reset.PreviousStatus != "NEW" {
+		event := &domain.OrderEvent{Number: reset.Number, Status: "NEW", PreviousStatus: reset.PreviousStatus}
+		if err = addEvent(ctx, tx, domain.EventOrderStatusChanged, reset.UserID, event); err != nil {
+			return err
+		}
+	}
+	details := map[string]string{"number": reset.Number, "previous_status": reset.PreviousStatus}
+	if err = addAudit(ctx, tx, reset.Audit, reset.UserID, details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,321 @@
+package sqlitestorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
+)
+
+func (ms *SQLiteStorage) GetUser(ctx context.Context, login *string) (*domain.User, error) {
+	defer metrics.ObserveQuery("GetUser", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetUser")
+	defer span.End()
+	const selectSQL = `select id,login,hash,role,locked_at is not null from users where login = ?`
+	row := ms.dbConnections.QueryRowContext(ctx, selectSQL, login)
+	user := domain.User{}
+	err := row.Scan(&user.UserID, &user.Login, &user.Hash, &user.Role, &user.Locked)
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Log.Error("Select login failed", zap.String("login", *login))
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return &user, nil
+}
+
+func (ms *SQLiteStorage) AddUser(ctx context.Context, user *domain.User) error {
+	defer metrics.ObserveQuery("AddUser", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.AddUser")
+	defer span.End()
+	role := user.Role
+	if role == "" {
+		role = domain.RoleUser
+	}
+	const insertSQL = `insert into users (login, hash, role) VALUES (?,?,?)`
+	_, err := ms.dbConnections.ExecContext(ctx, insertSQL, user.Login, user.Hash, role)
+	if err != nil {
+		logger.Log.Error("Insert user failed", zap.Error(err))
+		return fmt.Errorf("insert: %w", err)
+	}
+	return nil
+}
+
+func (ms *SQLiteStorage) UpdateHash(ctx context.Context, user *domain.User) error {
+	defer metrics.ObserveQuery("UpdateHash", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.UpdateHash")
+	defer span.End()
+	const updateSQL = `update users set hash = ? where id = ?`
+	_, err := ms.dbConnections.ExecContext(ctx, updateSQL, user.Hash, user.UserID)
+	if err != nil {
+		logger.Log.Error("Update hash failed", zap.Error(err))
+		return fmt.Errorf("update: %w", err)
+	}
+	return nil
+}
+
+func (ms *SQLiteStorage) RevokeToken(ctx context.Context, token *domain.RevokedToken) error {
+	defer metrics.ObserveQuery("RevokeToken", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.RevokeToken")
+	defer span.End()
+	now := micros(time.Now())
+	const deleteSQL = `delete from revoked_tokens where expires_at < ?`
+	_, err := ms.dbConnections.ExecContext(ctx, deleteSQL, now)
+	if err != nil {
+		logger.Log.Error("Delete expired tokens failed", zap.Error(err))
+		return fmt.Errorf("delete expired: %w", err)
+	}
+	const insertSQL = `insert into revoked_tokens (jti, userid, expires_at, revoked_at) values (?,?,?,?) on conflict (jti) do nothing`
+	res, err := ms.dbConnections.ExecContext(ctx, insertSQL, token.ID, token.UserID, micros(token.ExpiresAt), now)
+	if err != nil {
+		logger.Log.Error("Insert revoked token failed", zap.Error(err))
+		return fmt.Errorf("insert: %w", err)
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if inserted == 0 {
+		return domain.ErrAlreadyExists
+	}
+	return nil
+}
+
+func (ms *SQLiteStorage) IsTokenRevoked(ctx context.Context, jti *string) (bool, error) {
+	defer metrics.ObserveQuery("IsTokenRevoked", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.IsTokenRevoked")
+	defer span.End()
+	const selectSQL = `select exists(select 1 from revoked_tokens where jti = ?)`
+	var revoked bool
+	err := ms.dbConnections.QueryRowContext(ctx, selectSQL, jti).Scan(&revoked)
+	if err != nil {
+		logger.Log.Error("Select revoked token failed", zap.Error(err))
+		return false, fmt.Errorf("select: %w", err)
+	}
+	return revoked, nil
+}
+
+func (ms *SQLiteStorage) GetUserInfo(ctx context.Context, userID *int64) (*domain.UserInfo, error) {
+	defer metrics.ObserveQuery("GetUserInfo", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetUserInfo")
+	defer span.End()
+	const selectSQL = `select id,login,role,locked_at from users where id = ?`
+	user, err := scanUserInfo(ms.dbConnections.QueryRowContext(ctx, selectSQL, userID))
+	if err != nil && errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		logger.Log.Error("Select user failed", zap.Error(err))
+		return nil, fmt.Errorf("select: %w", err)
+	}
+	return user, nil
+}
+
+// SearchUsers ищет пользователей по подстроке логина; like в SQLite не учитывает регистр латиницы
+func (ms *SQLiteStorage) SearchUsers(ctx context.Context, search *domain.UserSearch) (*[]domain.UserInfo, error) {
+	defer metrics.ObserveQuery("SearchUsers", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.SearchUsers")
+	defer span.End()
+	const selectSQL = `select id,login,role,locked_at from users where login like ? escape '\' order by login limit ?`
+	pattern := "%" + likeEscaper.Replace(search.Login) + "%"
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, pattern, search.Limit)
+	if err != nil {
+		logger.Log.Error("Select users failed", zap.Error(err))
+		return nil, fmt.Errorf("select users: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.UserInfo, 0, search.Limit)
+	for rows.Next() {
+		user, err := scanUserInfo(rows)
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		ret = append(ret, *user)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select users failed", zap.Error(err))
+		return nil, fmt.Errorf("select users: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func scanUserInfo(row interface{ Scan(dest ...any) error }) (*domain.UserInfo, error) {
+	user := domain.UserInfo{}
+	var lockedAt sql.NullInt64
+	if err := row.Scan(&user.ID, &user.Login, &user.Role, &lockedAt); err != nil {
+		return nil, err
+	}
+	user.LockedAt = nullTime(lockedAt)
+	return &user, nil
+}
+
+func (ms *SQLiteStorage) IsUserLocked(ctx context.Context, userID *int64) (bool, error) {
+	defer metrics.ObserveQuery("IsUserLocked", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.IsUserLocked")
+	defer span.End()
+	const selectSQL = `select exists(select 1 from users where id = ? and locked_at is not null)`
+	var locked bool
+	err := ms.dbConnections.QueryRowContext(ctx, selectSQL, userID).Scan(&locked)
+	if err != nil {
+		logger.Log.Error("Select user lock failed", zap.Error(err))
+		return false, fmt.Errorf("select: %w", err)
+	}
+	return locked, nil
+}
+
+// SetLocked блокирует или разблокирует пользователя; повторная блокировка сохраняет исходное время
+func (ms *SQLiteStorage) SetLocked(ctx context.Context, lock *domain.UserLock) error {
+	defer metrics.ObserveQuery("SetLocked", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.SetLocked")
+	defer span.End()
+	tx, err := ms.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	updateSQL := `update users set locked_at = coalesce(locked_at, ?) where id = ?`
+	args := []any{micros(time.Now()), lock.UserID}
+	if !lock.Locked {
+		updateSQL = `update users set locked_at = null where id = ?`
+		args = args[1:]
+	}
+	res, err := tx.ExecContext(ctx, updateSQL, args...)
+	if err != nil {
+		logger.Log.Error("Update user lock failed", zap.Error(err))
+		return fmt.Errorf("update: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if updated == 0 {
+		return domain.ErrNotFound
+	}
+	if err = addAudit(ctx, tx, lock.Audit, lock.UserID, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ms *SQLiteStorage) SetRole(ctx context.Context, role *domain.UserRole) error {
+	defer metrics.ObserveQuery("SetRole", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.SetRole")
+	defer span.End()
+	const updateSQL = `update users set role = ? where login = ?`
+	res, err := ms.dbConnections.ExecContext(ctx, updateSQL, role.Role, role.Login)
+	if err != nil {
+		logger.Log.Error("Update user role failed", zap.Error(err))
+		return fmt.Errorf("update: %w", err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("rows affected: %w", err)
+	}
+	if updated == 0 {
+		return domain.ErrNotFound
+	}
+	return nil
+}
+
+func (ms *SQLiteStorage) AddAudit(ctx context.Context, record *domain.AuditRecord) error {
+	defer metrics.ObserveQuery("AddAudit", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.AddAudit")
+	defer span.End()
+	return insertAudit(ctx, ms.dbConnections, record)
+}
+
+// addAudit дополняет запись журнала администратора пользователем target и подробностями details и сохраняет её
+// в транзакции действия, чтобы действие не выполнилось без записи в журнале. Без записи ничего не делает
+func addAudit(ctx context.Context, tx *txn, record *domain.AuditRecord, target int64, details any) error {
+	if record == nil {
+		return nil
+	}
+	record.TargetUser = &target
+	if details != nil {
+		body, err := json.Marshal(details)
+		if err != nil {
+			return fmt.Errorf("marshal audit details: %w", err)
+		}
+		record.Details = body
+	}
+	return insertAudit(ctx, tx, record)
+}
+
+// queryRower - соединение с БД или транзакция
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func insertAudit(ctx context.Context, q queryRower, record *domain.AuditRecord) error {
+	details := "{}"
+	if len(record.Details) > 0 {
+		details = string(record.Details)
+	}
+	now := time.Now()
+	const insertSQL = `insert into admin_audit (operator_id,action,target_user,details,created_at) values (?,?,?,?,?) returning id`
+	err := q.QueryRowContext(ctx, insertSQL, record.OperatorID, record.Action, record.TargetUser, details, micros(now)).
+		Scan(&record.ID)
+	if err != nil {
+		logger.Log.Error("Insert audit failed", zap.Error(err))
+		return fmt.Errorf("insert audit: %w", err)
+	}
+	record.CreatedAt = domain.CustomTime(now)
+	return nil
+}
+
+// GetAudit возвращает последние записи журнала, при filter.TargetUser != 0 - только по этому пользователю
+func (ms *SQLiteStorage) GetAudit(ctx context.Context, filter *domain.AuditFilter) (*[]domain.AuditRecord, error) {
+	defer metrics.ObserveQuery("GetAudit", time.Now())
+	ctx, span := tracing.StartSQLite(ctx, "SQLiteStorage.GetAudit")
+	defer span.End()
+	const selectSQL = `select id,operator_id,action,target_user,details,created_at from admin_audit
+                       where ?1 = 0 or target_user = ?1
+                       order by id desc limit ?2`
+	rows, err := ms.dbConnections.QueryContext(ctx, selectSQL, filter.TargetUser, filter.Limit)
+	if err != nil {
+		logger.Log.Error("Select audit failed", zap.Error(err))
+		return nil, fmt.Errorf("select audit: %w", err)
+	}
+	defer rows.Close()
+	ret := make([]domain.AuditRecord, 0, filter.Limit)
+	for rows.Next() {
+		record := domain.AuditRecord{}
+		var targetUser sql.NullInt64
+		var details string
+		err = rows.Scan(&record.ID, &record.OperatorID, &record.Action, &targetUser, &details, (*timestamp)(&record.CreatedAt))
+		if err != nil {
+			logger.Log.Error("Scan rows", zap.Error(err))
+			return nil, fmt.Errorf("scan rows: %w", err)
+		}
+		if targetUser.Valid {
+			record.TargetUser = &targetUser.Int64
+		}
+		record.Details = json.RawMessage(details)
+		ret = append(ret, record)
+	}
+	if err = rows.Err(); err != nil {
+		logger.Log.Error("Select audit failed", zap.Error(err))
+		return nil, fmt.Errorf("select audit: %w", err)
+	}
+	if len(ret) == 0 {
+		return nil, nil
+	}
+	return &ret, nil
+}
@@ -0,0 +1,661 @@
+// Package storagetest - общие проверки поведения хранилищ. PostgreSQL, SQLite и хранилище в памяти должны проходить
+// их одинаково: тесты каждого хранилища вызывают Run со своей фабрикой
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"loyalty-system/internal/domain"
+)
+
+type Users interface {
+	AddUser(ctx context.Context, user *domain.User) error
+	GetUser(ctx context.Context, login *string) (*domain.User, error)
+	SetLocked(ctx context.Context, lock *domain.UserLock) error
+	GetAudit(ctx context.Context, filter *domain.AuditFilter) (*[]domain.AuditRecord, error)
+}
+
+type Transactions interface {
+	AddOrder(ctx context.Context, order *domain.Order) error
+	AddOrders(ctx context.Context, batch *domain.OrderBatch) (*[]domain.BatchOrder, error)
+	GetOrder(ctx context.Context, orderNumber *string) (*domain.Order, error)
+	AddWithdraw(ctx context.Context, withdraw *domain.Withdraw) error
+	AddAdjustment(ctx context.Context, adjustment *domain.Adjustment) error
+	ResetOrder(ctx context.Context, reset *domain.OrderReset) error
+	GetBalance(ctx context.Context, UserID *int64) (*domain.Balance, error)
+	GetLedger(ctx context.Context, filter *domain.ListFilter) (*[]domain.LedgerEntry, error)
+	GetStatement(ctx context.Context, filter *domain.ListFilter) (*domain.Statement, error)
+	SetProcessedAccruals(ctx context.Context, orders *[]domain.Accrual) error
+	ExpirePoints(ctx context.Context, limit *int) (*[]domain.Expiration, error)
+	GetExpiringPoints(ctx context.Context, filter *domain.ExpiryFilter) (*domain.ExpiringPoints, error)
+	GetPendingEvents(ctx context.Context, limit *int) (*[]domain.Event, error)
+	MarkEventsDelivered(ctx context.Context, ids *[]int64) error
+	MarkEventsFailed(ctx context.Context, failure *domain.EventFailure) error
+	DeleteEvents(ctx context.Context, cleanup *domain.EventCleanup) (int64, error)
+	AddWebhook(ctx context.Context, webhook *domain.Webhook) error
+	GetWebhookDeliveries(ctx context.Context, webhook *domain.Webhook) (*[]domain.WebhookDelivery, error)
+	SaveDeliveryResult(ctx context.Context, delivery *domain.WebhookDelivery) error
+	DeleteDeliveries(ctx context.Context, olderThan *time.Time) (int64, error)
+	GetDueDeliveries(ctx context.Context, limit *int) (*[]domain.WebhookDelivery, error)
+}
+
+// Storage - проверяемое хранилище; у PostgreSQL пользователи и операции - разные объекты, у остальных - один
+type Storage struct {
+	Users        Users
+	Transactions Transactions
+}
+
+// Factory создаёт пустое хранилище для одного теста
+type Factory func(t *testing.T) Storage
+
+// Run выполняет все проверки, каждую - на новом хранилище
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s Storage)
+	}{
+		{"UserRole", testUserRole},
+		{"OrderUniqueness", testOrderUniqueness},
+		{"BalanceMath", testBalanceMath},
+		{"Expiry", testExpiry},
+		{"FIFOLots", testFIFOLots},
+		{"LedgerCreditTime", testLedgerCreditTime},
+		{"OutboxLease", testOutboxLease},
+		{"WebhookDeliveries", testWebhookDeliveries},
+		{"WebhookLimit", testWebhookLimit},
+		{"AtomicAudit", testAtomicAudit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+// base - время загрузки первой операции теста; округлено, чтобы все хранилища сохраняли его без потерь
+var base = time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+
+func addUser(t *testing.T, s Storage, login string) int64 {
+	t.Helper()
+	ctx := context.Background()
+	if err := s.Users.AddUser(ctx, &domain.User{Login: login, Hash: "hash"}); err != nil {
+		t.Fatalf("AddUser(%s): %v", login, err)
+	}
+	user, err := s.Users.GetUser(ctx, &login)
+	if err != nil || user == nil {
+		t.Fatalf("GetUser(%s) = %v, %v", login, user, err)
+	}
+	return user.UserID
+}
+
+func addOrder(t *testing.T, s Storage, userID int64, number string, uploadedAt time.Time) error {
+	t.Helper()
+	amount := domain.CustomMoney(0)
+	order := &domain.Order{UserID: userID, Number: number, Status: "NEW", Accrual: &amount, UploadedAt: domain.CustomTime(uploadedAt)}
+	return s.Transactions.AddOrder(context.Background(), order)
+}
+
+// accrue загружает заказ и проводит по нему начисление sum со сроком действия expiresAt
+func accrue(t *testing.T, s Storage, userID int64, number string, sum domain.CustomMoney, uploadedAt time.Time, expiresAt *time.Time) {
+	t.Helper()
+	if err := addOrder(t, s, userID, number, uploadedAt); err != nil {
+		t.Fatalf("AddOrder(%s): %v", number, err)
+	}
+	accruals := []domain.Accrual{{Order: number, Status: "PROCESSED", Sum: &sum, ExpiresAt: expiresAt}}
+	if err := s.Transactions.SetProcessedAccruals(context.Background(), &accruals); err != nil {
+		t.Fatalf("SetProcessedAccruals(%s): %v", number, err)
+	}
+}
+
+func withdraw(s Storage, userID int64, number string, sum domain.CustomMoney) error {
+	w := &domain.Withdraw{UserID: userID, Order: number, Sum: sum, ProcessedAt: domain.CustomTime(time.Now().Truncate(time.Second))}
+	return s.Transactions.AddWithdraw(context.Background(), w)
+}
+
+func adjust(s Storage, userID, operatorID int64, number string, amount domain.CustomMoney) error {
+	adjustment := &domain.Adjustment{UserID: userID, OperatorID: operatorID, Number: number, Amount: amount, Reason: "test",
+		ProcessedAt: domain.CustomTime(time.Now().Truncate(time.Second))}
+	return s.Transactions.AddAdjustment(context.Background(), adjustment)
+}
+
+func checkBalance(t *testing.T, s Storage, userID int64, current, withdrawn domain.CustomMoney) {
+	t.Helper()
+	balance, err := s.Transactions.GetBalance(context.Background(), &userID)
+	if err != nil {
+		t.Fatalf("GetBalance: %v", err)
+	}
+	if balance.Current != current || balance.Withdrawn != withdrawn {
+		t.Errorf("balance = %v/%v, want %v/%v", balance.Current, balance.Withdrawn, current, withdrawn)
+	}
+}
+
+func expiring(t *testing.T, s Storage, userID int64, before time.Time) domain.CustomMoney {
+	t.Helper()
+	points, err := s.Transactions.GetExpiringPoints(context.Background(), &domain.ExpiryFilter{UserID: userID, Before: before})
+	if err != nil {
+		t.Fatalf("GetExpiringPoints: %v", err)
+	}
+	if points == nil {
+		return 0
+	}
+	return points.Amount
+}
+
+// пользователь создаётся сразу с ролью; без роли он обычный пользователь
+func testUserRole(t *testing.T, s Storage) {
+	ctx := context.Background()
+	for _, user := range []domain.User{{Login: "admin", Hash: "hash", Role: domain.RoleAdmin}, {Login: "user", Hash: "hash"}} {
+		if err := s.Users.AddUser(ctx, &user); err != nil {
+			t.Fatalf("AddUser(%s): %v", user.Login, err)
+		}
+	}
+	for login, want := range map[string]string{"admin": domain.RoleAdmin, "user": domain.RoleUser} {
+		user, err := s.Users.GetUser(ctx, &login)
+		if err != nil || user == nil || user.Role != want {
+			t.Errorf("GetUser(%s) = %+v, %v; want role %s", login, user, err, want)
+		}
+	}
+}
+
+func testOrderUniqueness(t *testing.T, s Storage) {
+	ctx := context.Background()
+	alice := addUser(t, s, "alice")
+	bob := addUser(t, s, "bob")
+	if err := addOrder(t, s, alice, "100", base); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	if err := addOrder(t, s, alice, "100", base); !errors.Is(err, domain.ErrAlreadyExists) {
+		t.Errorf("same user, same number: err = %v, want ErrAlreadyExists", err)
+	}
+	if err := addOrder(t, s, bob, "100", base); !errors.Is(err, domain.ErrAlreadyExists) {
+		t.Errorf("other user, same number: err = %v, want ErrAlreadyExists", err)
+	}
+	number := "100"
+	order, err := s.Transactions.GetOrder(ctx, &number)
+	if err != nil || order == nil || order.UserID != alice {
+		t.Fatalf("GetOrder = %+v, %v; want owner %d", order, err, alice)
+	}
+
+	batch := &domain.OrderBatch{UserID: bob, Numbers: []string{"100", "300"}, UploadedAt: domain.CustomTime(base)}
+	results, err := s.Transactions.AddOrders(ctx, batch)
+	if err != nil {
+		t.Fatalf("AddOrders: %v", err)
+	}
+	want := map[string]domain.BatchOrder{
+		"100": {Number: "100", Accepted: false, OwnerID: alice},
+		"300": {Number: "300", Accepted: true, OwnerID: bob},
+	}
+	for _, v := range *results {
+		if v != want[v.Number] {
+			t.Errorf("AddOrders result %+v, want %+v", v, want[v.Number])
+		}
+		delete(want, v.Number)
+	}
+	if len(want) != 0 {
+		t.Errorf("AddOrders: no result for %v", want)
+	}
+
+	// номер уникален в пределах типа операции: списание может иметь номер загруженного заказа
+	accrue(t, s, alice, "200", 5000, base, nil)
+	if err = withdraw(s, alice, "100", 1000); err != nil {
+		t.Fatalf("withdraw with order number: %v", err)
+	}
+	if err = withdraw(s, alice, "100", 1000); !errors.Is(err, domain.ErrAlreadyExists) {
+		t.Errorf("repeated withdraw: err = %v, want ErrAlreadyExists", err)
+	}
+	checkBalance(t, s, alice, 4000, 1000)
+}
+
+func testBalanceMath(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := addUser(t, s, "user")
+	operator := addUser(t, s, "operator")
+	checkBalance(t, s, user, 0, 0)
+	accrue(t, s, user, "1", 50000, base, nil)
+	checkBalance(t, s, user, 50000, 0)
+
+	// повторный результат по заказу в финальном статусе не начисляет баллы ещё раз
+	sum := domain.CustomMoney(50000)
+	again := []domain.Accrual{{Order: "1", Status: "PROCESSED", Sum: &sum}}
+	if err := s.Transactions.SetProcessedAccruals(ctx, &again); err != nil {
+		t.Fatalf("SetProcessedAccruals: %v", err)
+	}
+	checkBalance(t, s, user, 50000, 0)
+
+	if err := withdraw(s, user, "W1", 20000); err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+	checkBalance(t, s, user, 30000, 20000)
+	if err := withdraw(s, user, "W2", 30001); !errors.Is(err, domain.ErrInsufficientFunds) {
+		t.Errorf("withdraw over balance: err = %v, want ErrInsufficientFunds", err)
+	}
+	checkBalance(t, s, user, 30000, 20000)
+	if err := withdraw(s, user, "W3", 30000); err != nil {
+		t.Fatalf("withdraw whole balance: %v", err)
+	}
+	checkBalance(t, s, user, 0, 50000)
+	// нулевое или отрицательное списание увеличило бы баланс
+	for number, sum := range map[string]domain.CustomMoney{"W4": 0, "W5": -10000} {
+		if err := withdraw(s, user, number, sum); !errors.Is(err, domain.ErrInvalidAmount) {
+			t.Errorf("withdraw of %d: err = %v, want ErrInvalidAmount", sum, err)
+		}
+	}
+	checkBalance(t, s, user, 0, 50000)
+
+	if err := adjust(s, user, operator, "ADJ-1", 5000); err != nil {
+		t.Fatalf("positive adjustment: %v", err)
+	}
+	checkBalance(t, s, user, 5000, 50000)
+	if err := adjust(s, user, operator, "ADJ-2", -5001); !errors.Is(err, domain.ErrInsufficientFunds) {
+		t.Errorf("adjustment below zero: err = %v, want ErrInsufficientFunds", err)
+	}
+	// списание корректировкой уменьшает текущий баланс, но не сумму выведенных баллов
+	if err := adjust(s, user, operator, "ADJ-3", -2000); err != nil {
+		t.Fatalf("negative adjustment: %v", err)
+	}
+	checkBalance(t, s, user, 3000, 50000)
+	if err := adjust(s, user, operator, "ADJ-3", 100); !errors.Is(err, domain.ErrAlreadyExists) {
+		t.Errorf("repeated adjustment: err = %v, want ErrAlreadyExists", err)
+	}
+	if err := adjust(s, -1, operator, "ADJ-4", 100); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("adjustment of unknown user: err = %v, want ErrNotFound", err)
+	}
+}
+
+func testExpiry(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := addUser(t, s, "user")
+	expired := time.Now().Add(-time.Hour).Truncate(time.Second)
+	accrue(t, s, user, "1", 10000, base, &expired)
+	accrue(t, s, user, "2", 3000, base.Add(time.Minute), nil)
+	checkBalance(t, s, user, 13000, 0)
+
+	// просроченные баллы нельзя потратить, а неудачное списание ничего не меняет
+	if err := withdraw(s, user, "W1", 5000); !errors.Is(err, domain.ErrInsufficientFunds) {
+		t.Fatalf("withdraw of expired points: err = %v, want ErrInsufficientFunds", err)
+	}
+	checkBalance(t, s, user, 13000, 0)
+
+	limit := 10
+	expirations, err := s.Transactions.ExpirePoints(ctx, &limit)
+	if err != nil {
+		t.Fatalf("ExpirePoints: %v", err)
+	}
+	if expirations == nil || len(*expirations) != 1 {
+		t.Fatalf("ExpirePoints = %v, want one expiration", expirations)
+	}
+	if got := (*expirations)[0]; got.UserID != user || got.Amount != 10000 || got.Lots != 1 {
+		t.Errorf("expiration = %+v, want user %d, 100.00 from 1 lot", got, user)
+	}
+	checkBalance(t, s, user, 3000, 0)
+	expirations, err = s.Transactions.ExpirePoints(ctx, &limit)
+	if err != nil {
+		t.Fatalf("ExpirePoints: %v", err)
+	}
+	if expirations != nil && len(*expirations) != 0 {
+		t.Errorf("second ExpirePoints = %v, want nothing", *expirations)
+	}
+	if err = withdraw(s, user, "W2", 3000); err != nil {
+		t.Fatalf("withdraw of unexpired points: %v", err)
+	}
+	checkBalance(t, s, user, 0, 3000)
+
+	// списание сжигает просроченные баллы само, не дожидаясь ExpirePoints
+	accrue(t, s, user, "3", 4000, base.Add(2*time.Minute), &expired)
+	accrue(t, s, user, "4", 1000, base.Add(3*time.Minute), nil)
+	if err = withdraw(s, user, "W3", 1000); err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+	checkBalance(t, s, user, 0, 4000)
+}
+
+func testFIFOLots(t *testing.T, s Storage) {
+	user := addUser(t, s, "user")
+	operator := addUser(t, s, "operator")
+	now := time.Now().Truncate(time.Second)
+	late := now.Add(10 * 24 * time.Hour)
+	soon := now.Add(24 * time.Hour)
+	// старое начисление сгорает позже нового, а самое старое бессрочно: списание гасит остатки по сроку сгорания
+	accrue(t, s, user, "0", 2000, base.Add(-time.Minute), nil)
+	accrue(t, s, user, "1", 10000, base, &late)
+	accrue(t, s, user, "2", 5000, base.Add(time.Minute), &soon)
+	if got := expiring(t, s, user, now.Add(30*24*time.Hour)); got != 15000 {
+		t.Fatalf("expiring = %v, want 150.00", got)
+	}
+
+	if err := withdraw(s, user, "W1", 8000); err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+	if got := expiring(t, s, user, now.Add(2*24*time.Hour)); got != 0 {
+		t.Errorf("after withdraw 80: expiring soon = %v, want 0 (sooner lot consumed first)", got)
+	}
+	if got := expiring(t, s, user, now.Add(30*24*time.Hour)); got != 7000 {
+		t.Errorf("after withdraw 80: expiring = %v, want 70.00", got)
+	}
+
+	// списание корректировкой гасит остатки в том же порядке
+	if err := adjust(s, user, operator, "ADJ-1", -4000); err != nil {
+		t.Fatalf("negative adjustment: %v", err)
+	}
+	if got := expiring(t, s, user, now.Add(30*24*time.Hour)); got != 3000 {
+		t.Errorf("after adjustment -40: expiring = %v, want 30.00", got)
+	}
+	checkBalance(t, s, user, 5000, 8000)
+
+	// бессрочный остаток гасится последним
+	if err := withdraw(s, user, "W2", 4000); err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+	if got := expiring(t, s, user, now.Add(30*24*time.Hour)); got != 0 {
+		t.Errorf("after withdraw 40: expiring = %v, want 0 (lot without expiry consumed last)", got)
+	}
+	checkBalance(t, s, user, 1000, 12000)
+}
+
+// начисление по заказу попадает в журнал и выписку временем зачисления, а не временем загрузки заказа
+func testLedgerCreditTime(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := addUser(t, s, "user")
+	operator := addUser(t, s, "operator")
+	adjustment := &domain.Adjustment{UserID: user, OperatorID: operator, Number: "ADJ-1", Amount: 1000, Reason: "test",
+		ProcessedAt: domain.CustomTime(base.Add(30 * time.Minute))}
+	if err := s.Transactions.AddAdjustment(ctx, adjustment); err != nil {
+		t.Fatalf("AddAdjustment: %v", err)
+	}
+	from := base.Add(time.Hour)
+	accrue(t, s, user, "1", 5000, base, nil)
+
+	ledger, err := s.Transactions.GetLedger(ctx, &domain.ListFilter{UserID: user})
+	if err != nil {
+		t.Fatalf("GetLedger: %v", err)
+	}
+	if ledger == nil || len(*ledger) != 2 {
+		t.Fatalf("ledger = %v, want 2 entries", ledger)
+	}
+	// журнал идёт от новых к старым: заказ зачислен после корректировки, хотя загружен раньше неё
+	if got := (*ledger)[0]; got.Number != "1" || got.Balance != 6000 || time.Time(got.ProcessedAt).Before(from) {
+		t.Errorf("ledger[0] = %+v, want order 1 credited now with balance 60.00", got)
+	}
+	if got := (*ledger)[1]; got.Number != "ADJ-1" || got.Balance != 1000 {
+		t.Errorf("ledger[1] = %+v, want ADJ-1 with balance 10.00", got)
+	}
+
+	statement, err := s.Transactions.GetStatement(ctx, &domain.ListFilter{UserID: user, From: &from})
+	if err != nil {
+		t.Fatalf("GetStatement: %v", err)
+	}
+	if statement.Opening != 1000 || statement.Credited != 5000 || statement.Closing != 6000 ||
+		len(statement.Movements) != 1 || statement.Movements[0].Number != "1" {
+		t.Errorf("statement = %+v, want opening 10.00 and order 1 credited in the period", statement)
+	}
+}
+
+func testOutboxLease(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := addUser(t, s, "user")
+	for i, number := range []string{"1", "2", "3"} {
+		if err := addOrder(t, s, user, number, base.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+	limit := 2
+	first, err := s.Transactions.GetPendingEvents(ctx, &limit)
+	if err != nil || first == nil || len(*first) != 2 {
+		t.Fatalf("GetPendingEvents = %v, %v; want 2 events", first, err)
+	}
+	if (*first)[0].ID >= (*first)[1].ID {
+		t.Errorf("events out of order: %d, %d", (*first)[0].ID, (*first)[1].ID)
+	}
+	for _, e := range *first {
+		if e.Type != domain.EventOrderAccepted || e.UserID != user {
+			t.Errorf("event = %+v, want %s of user %d", e, domain.EventOrderAccepted, user)
+		}
+	}
+
+	// события в аренде не выдаются другому публикатору
+	limit = 10
+	second, err := s.Transactions.GetPendingEvents(ctx, &limit)
+	if err != nil || second == nil || len(*second) != 1 {
+		t.Fatalf("second GetPendingEvents = %v, %v; want the one remaining event", second, err)
+	}
+	if (*second)[0].ID <= (*first)[1].ID {
+		t.Errorf("second lease returned event %d, already leased", (*second)[0].ID)
+	}
+	if third, err := s.Transactions.GetPendingEvents(ctx, &limit); err != nil || (third != nil && len(*third) != 0) {
+		t.Fatalf("third GetPendingEvents = %v, %v; want nothing", third, err)
+	}
+
+	delivered := []int64{(*first)[0].ID, (*first)[1].ID}
+	if err = s.Transactions.MarkEventsDelivered(ctx, &delivered); err != nil {
+		t.Fatalf("MarkEventsDelivered: %v", err)
+	}
+	failure := &domain.EventFailure{IDs: []int64{(*second)[0].ID}, Error: "sink unavailable"}
+	if err = s.Transactions.MarkEventsFailed(ctx, failure); err != nil {
+		t.Fatalf("MarkEventsFailed: %v", err)
+	}
+	// неудачное событие остаётся в аренде до её окончания, доставленные не выдаются больше никогда
+	if again, err := s.Transactions.GetPendingEvents(ctx, &limit); err != nil || (again != nil && len(*again) != 0) {
+		t.Errorf("GetPendingEvents after marks = %v, %v; want nothing", again, err)
+	}
+
+	// очистка удаляет доставленные события, а недоставленные - только когда это явно запрошено
+	later := time.Now().Add(time.Minute)
+	deleted, err := s.Transactions.DeleteEvents(ctx, &domain.EventCleanup{DeliveredBefore: later})
+	if err != nil || deleted != 2 {
+		t.Fatalf("DeleteEvents(delivered) = %d, %v; want 2", deleted, err)
+	}
+	deleted, err = s.Transactions.DeleteEvents(ctx, &domain.EventCleanup{DeliveredBefore: later, PendingBefore: &later})
+	if err != nil || deleted != 1 {
+		t.Fatalf("DeleteEvents(pending) = %d, %v; want 1", deleted, err)
+	}
+	// идентификаторы новых событий не повторяют удалённые
+	if err = addOrder(t, s, user, "4", base.Add(3*time.Minute)); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	fresh, err := s.Transactions.GetPendingEvents(ctx, &limit)
+	if err != nil || fresh == nil || len(*fresh) != 1 || (*fresh)[0].ID <= (*second)[0].ID {
+		t.Fatalf("GetPendingEvents after cleanup = %v, %v; want one new event", fresh, err)
+	}
+}
+
+func testWebhookDeliveries(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := addUser(t, s, "user")
+	other := addUser(t, s, "other")
+	webhooks := []*domain.Webhook{
+		{UserID: user, URL: "https://example.com/a", Secret: "a"},
+		{UserID: user, URL: "https://example.com/b", Secret: "b"},
+		{UserID: other, URL: "https://example.com/c", Secret: "c"},
+	}
+	for _, v := range webhooks {
+		if err := s.Transactions.AddWebhook(ctx, v); err != nil {
+			t.Fatalf("AddWebhook: %v", err)
+		}
+	}
+	if err := addOrder(t, s, user, "1", base); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	sum := domain.CustomMoney(1000)
+	accruals := []domain.Accrual{{Order: "1", Status: "PROCESSED", Sum: &sum}}
+	if err := s.Transactions.SetProcessedAccruals(ctx, &accruals); err != nil {
+		t.Fatalf("SetProcessedAccruals: %v", err)
+	}
+	// заказ уже обработан: повторный результат не меняет статус и не ставит уведомлений
+	if err := s.Transactions.SetProcessedAccruals(ctx, &accruals); err != nil {
+		t.Fatalf("SetProcessedAccruals: %v", err)
+	}
+
+	limit := 10
+	deliveries, err := s.Transactions.GetDueDeliveries(ctx, &limit)
+	if err != nil {
+		t.Fatalf("GetDueDeliveries: %v", err)
+	}
+	if deliveries == nil || len(*deliveries) != 2 {
+		t.Fatalf("GetDueDeliveries = %v, want one delivery per webhook of the user", deliveries)
+	}
+	for _, v := range *deliveries {
+		if v.EventType != domain.EventOrderStatusChanged {
+			t.Errorf("delivery event = %s, want %s", v.EventType, domain.EventOrderStatusChanged)
+		}
+		if v.URL == "https://example.com/c" {
+			t.Errorf("delivery to webhook of another user")
+		}
+	}
+
+	// журнал доставок виден только владельцу вебхука; у вебхука без доставок журнал пустой
+	journal, err := s.Transactions.GetWebhookDeliveries(ctx, &domain.Webhook{ID: webhooks[0].ID, UserID: user})
+	if err != nil || journal == nil || len(*journal) != 1 {
+		t.Errorf("GetWebhookDeliveries of own webhook = %v, %v, want one delivery", journal, err)
+	}
+	journal, err = s.Transactions.GetWebhookDeliveries(ctx, &domain.Webhook{ID: webhooks[2].ID, UserID: other})
+	if err != nil || journal != nil {
+		t.Errorf("GetWebhookDeliveries of webhook without deliveries = %v, %v, want nil, nil", journal, err)
+	}
+	_, err = s.Transactions.GetWebhookDeliveries(ctx, &domain.Webhook{ID: webhooks[2].ID, UserID: user})
+	if !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("GetWebhookDeliveries of another user's webhook: err = %v, want ErrNotFound", err)
+	}
+
+	// очистка удаляет старые доставленные и проваленные уведомления, ожидающие доставки остаются
+	deliveredAt := domain.CustomTime(time.Now())
+	results := []domain.WebhookDelivery{
+		{ID: (*deliveries)[0].ID, WebhookID: (*deliveries)[0].WebhookID, Status: domain.DeliveryDelivered, Attempts: 1, DeliveredAt: &deliveredAt},
+		{ID: (*deliveries)[1].ID, WebhookID: (*deliveries)[1].WebhookID, Status: domain.DeliveryFailed, Attempts: 10, LastError: "timeout"},
+	}
+	for i := range results {
+		if err = s.Transactions.SaveDeliveryResult(ctx, &results[i]); err != nil {
+			t.Fatalf("SaveDeliveryResult: %v", err)
+		}
+	}
+	if err = addOrder(t, s, user, "2", base); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	accruals = []domain.Accrual{{Order: "2", Status: "PROCESSED", Sum: &sum}}
+	if err = s.Transactions.SetProcessedAccruals(ctx, &accruals); err != nil {
+		t.Fatalf("SetProcessedAccruals: %v", err)
+	}
+	earlier, later := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if deleted, err := s.Transactions.DeleteDeliveries(ctx, &earlier); err != nil || deleted != 0 {
+		t.Errorf("DeleteDeliveries(recent) = %d, %v; want 0", deleted, err)
+	}
+	if deleted, err := s.Transactions.DeleteDeliveries(ctx, &later); err != nil || deleted != 2 {
+		t.Errorf("DeleteDeliveries(old) = %d, %v; want 2", deleted, err)
+	}
+	deliveries, err = s.Transactions.GetDueDeliveries(ctx, &limit)
+	if err != nil || deliveries == nil || len(*deliveries) != 2 {
+		t.Errorf("GetDueDeliveries after cleanup = %v, %v; want two pending deliveries", deliveries, err)
+	}
+}
+
+// параллельные регистрации не превышают лимит вебхуков пользователя
+func testWebhookLimit(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := addUser(t, s, "user")
+	const attempts = domain.MaxWebhooksPerUser + 5
+	errs := make([]error, attempts)
+	wg := sync.WaitGroup{}
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			webhook := &domain.Webhook{UserID: user, URL: "https://example.com/" + strconv.Itoa(i), Secret: "s"}
+			errs[i] = s.Transactions.AddWebhook(ctx, webhook)
+		}(i)
+	}
+	wg.Wait()
+	added, limited := 0, 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			added++
+		case errors.Is(err, domain.ErrWebhookLimit):
+			limited++
+		default:
+			t.Errorf("AddWebhook: %v", err)
+		}
+	}
+	if added != domain.MaxWebhooksPerUser || limited != attempts-domain.MaxWebhooksPerUser {
+		t.Errorf("added %d, limited %d; want %d and %d", added, limited, domain.MaxWebhooksPerUser, attempts-domain.MaxWebhooksPerUser)
+	}
+}
+
+// auditActions возвращает действия из журнала по пользователю от новых к старым
+func auditActions(t *testing.T, s Storage, userID int64) []string {
+	t.Helper()
+	records, err := s.Users.GetAudit(context.Background(), &domain.AuditFilter{TargetUser: userID, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetAudit: %v", err)
+	}
+	var ret []string
+	if records != nil {
+		for _, v := range *records {
+			ret = append(ret, v.Action)
+		}
+	}
+	return ret
+}
+
+// запись журнала сохраняется вместе с действием администратора, а отклонённое действие в журнал не попадает
+func testAtomicAudit(t *testing.T, s Storage) {
+	ctx := context.Background()
+	user := addUser(t, s, "user")
+	operator := addUser(t, s, "operator")
+	record := func(action string) *domain.AuditRecord {
+		return &domain.AuditRecord{OperatorID: operator, Action: action}
+	}
+
+	lock := &domain.UserLock{UserID: user, Locked: true, Audit: record(domain.AuditLockUser)}
+	if err := s.Users.SetLocked(ctx, lock); err != nil {
+		t.Fatalf("SetLocked: %v", err)
+	}
+	if lock.Audit.ID == 0 || lock.Audit.TargetUser == nil || *lock.Audit.TargetUser != user {
+		t.Errorf("lock audit = %+v, want saved record for user %d", lock.Audit, user)
+	}
+	missing := &domain.UserLock{UserID: user + 1000, Locked: true, Audit: record(domain.AuditLockUser)}
+	if err := s.Users.SetLocked(ctx, missing); !errors.Is(err, domain.ErrNotFound) {
+		t.Errorf("SetLocked(unknown user): err = %v, want ErrNotFound", err)
+	}
+	if got := auditActions(t, s, user+1000); len(got) != 0 {
+		t.Errorf("audit of unknown user = %v, want nothing", got)
+	}
+
+	accrue(t, s, user, "1", 10000, base, nil)
+	processed := &domain.OrderReset{Number: "1", Audit: record(domain.AuditResetOrder)}
+	if err := s.Transactions.ResetOrder(ctx, processed); !errors.Is(err, domain.ErrOrderProcessed) {
+		t.Errorf("ResetOrder(PROCESSED): err = %v, want ErrOrderProcessed", err)
+	}
+	if err := addOrder(t, s, user, "2", base); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	reset := &domain.OrderReset{Number: "2", Audit: record(domain.AuditResetOrder)}
+	if err := s.Transactions.ResetOrder(ctx, reset); err != nil {
+		t.Fatalf("ResetOrder: %v", err)
+	}
+
+	rejected := &domain.Adjustment{UserID: user, OperatorID: operator, Number: "ADJ-1", Amount: -20000, Reason: "test",
+		ProcessedAt: domain.CustomTime(time.Now().Truncate(time.Second)), Audit: record(domain.AuditAdjustBalance)}
+	if err := s.Transactions.AddAdjustment(ctx, rejected); !errors.Is(err, domain.ErrInsufficientFunds) {
+		t.Errorf("AddAdjustment(-200): err = %v, want ErrInsufficientFunds", err)
+	}
+	accepted := *rejected
+	accepted.Number, accepted.Amount, accepted.Audit = "ADJ-2", 5000, record(domain.AuditAdjustBalance)
+	if err := s.Transactions.AddAdjustment(ctx, &accepted); err != nil {
+		t.Fatalf("AddAdjustment(+50): %v", err)
+	}
+
+	want := []string{domain.AuditAdjustBalance, domain.AuditResetOrder, domain.AuditLockUser}
+	got := auditActions(t, s, user)
+	if len(got) != len(want) {
+		t.Fatalf("audit = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("audit = %v, want %v", got, want)
+		}
+	}
+}
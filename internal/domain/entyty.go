@@ -17,12 +17,40 @@ func (c CustomTime) MarshalJSON() ([]byte, error) {
 	return []byte("\"" + t.Format(timeLayout) + "\""), nil
 }
 
+func (c *CustomTime) UnmarshalJSON(data []byte) error {
+	var v string
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	t, err := time.Parse(timeLayout, v)
+	if err != nil {
+		return err
+	}
+	*c = CustomTime(t)
+	return nil
+}
+
 func (c *CustomMoney) MarshalJSON() ([]byte, error) {
 	i := float64(*c)
 	i = i / 100
 	return []byte(strconv.FormatFloat(i, 'f', 2, 64)), nil
 }
 
+// String форматирует сумму с двумя знаками после запятой без перевода во float
+func (c CustomMoney) String() string {
+	v := int64(c)
+	sign := ""
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	cents := strconv.FormatInt(v%100, 10)
+	if len(cents) == 1 {
+		cents = "0" + cents
+	}
+	return sign + strconv.FormatInt(v/100, 10) + "." + cents
+}
+
 func (c *CustomMoney) UnmarshalJSON(data []byte) error {
 	var v float64
 	if err := json.Unmarshal(data, &v); err != nil {
@@ -48,21 +76,125 @@ type Withdraw struct {
 	ProcessedAt CustomTime  `json:"processed_at,omitempty"`
 }
 
+// Adjustment - ручная корректировка баланса оператором поддержки: начисление (Amount > 0) или списание (Amount < 0).
+// Audit, если задан, сохраняется в той же транзакции
+type Adjustment struct {
+	UserID      int64        `json:"-"`
+	Number      string       `json:"id"`
+	Amount      CustomMoney  `json:"amount"`
+	Reason      string       `json:"reason"`
+	OperatorID  int64        `json:"-"`
+	ProcessedAt CustomTime   `json:"processed_at"`
+	ExpiresAt   *time.Time   `json:"-"`
+	Audit       *AuditRecord `json:"-"`
+}
+
 type User struct {
 	UserID   int64  `json:"-"`
 	Login    string `json:"login"`
 	Password string `json:"password"`
 	Hash     string `json:"-"`
+	Role     string `json:"-"`
+	Locked   bool   `json:"-"`
 }
 
 type Balance struct {
-	UserID    int64       `json:"-"`
-	Current   CustomMoney `json:"current"`
-	Withdrawn CustomMoney `json:"withdrawn"`
+	UserID       int64           `json:"-"`
+	Current      CustomMoney     `json:"current"`
+	Withdrawn    CustomMoney     `json:"withdrawn"`
+	ExpiringSoon *ExpiringPoints `json:"expiring_soon,omitempty"`
 }
 
 type Accrual struct {
-	Order  string       `json:"order"`
-	Status string       `json:"status"`
-	Sum    *CustomMoney `json:"accrual,omitempty"`
+	Order     string       `json:"order"`
+	Status    string       `json:"status"`
+	Sum       *CustomMoney `json:"accrual,omitempty"`
+	ExpiresAt *time.Time   `json:"-"`
+}
+
+type BalanceDrift struct {
+	UserID          int64
+	StoredCurrent   CustomMoney
+	StoredWithdrawn CustomMoney
+	ActualCurrent   CustomMoney
+	ActualWithdrawn CustomMoney
+}
+
+type RevokedToken struct {
+	ID        string
+	UserID    int64
+	ExpiresAt time.Time
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+}
+
+const (
+	EventOrderAccepted      = "order.accepted"
+	EventOrderStatusChanged = "order.status_changed"
+	EventOrderAccrued       = "order.accrued"
+	EventPointsWithdrawn    = "points.withdrawn"
+	EventPointsAdjusted     = "points.adjusted"
+	EventPointsExpired      = "points.expired"
+	// EventBalanceUpdated не попадает в outbox, о нём оповещаются только подписчики потока событий
+	EventBalanceUpdated = "balance.updated"
+)
+
+type Event struct {
+	ID        int64           `json:"id"`
+	Type      string          `json:"type"`
+	UserID    int64           `json:"user_id"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt CustomTime      `json:"created_at"`
+}
+
+type OrderEvent struct {
+	Number         string       `json:"number"`
+	Status         string       `json:"status"`
+	PreviousStatus string       `json:"previous_status,omitempty"`
+	Accrual        *CustomMoney `json:"accrual,omitempty"`
+}
+
+type EventFailure struct {
+	IDs   []int64
+	Error string
+}
+
+// EventCleanup - какие события удаляются из outbox: доставленные раньше DeliveredBefore и, если задано,
+// недоставленные, созданные раньше PendingBefore
+type EventCleanup struct {
+	DeliveredBefore time.Time
+	PendingBefore   *time.Time
+}
+
+// OrderBatch - номера заказов, загружаемые пользователем одним запросом
+type OrderBatch struct {
+	UserID     int64
+	Numbers    []string
+	UploadedAt CustomTime
+}
+
+// BatchOrder - результат вставки одного номера пачки. OwnerID == 0 у непринятого номера означает,
+// что его одновременно загрузили другим запросом и владелец ещё не известен
+type BatchOrder struct {
+	Number   string
+	Accepted bool
+	OwnerID  int64
+}
+
+const (
+	UploadAccepted        = "accepted"
+	UploadAlreadyUploaded = "already_uploaded"
+	UploadAnotherUser     = "uploaded_by_another_user"
+	UploadInvalidFormat   = "invalid_format"
+	UploadFailed          = "error"
+)
+
+type OrderUploadResult struct {
+	Number  string `json:"number"`
+	Result  string `json:"result"`
+	Message string `json:"message"`
 }
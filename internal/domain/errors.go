@@ -0,0 +1,10 @@
+package domain
+
+import "errors"
+
+var ErrInsufficientFunds = errors.New("there are insufficient funds in the account")
+var ErrAlreadyExists = errors.New("record already exists")
+var ErrNotFound = errors.New("record not found")
+var ErrOrderProcessed = errors.New("order has already been processed")
+var ErrInvalidAmount = errors.New("amount must be positive")
+var ErrWebhookLimit = errors.New("too many webhooks registered")
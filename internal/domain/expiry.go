@@ -0,0 +1,23 @@
+package domain
+
+import "time"
+
+// ExpiringPoints - баллы, срок действия которых истекает в ближайшее время, и дата первого сгорания
+type ExpiringPoints struct {
+	UserID int64       `json:"-"`
+	Amount CustomMoney `json:"amount"`
+	Date   CustomTime  `json:"date"`
+}
+
+// ExpiryFilter выбирает баллы пользователя, сгорающие до Before
+type ExpiryFilter struct {
+	UserID int64
+	Before time.Time
+}
+
+// Expiration - итог сгорания баллов пользователя: сколько списано и из скольких начислений
+type Expiration struct {
+	UserID int64       `json:"-"`
+	Amount CustomMoney `json:"amount"`
+	Lots   int         `json:"lots"`
+}
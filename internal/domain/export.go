@@ -0,0 +1,28 @@
+package domain
+
+import "time"
+
+const (
+	ExportCSV    = "csv"
+	ExportJSON   = "json"
+	ExportNDJSON = "ndjson"
+)
+
+// ExportFilter задаёт выгрузку операций; UserID == 0 означает операции всех пользователей
+type ExportFilter struct {
+	UserID int64
+	From   *time.Time
+	To     *time.Time
+}
+
+// ExportRow - строка выгрузки истории счёта. Для заказов Amount - начисление (ноль, пока заказ не обработан),
+// для списаний - отрицательная сумма
+type ExportRow struct {
+	UserID     int64       `json:"user_id"`
+	Type       string      `json:"type"`
+	Number     string      `json:"number"`
+	Status     string      `json:"status"`
+	Amount     CustomMoney `json:"amount"`
+	Reason     string      `json:"reason,omitempty"`
+	UploadedAt CustomTime  `json:"uploaded_at"`
+}
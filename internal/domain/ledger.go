@@ -0,0 +1,31 @@
+package domain
+
+const (
+	TransactionOrder      = "ORDER"
+	TransactionWithdraw   = "WITHDRAW"
+	TransactionAdjustment = "ADJUSTMENT"
+	TransactionExpired    = "EXPIRED"
+)
+
+// LedgerEntry - проведённая операция по счёту: начисление (Amount > 0) или списание (Amount < 0)
+// и баланс после неё
+type LedgerEntry struct {
+	Type        string      `json:"type"`
+	Number      string      `json:"number"`
+	Amount      CustomMoney `json:"amount"`
+	Balance     CustomMoney `json:"balance"`
+	Reason      string      `json:"reason,omitempty"`
+	ProcessedAt CustomTime  `json:"processed_at"`
+}
+
+// Statement - выписка по счёту за период [From, To)
+type Statement struct {
+	UserID    int64         `json:"-"`
+	From      *CustomTime   `json:"from,omitempty"`
+	To        *CustomTime   `json:"to,omitempty"`
+	Opening   CustomMoney   `json:"opening_balance"`
+	Credited  CustomMoney   `json:"credited"`
+	Debited   CustomMoney   `json:"debited"`
+	Closing   CustomMoney   `json:"closing_balance"`
+	Movements []LedgerEntry `json:"movements"`
+}
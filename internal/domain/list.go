@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrCursorFormat = errors.New("incorrect cursor format")
+
+// ListFilter задаёт выборку операций пользователя. Записи упорядочены от новых к старым,
+// Limit == 0 означает выборку без ограничения
+type ListFilter struct {
+	UserID   int64
+	Limit    int
+	Cursor   *ListCursor
+	Statuses []string
+	From     *time.Time
+	To       *time.Time
+}
+
+// ListCursor - позиция последней записи предыдущей страницы
+type ListCursor struct {
+	At     time.Time
+	Number string
+}
+
+func (c ListCursor) Encode() string {
+	raw := strconv.FormatInt(c.At.UnixNano(), 10) + ":" + c.Number
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (*ListCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrCursorFormat
+	}
+	nanos, number, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, ErrCursorFormat
+	}
+	ns, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return nil, ErrCursorFormat
+	}
+	return &ListCursor{At: time.Unix(0, ns), Number: number}, nil
+}
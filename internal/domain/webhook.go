@@ -0,0 +1,60 @@
+package domain
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// MaxWebhooksPerUser - сколько адресов уведомлений может зарегистрировать один пользователь
+const MaxWebhooksPerUser = 10
+
+const (
+	DeliveryPending   = "PENDING"
+	DeliveryDelivered = "DELIVERED"
+	DeliveryFailed    = "FAILED"
+)
+
+// Webhook - адрес, на который пользователь получает уведомления о смене статусов своих заказов.
+// Secret возвращается только при регистрации, им подписывается тело каждого запроса
+type Webhook struct {
+	ID            int64       `json:"id"`
+	UserID        int64       `json:"-"`
+	URL           string      `json:"url"`
+	Secret        string      `json:"secret,omitempty"`
+	CreatedAt     CustomTime  `json:"created_at"`
+	LastSuccessAt *CustomTime `json:"last_success_at,omitempty"`
+	LastErrorAt   *CustomTime `json:"last_error_at,omitempty"`
+	LastError     string      `json:"last_error,omitempty"`
+	Failures      int         `json:"failures"`
+}
+
+// WebhookEvent - тело уведомления о смене статуса заказа
+type WebhookEvent struct {
+	UserID     int64      `json:"-"`
+	Event      string     `json:"event"`
+	Order      OrderEvent `json:"order"`
+	OccurredAt CustomTime `json:"occurred_at"`
+}
+
+// NewStatusChangedEvent - уведомление о смене статуса заказа; начисление передаётся только для PROCESSED
+func NewStatusChangedEvent(userID int64, order OrderEvent, at time.Time) *WebhookEvent {
+	if order.Status != "PROCESSED" {
+		order.Accrual = nil
+	}
+	return &WebhookEvent{UserID: userID, Event: EventOrderStatusChanged, Order: order, OccurredAt: CustomTime(at)}
+}
+
+type WebhookDelivery struct {
+	ID            int64           `json:"id"`
+	WebhookID     int64           `json:"webhook_id"`
+	URL           string          `json:"-"`
+	Secret        string          `json:"-"`
+	EventType     string          `json:"event"`
+	Payload       json.RawMessage `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt *CustomTime     `json:"next_attempt_at,omitempty"`
+	LastError     string          `json:"last_error,omitempty"`
+	CreatedAt     CustomTime      `json:"created_at"`
+	DeliveredAt   *CustomTime     `json:"delivered_at,omitempty"`
+}
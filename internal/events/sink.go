@@ -0,0 +1,128 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"loyalty-system/internal/domain"
+)
+
+const (
+	SinkNone    = "none"
+	SinkWebhook = "webhook"
+	SinkFile    = "file"
+	SinkMemory  = "memory"
+)
+
+// Sink доставляет пачку событий получателю. Ошибка означает, что пачка будет отправлена повторно целиком,
+// поэтому получатель должен быть готов к дублям (доставка at-least-once, дубли различаются по Event.ID)
+type Sink interface {
+	Publish(ctx context.Context, events []domain.Event) error
+}
+
+// NewSink создаёт приёмник событий: для webhook target - URL, для file - путь к NDJSON-файлу
+func NewSink(kind string, target string) (Sink, error) {
+	switch kind {
+	case SinkWebhook:
+		if target == "" {
+			return nil, fmt.Errorf("webhook sink: empty url")
+		}
+		return NewWebhookSink(target), nil
+	case SinkFile:
+		if target == "" {
+			return nil, fmt.Errorf("file sink: empty path")
+		}
+		return NewFileSink(target), nil
+	case SinkMemory:
+		return NewMemorySink(), nil
+	default:
+		return nil, fmt.Errorf("unknown event sink %q", kind)
+	}
+}
+
+// WebhookSink отправляет пачку событий JSON-массивом методом POST; любой ответ, кроме 2xx, считается ошибкой
+type WebhookSink struct {
+	url    string
+	client *resty.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: resty.New().SetTimeout(10 * time.Second)}
+}
+
+func (s *WebhookSink) Publish(ctx context.Context, events []domain.Event) error {
+	resp, err := s.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(events).
+		Post(s.url)
+	if err != nil {
+		return fmt.Errorf("post events: %w", err)
+	}
+	if !resp.IsSuccess() {
+		return fmt.Errorf("post events: unexpected status %d", resp.StatusCode())
+	}
+	return nil
+}
+
+// FileSink дописывает события в файл, по одному JSON-объекту на строку
+type FileSink struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) Publish(_ context.Context, events []domain.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open %q: %w", s.path, err)
+	}
+	enc := json.NewEncoder(f)
+	for i := range events {
+		if err = enc.Encode(&events[i]); err != nil {
+			f.Close()
+			return fmt.Errorf("write event: %w", err)
+		}
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync %q: %w", s.path, err)
+	}
+	return f.Close()
+}
+
+// MemorySink накапливает события в памяти; предназначен для тестов и отладки
+type MemorySink struct {
+	mu     sync.Mutex
+	events []domain.Event
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Publish(_ context.Context, events []domain.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, events...)
+	return nil
+}
+
+// Events возвращает копию полученных событий
+func (s *MemorySink) Events() []domain.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]domain.Event, len(s.events))
+	copy(ret, s.events)
+	return ret
+}
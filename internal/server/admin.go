@@ -0,0 +1,298 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/internal/domain/actions"
+	"loyalty-system/pkg/logger"
+)
+
+const (
+	adminDefaultLimit = 50
+	adminMaxLimit     = 1000
+)
+
+// auditRecord начинает запись журнала о действии администратора. Изменяющие действия передают её в хранилище,
+// которое сохраняет запись в той же транзакции, дополнив пользователем и подробностями
+func auditRecord(r *http.Request, action string) *domain.AuditRecord {
+	operatorID, _ := strconv.ParseInt(r.Header.Get("user-id"), 10, 64)
+	return &domain.AuditRecord{OperatorID: operatorID, Action: action}
+}
+
+// audit записывает в журнал просмотр данных администратором. Данные уже прочитаны, поэтому ошибка записи только логируется
+func (a *Server) audit(r *http.Request, action string, target *int64, details any) {
+	record := auditRecord(r, action)
+	record.TargetUser = target
+	if details != nil {
+		body, err := json.Marshal(details)
+		if err != nil {
+			logger.Log.Error("Marshal audit details", zap.Error(err))
+		}
+		record.Details = body
+	}
+	if err := a.userStorage.Audit(r.Context(), record); err != nil {
+		logger.Log.Error("Audit", zap.String("action", action), zap.Int64("operator", record.OperatorID), zap.Error(err))
+	}
+}
+
+func adminLimit(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return adminDefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > adminMaxLimit {
+		return 0, errors.New("limit must be between 1 and " + strconv.Itoa(adminMaxLimit))
+	}
+	return limit, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	resp, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+func (a *Server) adminSearchUsers(w http.ResponseWriter, r *http.Request) {
+	limit, err := adminLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	login := r.URL.Query().Get("login")
+	users, err := a.userStorage.SearchUsers(r.Context(), login, limit)
+	a.audit(r, domain.AuditSearchUsers, nil, map[string]string{"login": login})
+	if err != nil {
+		switch {
+		case errors.Is(err, actions.ErrNotExists):
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, users)
+}
+
+// targetUser разбирает идентификатор пользователя из пути и проверяет, что такой пользователь есть.
+// Если пользователя нет, отвечает 404, и просмотр не попадает в журнал
+func (a *Server) targetUser(w http.ResponseWriter, r *http.Request) (*domain.UserInfo, bool) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	user, err := a.userStorage.GetUserInfo(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, actions.ErrUserNotExists):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return user, true
+}
+
+func (a *Server) adminGetUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.targetUser(w, r)
+	if !ok {
+		return
+	}
+	a.audit(r, domain.AuditViewUser, &user.ID, nil)
+	writeJSON(w, user)
+}
+
+func (a *Server) adminGetOrders(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.targetUser(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseListFilter(r, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	orders, next, err := a.transactionStorage.GetAllOrders(r.Context(), filter)
+	if err != nil && !errors.Is(err, actions.ErrNotExists) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.audit(r, domain.AuditViewLedger, &user.ID, map[string]string{"list": "orders", "query": r.URL.RawQuery})
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	setNextPage(w, r, next)
+	writeJSON(w, orders)
+}
+
+func (a *Server) adminGetWithdrawals(w http.ResponseWriter, r *http.Request) {
+	user, ok := a.targetUser(w, r)
+	if !ok {
+		return
+	}
+	filter, err := parseListFilter(r, user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	withdraws, next, err := a.transactionStorage.GetAllWithdraw(r.Context(), filter)
+	if err != nil && !errors.Is(err, actions.ErrNotExists) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.audit(r, domain.AuditViewLedger, &user.ID, map[string]string{"list": "withdrawals", "query": r.URL.RawQuery})
+	if err != nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	setNextPage(w, r, next)
+	writeJSON(w, withdraws)
+}
+
+func (a *Server) adminGetBalance(w http.ResponseWriter, r *http.Request) {
+	// у неизвестного пользователя нулевой баланс, поэтому без проверки ответ был бы 200
+	user, ok := a.targetUser(w, r)
+	if !ok {
+		return
+	}
+	balance, err := a.transactionStorage.GetBalance(r.Context(), user.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	a.audit(r, domain.AuditViewLedger, &user.ID, map[string]string{"list": "balance"})
+	writeJSON(w, balance)
+}
+
+func (a *Server) adminLockUser(w http.ResponseWriter, r *http.Request) {
+	a.setUserLock(w, r, true)
+}
+
+func (a *Server) adminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	a.setUserLock(w, r, false)
+}
+
+func (a *Server) setUserLock(w http.ResponseWriter, r *http.Request, locked bool) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	action := domain.AuditUnlockUser
+	if locked {
+		action = domain.AuditLockUser
+	}
+	err = a.userStorage.SetLocked(r.Context(), userID, locked, auditRecord(r, action))
+	if err != nil {
+		switch {
+		case errors.Is(err, actions.ErrUserNotExists):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *Server) adminResetOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+	_, err := a.transactionStorage.ResetOrder(r.Context(), number, auditRecord(r, domain.AuditResetOrder))
+	if err != nil {
+		switch {
+		case errors.Is(err, actions.ErrOrderNotExists):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, actions.ErrOrderProcessed):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (a *Server) adminAdjustBalance(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	operatorID, err := strconv.ParseInt(r.Header.Get("user-id"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	adjustment := domain.Adjustment{}
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = json.Unmarshal(buf.Bytes(), &adjustment); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	adjustment.UserID = userID
+	adjustment.OperatorID = operatorID
+	adjustment.Audit = auditRecord(r, domain.AuditAdjustBalance)
+	created, err := a.transactionStorage.NewAdjustment(r.Context(), adjustment)
+	if err != nil {
+		switch {
+		case errors.Is(err, actions.ErrAdjustmentAmount), errors.Is(err, actions.ErrAdjustmentReason):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, actions.ErrUserNotExists):
+			http.Error(w, err.Error(), http.StatusNotFound)
+		case errors.Is(err, actions.ErrInsufficientFounds):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, created)
+}
+
+func (a *Server) adminGetAudit(w http.ResponseWriter, r *http.Request) {
+	limit, err := adminLimit(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter := domain.AuditFilter{Limit: limit}
+	if raw := r.URL.Query().Get("user"); raw != "" {
+		filter.TargetUser, err = strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	records, err := a.userStorage.GetAudit(r.Context(), filter)
+	a.audit(r, domain.AuditViewAuditLog, nil, map[string]string{"query": r.URL.RawQuery})
+	if err != nil {
+		switch {
+		case errors.Is(err, actions.ErrNotExists):
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	writeJSON(w, records)
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+	"loyalty-system/internal/domain"
+	"loyalty-system/internal/domain/actions"
+	"loyalty-system/pkg/logger"
+)
+
+var errExportFormat = errors.New("format must be csv, json or ndjson")
+
+// exportFlushRows - через сколько строк выгрузка сбрасывается клиенту
+const exportFlushRows = 500
+
+// exportWriter пишет строки выгрузки в ответ в одном из форматов
+type exportWriter interface {
+	Write(row *domain.ExportRow) error
+	Flush() error
+	Close() error
+}
+
+func newExportWriter(format string, w io.Writer) (exportWriter, string, error) {
+	switch format {
+	case domain.ExportCSV:
+		return &csvExport{w: csv.NewWriter(w)}, "text/csv; charset=utf-8", nil
+	case domain.ExportJSON:
+		return &jsonExport{w: w}, "application/json", nil
+	case domain.ExportNDJSON:
+		return &ndjsonExport{enc: json.NewEncoder(w)}, "application/x-ndjson", nil
+	default:
+		return nil, "", errExportFormat
+	}
+}
+
+type csvExport struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (e *csvExport) Write(row *domain.ExportRow) error {
+	if !e.header {
+		e.header = true
+		if err := e.w.Write([]string{"user_id", "type", "number", "status", "amount", "reason", "uploaded_at"}); err != nil {
+			return err
+		}
+	}
+	return e.w.Write([]string{
+		strconv.FormatInt(row.UserID, 10),
+		row.Type,
+		row.Number,
+		row.Status,
+		row.Amount.String(),
+		row.Reason,
+		time.Time(row.UploadedAt).Format(time.RFC3339),
+	})
+}
+
+func (e *csvExport) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvExport) Close() error {
+	return e.Flush()
+}
+
+// jsonExport пишет JSON-массив по элементу, не собирая его целиком
+type jsonExport struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonExport) Write(row *domain.ExportRow) error {
+	body, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if e.count == 0 {
+		sep = "[\n"
+	}
+	e.count++
+	if _, err = io.WriteString(e.w, sep); err != nil {
+		return err
+	}
+	_, err = e.w.Write(body)
+	return err
+}
+
+func (e *jsonExport) Flush() error {
+	return nil
+}
+
+func (e *jsonExport) Close() error {
+	end := "\n]\n"
+	if e.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(e.w, end)
+	return err
+}
+
+type ndjsonExport struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonExport) Write(row *domain.ExportRow) error {
+	return e.enc.Encode(row)
+}
+
+func (e *ndjsonExport) Flush() error {
+	return nil
+}
+
+func (e *ndjsonExport) Close() error {
+	return nil
+}
+
+// export передаёт выгрузку клиенту по мере чтения из БД. После первой строки статус ответа уже отправлен,
+// поэтому ошибка посередине только обрывает ответ и пишется в лог. onStart, если задан, вызывается перед отправкой
+// статуса 200 - когда период и формат уже проверены
+func (a *Server) export(w http.ResponseWriter, r *http.Request, filter domain.ExportFilter, name string, onStart func()) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = domain.ExportCSV
+	}
+	out, contentType, err := newExportWriter(format, w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	started := false
+	start := func() {
+		started = true
+		if onStart != nil {
+			onStart()
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+		w.WriteHeader(http.StatusOK)
+	}
+	rows := 0
+	err = a.transactionStorage.Export(r.Context(), filter, func(row *domain.ExportRow) error {
+		if !started {
+			start()
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+		rows++
+		if flusher != nil && rows%exportFlushRows == 0 {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && !started {
+		switch {
+		case errors.Is(err, actions.ErrExportPeriod):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err == nil && !started {
+		start()
+	}
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil {
+		logger.Log.Error("Export interrupted", zap.Int("rows", rows), zap.Error(err))
+	}
+}
+
+func (a *Server) exportHistory(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.Header.Get("user-id"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	filter := domain.ExportFilter{UserID: userID}
+	if filter.From, err = parseListTime(r.URL.Query().Get("from")); err != nil {
+		http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseListTime(r.URL.Query().Get("to")); err != nil {
+		http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.export(w, r, filter, "history", nil)
+}
+
+func (a *Server) adminExport(w http.ResponseWriter, r *http.Request) {
+	filter := domain.ExportFilter{}
+	var err error
+	if filter.From, err = parseListTime(r.URL.Query().Get("from")); err != nil {
+		http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseListTime(r.URL.Query().Get("to")); err != nil {
+		http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.From == nil || filter.To == nil {
+		http.Error(w, actions.ErrExportPeriod.Error(), http.StatusBadRequest)
+		return
+	}
+	a.export(w, r, filter, "transactions", func() {
+		a.audit(r, domain.AuditExport, nil, map[string]any{"from": filter.From, "to": filter.To, "format": r.URL.Query().Get("format")})
+	})
+}
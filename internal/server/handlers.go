@@ -6,6 +6,7 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"loyalty-system/internal/domain"
@@ -29,35 +30,39 @@ func (a *Server) registerNewUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	err = a.userStorage.NewUser(r.Context(), user.Login, user.Password, a.config.Salt)
+	err = a.userStorage.NewUser(r.Context(), user.Login, user.Password)
 	if err != nil && errors.Is(err, actions.ErrUserExists) {
 		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
+	if err != nil && errors.Is(err, actions.ErrLoginReserved) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil && errors.Is(err, actions.ErrPasswordTooLong) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	//
-	userID, err := a.userStorage.LoginUser(r.Context(), user.Login, user.Password, a.config.Salt)
+	info, err := a.userStorage.LoginUser(r.Context(), user.Login, user.Password)
 	if err != nil {
 		switch {
 		case errors.Is(err, actions.ErrWrongPassword):
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 		case errors.Is(err, actions.ErrUserNotExists):
 			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case errors.Is(err, actions.ErrUserLocked):
+			http.Error(w, err.Error(), http.StatusForbidden)
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
-	t, err := security.BuildJWTString(userID, time.Hour*time.Duration(a.config.JWTExp), a.config.JWTKey)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.Header().Add("Authorization", t)
-	w.WriteHeader(http.StatusOK)
+	a.issueTokens(w, info)
 }
 
 func (a *Server) loginUser(w http.ResponseWriter, r *http.Request) {
@@ -76,32 +81,134 @@ func (a *Server) loginUser(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	userID, err := a.userStorage.LoginUser(r.Context(), user.Login, user.Password, a.config.Salt)
+	info, err := a.userStorage.LoginUser(r.Context(), user.Login, user.Password)
 	if err != nil {
 		switch {
 		case errors.Is(err, actions.ErrWrongPassword):
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 		case errors.Is(err, actions.ErrUserNotExists):
 			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case errors.Is(err, actions.ErrUserLocked):
+			http.Error(w, err.Error(), http.StatusForbidden)
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
 		return
 	}
+	a.issueTokens(w, info)
+}
 
-	t, err := security.BuildJWTString(userID, time.Hour*time.Duration(a.config.JWTExp), a.config.JWTKey)
+func (a *Server) refreshToken(w http.ResponseWriter, r *http.Request) {
+	var pair domain.TokenPair
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err = json.Unmarshal(buf.Bytes(), &pair); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	claims, err := security.ParseJWT(pair.RefreshToken, security.RefreshToken, a.keys)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	// refresh-токен одноразовый: отзыв одновременно проверяет, что его ещё не использовали
+	err = a.userStorage.RevokeToken(r.Context(), claims)
+	switch {
+	case errors.Is(err, actions.ErrTokenRevoked):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Add("Authorization", t)
+	// роль и блокировку берём из БД: они могли измениться после выдачи refresh-токена
+	info, err := a.userStorage.GetUserInfo(r.Context(), claims.UserID)
+	switch {
+	case errors.Is(err, actions.ErrUserNotExists):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	case info.LockedAt != nil:
+		http.Error(w, actions.ErrUserLocked.Error(), http.StatusForbidden)
+		return
+	}
+	a.issueTokens(w, info)
+}
+
+func (a *Server) logoutUser(w http.ResponseWriter, r *http.Request) {
+	claims, err := security.ParseJWT(r.Header.Get("Authorization"), security.AccessToken, a.keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	var pair domain.TokenPair
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if buf.Len() > 0 {
+		if err = json.Unmarshal(buf.Bytes(), &pair); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	err = a.userStorage.RevokeToken(r.Context(), claims)
+	if err != nil && !errors.Is(err, actions.ErrTokenRevoked) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if pair.RefreshToken != "" {
+		refreshClaims, err := security.ParseJWT(pair.RefreshToken, security.RefreshToken, a.keys)
+		if err != nil || refreshClaims.UserID != claims.UserID {
+			http.Error(w, "invalid refresh token", http.StatusBadRequest)
+			return
+		}
+		err = a.userStorage.RevokeToken(r.Context(), refreshClaims)
+		if err != nil && !errors.Is(err, actions.ErrTokenRevoked) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
+func (a *Server) issueTokens(w http.ResponseWriter, user *domain.UserInfo) {
+	accessExp := time.Hour * time.Duration(a.config.JWTExp)
+	admin := user.Role == domain.RoleAdmin
+	access, _, err := security.BuildJWTString(user.ID, admin, security.AccessToken, accessExp, a.keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	refresh, _, err := security.BuildJWTString(user.ID, admin, security.RefreshToken, time.Hour*time.Duration(a.config.RefreshExp), a.keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := json.MarshalIndent(domain.TokenPair{AccessToken: access, RefreshToken: refresh, ExpiresIn: int64(accessExp.Seconds())}, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Authorization", access)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
 func (a *Server) loadOrders(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(r.Header.Get("user-id"), 10, 64)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
 	}
 	//
 	var buf bytes.Buffer
@@ -130,13 +237,97 @@ func (a *Server) loadOrders(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// maxBatchBody - наибольший размер тела пакетной загрузки: MaxBatchOrders номеров с запасом на кавычки, запятые и пробелы
+const maxBatchBody = actions.MaxBatchOrders * 128
+
+func (a *Server) loadOrdersBatch(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.Header.Get("user-id"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	//
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(http.MaxBytesReader(w, r.Body, maxBatchBody))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, actions.ErrBatchTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	numbers, err := parseOrderNumbers(r.Header.Get("Content-Type"), buf.Bytes())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	results, err := a.transactionStorage.NewOrders(r.Context(), userID, numbers)
+	if err != nil {
+		switch {
+		case errors.Is(err, actions.ErrBatchEmpty):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, actions.ErrBatchTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	resp, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+// parseOrderNumbers разбирает тело пакетной загрузки: JSON-массив строк или чисел при Content-Type application/json,
+// иначе - текст с номером на каждой непустой строке
+func parseOrderNumbers(contentType string, body []byte) ([]string, error) {
+	if !strings.HasPrefix(contentType, "application/json") {
+		ret := make([]string, 0)
+		for _, line := range strings.Split(string(body), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				ret = append(ret, line)
+			}
+		}
+		return ret, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(items))
+	for _, v := range items {
+		// числа берём как есть, чтобы не потерять точность длинных номеров
+		number := string(v)
+		if len(v) > 0 && v[0] == '"' {
+			if err := json.Unmarshal(v, &number); err != nil {
+				return nil, err
+			}
+		}
+		ret = append(ret, strings.TrimSpace(number))
+	}
+	return ret, nil
+}
+
 func (a *Server) getOrders(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(r.Header.Get("user-id"), 10, 64)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	filter, err := parseListFilter(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	//
-	orders, err := a.transactionStorage.GetAllOrders(r.Context(), userID)
+	orders, next, err := a.transactionStorage.GetAllOrders(r.Context(), filter)
 	if err != nil {
 		switch {
 		case errors.Is(err, actions.ErrNotExists):
@@ -144,12 +335,14 @@ func (a *Server) getOrders(w http.ResponseWriter, r *http.Request) {
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
+		return
 	}
 	resp, err := json.MarshalIndent(orders, "", "  ")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	setNextPage(w, r, next)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
@@ -159,11 +352,13 @@ func (a *Server) getBalance(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(r.Header.Get("user-id"), 10, 64)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
 	}
 	//
 	balance, err := a.transactionStorage.GetBalance(r.Context(), userID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	resp, err := json.MarshalIndent(balance, "", "  ")
 	if err != nil {
@@ -179,6 +374,7 @@ func (a *Server) debitingFunds(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(r.Header.Get("user-id"), 10, 64)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
 	}
 	//
 	withdraw := domain.Withdraw{}
@@ -200,13 +396,14 @@ func (a *Server) debitingFunds(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusPaymentRequired)
 		case errors.Is(err, actions.ErrOrderUploadedCurrUser):
 			w.WriteHeader(http.StatusOK)
-		case errors.Is(err, actions.ErrOrderFormat):
+		case errors.Is(err, actions.ErrOrderFormat), errors.Is(err, actions.ErrWithdrawSum):
 			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
 		case errors.Is(err, actions.ErrOrderUploadedAnotherUser):
 			http.Error(w, err.Error(), http.StatusConflict)
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
+		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
@@ -215,9 +412,15 @@ func (a *Server) debitHistory(w http.ResponseWriter, r *http.Request) {
 	userID, err := strconv.ParseInt(r.Header.Get("user-id"), 10, 64)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	filter, err := parseListFilter(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 	//
-	withdraws, err := a.transactionStorage.GetAllWithdraw(r.Context(), userID)
+	withdraws, next, err := a.transactionStorage.GetAllWithdraw(r.Context(), filter)
 	if err != nil {
 		switch {
 		case errors.Is(err, actions.ErrNotExists):
@@ -225,13 +428,60 @@ func (a *Server) debitHistory(w http.ResponseWriter, r *http.Request) {
 		default:
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
+		return
 	}
 	resp, err := json.MarshalIndent(withdraws, "", "  ")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	setNextPage(w, r, next)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+func (a *Server) getAdjustments(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.ParseInt(r.Header.Get("user-id"), 10, 64)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	filter, err := parseListFilter(r, userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	//
+	adjustments, next, err := a.transactionStorage.GetAllAdjustments(r.Context(), filter)
+	if err != nil {
+		switch {
+		case errors.Is(err, actions.ErrNotExists):
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	resp, err := json.MarshalIndent(adjustments, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setNextPage(w, r, next)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(resp)
+}
+
+func (a *Server) getJWKS(w http.ResponseWriter, r *http.Request) {
+	resp, err := json.MarshalIndent(a.keys.JWKS(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(a.config.JWTKeyReload))
 	w.WriteHeader(http.StatusOK)
 	w.Write(resp)
 }
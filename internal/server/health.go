@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"loyalty-system/internal/domain/dbstorage/migrations"
+)
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+)
+
+type dependencyStatus struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+type healthStatus struct {
+	Status string                      `json:"status"`
+	Checks map[string]dependencyStatus `json:"checks,omitempty"`
+}
+
+func (a *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, healthStatus{Status: statusOK})
+}
+
+func (a *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second*time.Duration(a.config.ReadyTimeout))
+	defer cancel()
+
+	checks := map[string]func(ctx context.Context) error{
+		"users_storage":        a.userStorage.Ping,
+		"transactions_storage": a.transactionStorage.Ping,
+		"accrual_system":       a.transactionStorage.PingAccrual,
+		"migrations": func(ctx context.Context) error {
+			if a.db == nil {
+				return nil
+			}
+			pending, err := migrations.Pending(ctx, a.db)
+			if err != nil {
+				return err
+			}
+			if pending > 0 {
+				return fmt.Errorf("%d migration(s) pending", pending)
+			}
+			return nil
+		},
+		"processing": func(ctx context.Context) error {
+			last := a.transactionStorage.LastHeartbeat()
+			if last.IsZero() {
+				return fmt.Errorf("processing has not started")
+			}
+			// цикл обработки может пропустить несколько тиков, пока ждёт ответов системы начислений
+			if age := time.Since(last); age > 3*time.Second*time.Duration(a.config.PollInterval)+time.Minute {
+				return fmt.Errorf("last heartbeat %s ago", age.Round(time.Second))
+			}
+			return nil
+		},
+	}
+
+	ret := healthStatus{Status: statusOK, Checks: make(map[string]dependencyStatus, len(checks))}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for name, check := range checks {
+		name, check := name, check
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			dep := dependencyStatus{Status: statusOK, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				dep.Status = statusFail
+				dep.Error = err.Error()
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			ret.Checks[name] = dep
+			if err != nil {
+				ret.Status = statusFail
+			}
+		}()
+	}
+	wg.Wait()
+	writeHealth(w, ret)
+}
+
+func writeHealth(w http.ResponseWriter, status healthStatus) {
+	resp, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if status.Status == statusOK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	w.Write(resp)
+}
@@ -1,9 +1,12 @@
 package server
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
+	"loyalty-system/internal/domain"
+	"loyalty-system/internal/domain/actions"
 	"loyalty-system/pkg/security"
 )
 
@@ -11,14 +14,56 @@ func (a *Server) Auth(h http.Handler) http.Handler {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
 		ow := w
 		jwt := r.Header.Get("Authorization")
-		userID, err := security.GetUserID(jwt, a.config.JWTKey)
+		claims, err := security.ParseJWT(jwt, security.AccessToken, a.keys)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
-		user := strconv.FormatInt(userID, 10)
-		r.Header.Add("user-id", user)
+		err = a.userStorage.CheckToken(r.Context(), claims)
+		switch {
+		case errors.Is(err, actions.ErrTokenRevoked):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case errors.Is(err, actions.ErrUserLocked):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		user := strconv.FormatInt(claims.UserID, 10)
+		r.Header.Set("user-id", user)
+		r.Header.Set("user-admin", strconv.FormatBool(claims.Admin))
 		h.ServeHTTP(ow, r)
 	}
 	return http.HandlerFunc(logFn)
 }
+
+// AdminOnly пропускает только администраторов: роль должна быть и в токене, и в БД на момент запроса
+func (a *Server) AdminOnly(h http.Handler) http.Handler {
+	logFn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("user-admin") != "true" {
+			http.Error(w, "admin role required", http.StatusForbidden)
+			return
+		}
+		userID, err := strconv.ParseInt(r.Header.Get("user-id"), 10, 64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		user, err := a.userStorage.GetUserInfo(r.Context(), userID)
+		switch {
+		case errors.Is(err, actions.ErrUserNotExists):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		case user.Role != domain.RoleAdmin:
+			http.Error(w, "admin role required", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(logFn)
+}
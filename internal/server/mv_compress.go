@@ -10,6 +10,9 @@ import (
 type compressWriter struct {
 	w  http.ResponseWriter
 	zw *gzip.Writer
+	// plain - ответ передаётся как есть: ошибка, ответ без тела или тело, которое обработчик сжал сам
+	plain       bool
+	wroteHeader bool
 }
 
 func newCompressWriter(w http.ResponseWriter) *compressWriter {
@@ -24,17 +27,46 @@ func (c *compressWriter) Header() http.Header {
 }
 
 func (c *compressWriter) Write(p []byte) (int, error) {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.plain {
+		return c.w.Write(p)
+	}
 	return c.zw.Write(p)
 }
 
 func (c *compressWriter) WriteHeader(statusCode int) {
-	if statusCode < 300 {
+	if c.wroteHeader {
+		c.w.WriteHeader(statusCode)
+		return
+	}
+	c.wroteHeader = true
+	c.plain = statusCode >= 300 || statusCode == http.StatusNoContent || c.w.Header().Get("Content-Encoding") != ""
+	if !c.plain {
 		c.w.Header().Set("Content-Encoding", "gzip")
+		c.w.Header().Del("Content-Length")
 	}
 	c.w.WriteHeader(statusCode)
 }
 
+// Flush отправляет клиенту всё сжатое к этому моменту, что нужно потоковым ответам
+func (c *compressWriter) Flush() {
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
+	if !c.plain {
+		c.zw.Flush()
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (c *compressWriter) Close() error {
+	if c.plain || !c.wroteHeader {
+		return nil
+	}
 	return c.zw.Close()
 }
 
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"loyalty-system/internal/accrual"
+	"loyalty-system/internal/domain"
+	"loyalty-system/internal/domain/actions"
+	"loyalty-system/internal/events"
+	"loyalty-system/pkg/security"
+)
+
+// UserService - операции с пользователями, которые используют обработчики; реализуется actions.UserStorage
+type UserService interface {
+	NewUser(ctx context.Context, login string, password string) error
+	LoginUser(ctx context.Context, login string, password string) (*domain.UserInfo, error)
+	GetUserInfo(ctx context.Context, userID int64) (*domain.UserInfo, error)
+	CheckToken(ctx context.Context, claims *security.Claims) error
+	RevokeToken(ctx context.Context, claims *security.Claims) error
+	SearchUsers(ctx context.Context, login string, limit int) (*[]domain.UserInfo, error)
+	SetLocked(ctx context.Context, userID int64, locked bool, audit *domain.AuditRecord) error
+	Audit(ctx context.Context, record *domain.AuditRecord) error
+	GetAudit(ctx context.Context, filter domain.AuditFilter) (*[]domain.AuditRecord, error)
+	Ping(ctx context.Context) error
+}
+
+// TransactionService - операции со счетами и фоновые циклы сервиса; реализуется actions.TransactionRepo
+type TransactionService interface {
+	NewOrder(ctx context.Context, userID int64, orderNum string) error
+	NewOrders(ctx context.Context, userID int64, numbers []string) ([]domain.OrderUploadResult, error)
+	GetAllOrders(ctx context.Context, filter domain.ListFilter) (*[]domain.Order, *domain.ListCursor, error)
+	ResetOrder(ctx context.Context, number string, audit *domain.AuditRecord) (*domain.OrderReset, error)
+	GetBalance(ctx context.Context, UserID int64) (*domain.Balance, error)
+	NewWithdraw(ctx context.Context, newWithdraw domain.Withdraw) error
+	GetAllWithdraw(ctx context.Context, filter domain.ListFilter) (*[]domain.Withdraw, *domain.ListCursor, error)
+	NewAdjustment(ctx context.Context, adjustment domain.Adjustment) (*domain.Adjustment, error)
+	GetAllAdjustments(ctx context.Context, filter domain.ListFilter) (*[]domain.Adjustment, *domain.ListCursor, error)
+	GetLedger(ctx context.Context, filter domain.ListFilter) (*[]domain.LedgerEntry, *domain.ListCursor, error)
+	GetStatement(ctx context.Context, userID int64, from, to *time.Time) (*domain.Statement, error)
+	Export(ctx context.Context, filter domain.ExportFilter, write func(*domain.ExportRow) error) error
+	NewWebhook(ctx context.Context, userID int64, rawURL string) (*domain.Webhook, error)
+	GetWebhooks(ctx context.Context, userID int64) (*[]domain.Webhook, error)
+	DeleteWebhook(ctx context.Context, userID int64, webhookID int64) error
+	GetWebhookDeliveries(ctx context.Context, userID int64, webhookID int64) (*[]domain.WebhookDelivery, error)
+	Subscribe(userID int64) (<-chan domain.Event, func())
+	Ping(ctx context.Context) error
+	PingAccrual(ctx context.Context) error
+	LastHeartbeat() time.Time
+	RunProcessing(ctx context.Context, batchLimit int, sendLimit int, pollInterval int) error
+	RunReconciliation(ctx context.Context, reconcileInterval int, repair bool) error
+	RunExpiry(ctx context.Context, batchLimit int, interval int) error
+	RunNotifications(ctx context.Context) error
+	RunWebhookDispatcher(ctx context.Context, batchLimit int, sendLimit int, interval int) error
+	RunWebhookCleanup(ctx context.Context, retention int) error
+	RunOutboxPublisher(ctx context.Context, sink events.Sink, batchLimit int, interval int) error
+	RunOutboxCleanup(ctx context.Context, retention int, dropPending bool) error
+}
+
+// Option задаёт зависимость сервера вместо создаваемой по конфигурации
+type Option func(*Server)
+
+// WithUserService подменяет сервис пользователей
+func WithUserService(users UserService) Option {
+	return func(a *Server) {
+		a.userStorage = users
+	}
+}
+
+// WithTransactionService подменяет сервис операций; клиент WithAccrualClient при этом не используется
+func WithTransactionService(transactions TransactionService) Option {
+	return func(a *Server) {
+		a.transactionStorage = transactions
+	}
+}
+
+// WithAccrualClient задаёт клиент системы начислений для сервиса операций, создаваемого по конфигурации
+func WithAccrualClient(client accrual.Client) Option {
+	return func(a *Server) {
+		a.accrual = client
+	}
+}
+
+// WithStorage задаёт открытое хранилище, поверх которого создаются сервисы, не заданные опциями; сервер его не закрывает
+func WithStorage(storage *actions.Storage) Option {
+	return func(a *Server) {
+		a.storage = storage
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"loyalty-system/internal/domain"
+)
+
+const maxPageLimit = 1000
+
+var errListQuery = errors.New("incorrect list parameters")
+
+// parseListFilter читает параметры выборки: limit, cursor, status (через запятую или повторением), from и to
+// (RFC 3339 или дата ГГГГ-ММ-ДД; to не включается)
+func parseListFilter(r *http.Request, userID int64) (domain.ListFilter, error) {
+	q := r.URL.Query()
+	filter := domain.ListFilter{UserID: userID}
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return filter, fmt.Errorf("%w: limit must be between 1 and %d", errListQuery, maxPageLimit)
+		}
+		filter.Limit = limit
+	}
+	if v := q.Get("cursor"); v != "" {
+		cursor, err := domain.DecodeCursor(v)
+		if err != nil {
+			return filter, fmt.Errorf("%w: %w", errListQuery, err)
+		}
+		filter.Cursor = cursor
+	}
+	for _, v := range q["status"] {
+		for _, status := range strings.Split(v, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				filter.Statuses = append(filter.Statuses, strings.ToUpper(status))
+			}
+		}
+	}
+	var err error
+	if filter.From, err = parseListTime(q.Get("from")); err != nil {
+		return filter, fmt.Errorf("%w: from: %w", errListQuery, err)
+	}
+	if filter.To, err = parseListTime(q.Get("to")); err != nil {
+		return filter, fmt.Errorf("%w: to: %w", errListQuery, err)
+	}
+	return filter, nil
+}
+
+func parseListTime(v string) (*time.Time, error) {
+	if v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		t, err = time.ParseInLocation(time.DateOnly, v, time.Local)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// setNextPage сообщает клиенту курсор следующей страницы в заголовках X-Next-Cursor и Link, не меняя формат тела ответа
+func setNextPage(w http.ResponseWriter, r *http.Request, next *domain.ListCursor) {
+	if next == nil {
+		return
+	}
+	cursor := next.Encode()
+	u := *r.URL
+	q := u.Query()
+	q.Set("cursor", cursor)
+	u.RawQuery = q.Encode()
+	w.Header().Set("X-Next-Cursor", cursor)
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", u.RequestURI()))
+}
@@ -2,56 +2,155 @@ package server
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
+	"loyalty-system/internal/accrual"
 	"loyalty-system/internal/config"
 	"loyalty-system/internal/domain/actions"
+	"loyalty-system/internal/domain/dbstorage/migrations"
+	"loyalty-system/internal/events"
 	"loyalty-system/pkg/logger"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/security"
 )
 
 type Server struct {
 	config             *config.Config
-	userStorage        *actions.UserStorage
-	transactionStorage *actions.TransactionRepo
+	userStorage        UserService
+	transactionStorage TransactionService
+	accrual            accrual.Client
+	storage            *actions.Storage
+	ownStorage         bool
+	keys               *security.KeySet
+	db                 *sql.DB
+	eventSink          events.Sink
 }
 
-func New(ctx context.Context, config *config.Config) (*Server, error) {
-	users, err := actions.GetUserStorage(ctx, config)
-	if err != nil {
-		return nil, err
+// New создаёт сервер. Сервисы, не заданные опциями, создаются по config; без опций сервер открывает одно хранилище
+// из конфигурации для пользователей и операций, подключается к системе начислений и применяет миграции PostgreSQL
+func New(ctx context.Context, config *config.Config, opts ...Option) (_ *Server, err error) {
+	a := &Server{config: config}
+	for _, opt := range opts {
+		opt(a)
 	}
-	transactions, err := actions.GetTransactionRepo(ctx, config)
-	if err != nil {
-		return nil, err
+	if a.storage == nil && (a.userStorage == nil || a.transactionStorage == nil) {
+		a.storage, err = actions.OpenStorage(ctx, config)
+		if err != nil {
+			return nil, fmt.Errorf("open storage: %w", err)
+		}
+		a.ownStorage = true
+		defer func() {
+			if err != nil {
+				a.storage.Close()
+			}
+		}()
 	}
-	return &Server{config: config, userStorage: &users, transactionStorage: &transactions}, nil
+	// миграции PostgreSQL применяются через пул хранилища, он же проверяется в /readyz
+	if a.storage != nil && a.storage.DB() != nil {
+		a.db = a.storage.DB()
+		applied, err := migrations.Up(ctx, a.db)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %w", err)
+		}
+		logger.Log.Info("Migrations done", zap.Int("applied", applied))
+	}
+	if a.userStorage == nil {
+		users, err := actions.GetUserStorage(config, a.storage)
+		if err != nil {
+			return nil, err
+		}
+		a.userStorage = &users
+	}
+	if a.transactionStorage == nil {
+		if a.accrual == nil {
+			a.accrual = actions.NewAccrualClient(config)
+		}
+		transactions, err := actions.NewTransactionRepo(config, a.storage, a.accrual)
+		if err != nil {
+			return nil, err
+		}
+		a.transactionStorage = &transactions
+	}
+	a.keys = security.NewHMACKeySet(config.JWTKey)
+	if config.JWTKeyDir != "" {
+		a.keys, err = security.LoadKeySet(config.JWTKeyDir)
+		if err != nil {
+			return nil, fmt.Errorf("load jwt keys: %w", err)
+		}
+	}
+	if config.EventSink != events.SinkNone {
+		a.eventSink, err = events.NewSink(config.EventSink, config.EventSinkTarget)
+		if err != nil {
+			return nil, fmt.Errorf("event sink: %w", err)
+		}
+	}
+	return a, nil
 }
 
-func (a *Server) Run(ctx context.Context) error {
-
+// Handler возвращает маршруты сервиса со всеми middleware; фоновые циклы при этом не запускаются
+func (a *Server) Handler() http.Handler {
 	mux := chi.NewRouter()
+	mux.Use(a.WithTracing)
 	mux.Use(a.WithLogging)
+	mux.Use(a.WithMetrics)
 	mux.Use(a.WithCompress)
 
-	mux.Post("/api/user/register", a.registerNewUser) //регистрация пользователя;
-	mux.Post("/api/user/login", a.loginUser)          //аутентификация пользователя;
+	mux.Get("/healthz", a.healthz)                      //процесс жив;
+	mux.Get("/readyz", a.readyz)                        //готовность принимать трафик: БД, миграции, система начислений, цикл обработки;
+	mux.Handle("/metrics", metrics.Handler())           //метрики Prometheus;
+	mux.Get("/.well-known/jwks.json", a.getJWKS)        //открытые ключи для проверки токенов другими сервисами;
+	mux.Post("/api/user/register", a.registerNewUser)   //регистрация пользователя;
+	mux.Post("/api/user/login", a.loginUser)            //аутентификация пользователя;
+	mux.Post("/api/user/token/refresh", a.refreshToken) //обновление пары токенов по refresh-токену;
 	mux.Route("/api/user", func(mux chi.Router) {
 		mux.Use(a.Auth)
-		mux.Post("/orders", a.loadOrders)              //загрузка пользователем номера заказа для расчёта;
-		mux.Get("/orders", a.getOrders)                //получение списка загруженных пользователем номеров заказов, статусов их обработки и информации о начислениях;
-		mux.Get("/balance", a.getBalance)              //получение текущего баланса счёта баллов лояльности пользователя;
-		mux.Post("/balance/withdraw", a.debitingFunds) //запрос на списание баллов с накопительного счёта в счёт оплаты нового заказа;
-		mux.Get("/withdrawals", a.debitHistory)        // получение информации о выводе средств с накопительного счёта пользователем.
+		mux.Post("/orders", a.loadOrders)                            //загрузка пользователем номера заказа для расчёта;
+		mux.Post("/orders/batch", a.loadOrdersBatch)                 //загрузка пачки номеров заказов (JSON-массив или по номеру на строку);
+		mux.Get("/orders", a.getOrders)                              //получение списка загруженных пользователем номеров заказов, статусов их обработки и информации о начислениях;
+		mux.Get("/balance", a.getBalance)                            //получение текущего баланса счёта баллов лояльности пользователя;
+		mux.Post("/balance/withdraw", a.debitingFunds)               //запрос на списание баллов с накопительного счёта в счёт оплаты нового заказа;
+		mux.Get("/withdrawals", a.debitHistory)                      // получение информации о выводе средств с накопительного счёта пользователем.
+		mux.Get("/adjustments", a.getAdjustments)                    //ручные корректировки баланса пользователя;
+		mux.Get("/ledger", a.getLedger)                              //все проведённые начисления и списания с остатком после каждой операции;
+		mux.Get("/statement", a.getStatement)                        //выписка за период: входящий остаток, операции, исходящий остаток;
+		mux.Get("/export", a.exportHistory)                          //выгрузка истории счёта в csv, json или ndjson;
+		mux.Post("/logout", a.logoutUser)                            //отзыв токенов пользователя;
+		mux.Post("/webhooks", a.addWebhook)                          //регистрация адреса для уведомлений о смене статусов заказов;
+		mux.Get("/webhooks", a.getWebhooks)                          //список адресов уведомлений с результатами последних доставок;
+		mux.Delete("/webhooks/{id}", a.deleteWebhook)                //удаление адреса уведомлений;
+		mux.Get("/webhooks/{id}/deliveries", a.getWebhookDeliveries) //журнал доставок уведомлений;
+		mux.Get("/events", a.streamEvents)                           //поток событий по заказам и балансу пользователя (SSE).
 	})
+	mux.Route("/api/admin", func(mux chi.Router) {
+		mux.Use(a.Auth)
+		mux.Use(a.AdminOnly)
+		mux.Get("/users", a.adminSearchUsers)                     //поиск пользователей по части логина;
+		mux.Get("/users/{id}", a.adminGetUser)                    //сведения о пользователе;
+		mux.Get("/users/{id}/orders", a.adminGetOrders)           //заказы пользователя;
+		mux.Get("/users/{id}/withdrawals", a.adminGetWithdrawals) //списания пользователя;
+		mux.Get("/users/{id}/balance", a.adminGetBalance)         //баланс пользователя;
+		mux.Post("/users/{id}/lock", a.adminLockUser)             //блокировка пользователя;
+		mux.Post("/users/{id}/unlock", a.adminUnlockUser)         //разблокировка пользователя;
+		mux.Post("/users/{id}/adjustments", a.adminAdjustBalance) //ручная корректировка баланса с указанием причины;
+		mux.Post("/orders/{number}/reset", a.adminResetOrder)     //возврат заказа в статус NEW для повторного расчёта;
+		mux.Get("/export", a.adminExport)                         //выгрузка операций всех пользователей за период;
+		mux.Get("/audit", a.adminGetAudit)                        //журнал действий администраторов.
+	})
+	return mux
+}
 
+func (a *Server) Run(ctx context.Context) error {
 	logger.Log.Info("Starting server", zap.String("address", a.config.Host))
 
 	httpServer := &http.Server{
 		Addr:    a.config.Host,
-		Handler: mux,
+		Handler: a.Handler(),
 	}
 
 	g := errgroup.Group{}
@@ -61,6 +160,37 @@ func (a *Server) Run(ctx context.Context) error {
 	g.Go(func() error {
 		return a.transactionStorage.RunProcessing(ctx, a.config.BatchLimit, a.config.SendLimit, a.config.PollInterval)
 	})
+	g.Go(func() error {
+		return a.transactionStorage.RunReconciliation(ctx, a.config.ReconcileInterval, a.config.ReconcileRepair)
+	})
+	// без срока действия баллы не сгорают и списывать нечего
+	if a.config.PointsTTL > 0 {
+		g.Go(func() error {
+			return a.transactionStorage.RunExpiry(ctx, a.config.BatchLimit, a.config.ExpiryInterval)
+		})
+	}
+	g.Go(func() error {
+		return a.transactionStorage.RunNotifications(ctx)
+	})
+	g.Go(func() error {
+		return a.transactionStorage.RunWebhookDispatcher(ctx, a.config.BatchLimit, a.config.SendLimit, a.config.WebhookInterval)
+	})
+	g.Go(func() error {
+		return a.transactionStorage.RunWebhookCleanup(ctx, a.config.WebhookRetention)
+	})
+	if a.eventSink != nil {
+		g.Go(func() error {
+			return a.transactionStorage.RunOutboxPublisher(ctx, a.eventSink, a.config.BatchLimit, a.config.EventInterval)
+		})
+	}
+	g.Go(func() error {
+		return a.transactionStorage.RunOutboxCleanup(ctx, a.config.OutboxRetention, a.eventSink == nil)
+	})
+	if a.config.JWTKeyDir != "" {
+		g.Go(func() error {
+			return a.reloadKeys(ctx)
+		})
+	}
 	g.Go(func() error {
 		<-ctx.Done()
 		return httpServer.Shutdown(ctx)
@@ -70,9 +200,30 @@ func (a *Server) Run(ctx context.Context) error {
 		logger.Log.Info("Shutdown", zap.Error(err))
 		a.RouterShutdown(ctx)
 	}
+	// хранилище, переданное WithStorage, закрывает вызывающий
+	if a.ownStorage {
+		if err := a.storage.Close(); err != nil {
+			logger.Log.Error("Close storage", zap.Error(err))
+		}
+	}
 	return nil
 }
 
+func (a *Server) reloadKeys(ctx context.Context) error {
+	ticker := time.NewTicker(time.Second * time.Duration(a.config.JWTKeyReload))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.keys.Reload(); err != nil {
+				logger.Log.Error("Reload jwt keys", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
 func (a *Server) RouterShutdown(ctx context.Context) {
 	logger.Log.Info("Router shutting down gracefully")
 }
@@ -0,0 +1,663 @@
+package server_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"loyalty-system/internal/config"
+	"loyalty-system/internal/domain"
+	"loyalty-system/internal/domain/actions"
+	"loyalty-system/internal/events"
+	"loyalty-system/internal/server"
+	"loyalty-system/pkg/accrualmock"
+	"loyalty-system/pkg/security"
+)
+
+// reservedLogin закрыт для самостоятельной регистрации
+const reservedLogin = "root"
+
+// seq нумерует логины и номера заказов, создаваемые тестами
+var seq atomic.Int64
+
+func nextLogin() string {
+	return "user" + strconv.FormatInt(seq.Add(1), 10)
+}
+
+// nextOrder возвращает новый номер заказа, проходящий проверку Луна
+func nextOrder() string {
+	payload := strconv.FormatInt(100000+seq.Add(1), 10)
+	sum := 0
+	for i := 0; i < len(payload); i++ {
+		d := int(payload[len(payload)-1-i] - '0')
+		if i%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return payload + strconv.Itoa((10-sum%10)%10)
+}
+
+func testConfig(accrualHost string) *config.Config {
+	return &config.Config{
+		Storage:           config.StorageMemory,
+		AccrualHost:       accrualHost,
+		AccrualTimeout:    5,
+		Salt:              "salt",
+		PasswordHasher:    "bcrypt",
+		JWTKey:            "test-key",
+		JWTKeyReload:      60,
+		JWTExp:            1,
+		RefreshExp:        24,
+		BatchLimit:        100,
+		SendLimit:         10,
+		PollInterval:      1,
+		ReconcileInterval: 60,
+		ReadyTimeout:      2,
+		EventSink:         events.SinkNone,
+		OrderValidation:   "luhn",
+		AdminLogins:       reservedLogin,
+		ExpiryNotice:      30,
+	}
+}
+
+type testServer struct {
+	t       *testing.T
+	url     string
+	client  *http.Client
+	accrual *accrualmock.Server
+	users   *actions.UserStorage
+}
+
+// newTestServer поднимает обработчики сервера на хранилище в памяти и имитации системы начислений
+// и запускает обработку заказов и раздачу событий
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	mock := accrualmock.New(accrualmock.Config{})
+	accrualServer := httptest.NewServer(mock)
+	cfg := testConfig(accrualServer.URL)
+
+	storage, err := actions.OpenStorage(ctx, cfg)
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	users, err := actions.GetUserStorage(cfg, storage)
+	if err != nil {
+		t.Fatalf("user storage: %v", err)
+	}
+	transactions, err := actions.NewTransactionRepo(cfg, storage, actions.NewAccrualClient(cfg))
+	if err != nil {
+		t.Fatalf("transaction repo: %v", err)
+	}
+	srv, err := server.New(ctx, cfg, server.WithUserService(&users), server.WithTransactionService(&transactions))
+	if err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		transactions.RunProcessing(ctx, cfg.BatchLimit, cfg.SendLimit, cfg.PollInterval)
+		done <- struct{}{}
+	}()
+	go func() {
+		transactions.RunNotifications(ctx)
+		done <- struct{}{}
+	}()
+	httpServer := httptest.NewServer(srv.Handler())
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		<-done
+		httpServer.Close()
+		accrualServer.Close()
+	})
+	return &testServer{t: t, url: httpServer.URL, client: httpServer.Client(), accrual: mock, users: &users}
+}
+
+type response struct {
+	code   int
+	header http.Header
+	body   string
+}
+
+func (s *testServer) do(method, path, token, contentType, body string) response {
+	s.t.Helper()
+	req, err := http.NewRequest(method, s.url+path, strings.NewReader(body))
+	if err != nil {
+		s.t.Fatalf("new request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.t.Fatalf("%s %s: read body: %v", method, path, err)
+	}
+	return response{code: resp.StatusCode, header: resp.Header, body: string(data)}
+}
+
+func (s *testServer) get(path, token string) response {
+	s.t.Helper()
+	return s.do(http.MethodGet, path, token, "", "")
+}
+
+func (s *testServer) post(path, token, body string) response {
+	s.t.Helper()
+	return s.do(http.MethodPost, path, token, "application/json", body)
+}
+
+func (s *testServer) expect(r response, code int, what string) {
+	s.t.Helper()
+	if r.code != code {
+		s.t.Fatalf("%s: status %d, want %d; body %q", what, r.code, code, r.body)
+	}
+}
+
+func decode[T any](t *testing.T, r response) T {
+	t.Helper()
+	var v T
+	if err := json.Unmarshal([]byte(r.body), &v); err != nil {
+		t.Fatalf("decode %q: %v", r.body, err)
+	}
+	return v
+}
+
+func credentials(login, password string) string {
+	body, _ := json.Marshal(domain.User{Login: login, Password: password})
+	return string(body)
+}
+
+// register регистрирует пользователя и возвращает его пару токенов
+func (s *testServer) register(login string) domain.TokenPair {
+	s.t.Helper()
+	r := s.post("/api/user/register", "", credentials(login, "secret"))
+	s.expect(r, http.StatusOK, "register "+login)
+	pair := decode[domain.TokenPair](s.t, r)
+	if r.header.Get("Authorization") != pair.AccessToken {
+		s.t.Fatalf("register: Authorization header differs from access_token")
+	}
+	return pair
+}
+
+// admin создаёт администратора так же, как команда gophermart admin create, и возвращает его токен
+func (s *testServer) admin() string {
+	s.t.Helper()
+	login := nextLogin()
+	if err := s.users.CreateAdmin(context.Background(), login, "secret"); err != nil {
+		s.t.Fatalf("create admin: %v", err)
+	}
+	r := s.post("/api/user/login", "", credentials(login, "secret"))
+	s.expect(r, http.StatusOK, "admin login")
+	return decode[domain.TokenPair](s.t, r).AccessToken
+}
+
+func (s *testServer) userID(adminToken, login string) int64 {
+	s.t.Helper()
+	r := s.get("/api/admin/users?login="+login, adminToken)
+	s.expect(r, http.StatusOK, "search users")
+	for _, v := range decode[[]domain.UserInfo](s.t, r) {
+		if v.Login == login {
+			return v.ID
+		}
+	}
+	s.t.Fatalf("user %s not found", login)
+	return 0
+}
+
+// processedOrder загружает заказ, по которому система начислений начислит accrual, и ждёт окончания расчёта
+func (s *testServer) processedOrder(token string, accrual float64) string {
+	s.t.Helper()
+	number := nextOrder()
+	s.accrual.SetOrder(number, accrualmock.StatusProcessed, accrual)
+	s.expect(s.do(http.MethodPost, "/api/user/orders", token, "text/plain", number), http.StatusAccepted, "upload order")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		r := s.get("/api/user/orders", token)
+		s.expect(r, http.StatusOK, "get orders")
+		for _, v := range decode[[]domain.Order](s.t, r) {
+			if v.Number == number && v.Status == "PROCESSED" {
+				return number
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	s.t.Fatalf("order %s was not processed", number)
+	return ""
+}
+
+func TestHealth(t *testing.T) {
+	s := newTestServer(t)
+	s.expect(s.get("/healthz", ""), http.StatusOK, "healthz")
+
+	// цикл обработки отмечается при запуске, имитация начислений отвечает на запросы
+	r := s.get("/readyz", "")
+	s.expect(r, http.StatusOK, "readyz")
+	if status := decode[map[string]any](t, r)["status"]; status != "ok" {
+		t.Errorf("readyz status = %v, want ok", status)
+	}
+
+	r = s.get("/metrics", "")
+	s.expect(r, http.StatusOK, "metrics")
+	if !strings.Contains(r.body, "# TYPE") {
+		t.Errorf("metrics body is not in Prometheus format: %.100q", r.body)
+	}
+
+	r = s.get("/.well-known/jwks.json", "")
+	s.expect(r, http.StatusOK, "jwks")
+	if _, ok := decode[map[string]any](t, r)["keys"]; !ok {
+		t.Errorf("jwks without keys: %s", r.body)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	s := newTestServer(t)
+	login := nextLogin()
+	pair := s.register(login)
+
+	s.expect(s.post("/api/user/register", "", credentials(login, "other")), http.StatusConflict, "register existing login")
+	s.expect(s.post("/api/user/register", "", credentials(reservedLogin, "secret")), http.StatusForbidden, "register reserved login")
+	s.expect(s.post("/api/user/register", "", `{"login":"`+nextLogin()+`"}`), http.StatusBadRequest, "register without password")
+	s.expect(s.post("/api/user/register", "", `{`), http.StatusBadRequest, "register with broken json")
+	long := strings.Repeat("x", security.MaxPasswordLength+1)
+	s.expect(s.post("/api/user/register", "", credentials(nextLogin(), long)), http.StatusBadRequest, "register with too long password")
+	s.expect(s.post("/api/user/login", "", credentials(login, long)), http.StatusUnauthorized, "login with too long password")
+
+	s.expect(s.post("/api/user/login", "", credentials(login, "secret")), http.StatusOK, "login")
+	s.expect(s.post("/api/user/login", "", credentials(login, "wrong")), http.StatusUnauthorized, "login with wrong password")
+	s.expect(s.post("/api/user/login", "", credentials(nextLogin(), "secret")), http.StatusUnauthorized, "login of unknown user")
+
+	s.expect(s.get("/api/user/balance", ""), http.StatusUnauthorized, "without token")
+	s.expect(s.get("/api/user/balance", "garbage"), http.StatusUnauthorized, "with broken token")
+	s.expect(s.get("/api/user/balance", pair.RefreshToken), http.StatusUnauthorized, "with refresh token")
+
+	// refresh-токен одноразовый
+	refreshBody := `{"refresh_token":"` + pair.RefreshToken + `"}`
+	r := s.post("/api/user/token/refresh", "", refreshBody)
+	s.expect(r, http.StatusOK, "refresh")
+	refreshed := decode[domain.TokenPair](t, r)
+	s.expect(s.post("/api/user/token/refresh", "", refreshBody), http.StatusUnauthorized, "reused refresh token")
+	s.expect(s.get("/api/user/balance", refreshed.AccessToken), http.StatusOK, "refreshed access token")
+
+	s.expect(s.post("/api/user/logout", refreshed.AccessToken, `{"refresh_token":"`+refreshed.RefreshToken+`"}`), http.StatusOK, "logout")
+	s.expect(s.get("/api/user/balance", refreshed.AccessToken), http.StatusUnauthorized, "access token after logout")
+	s.expect(s.post("/api/user/token/refresh", "", `{"refresh_token":"`+refreshed.RefreshToken+`"}`), http.StatusUnauthorized,
+		"refresh token after logout")
+}
+
+// у каждого сервера своё хранилище: данные одного не видны другому
+func TestStorageNotShared(t *testing.T) {
+	first, second := newTestServer(t), newTestServer(t)
+	login := nextLogin()
+	first.register(login)
+	second.expect(second.post("/api/user/login", "", credentials(login, "secret")), http.StatusUnauthorized, "login on other server")
+	second.register(login)
+}
+
+func TestOrders(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register(nextLogin()).AccessToken
+	other := s.register(nextLogin()).AccessToken
+
+	s.expect(s.get("/api/user/orders", token), http.StatusNoContent, "orders of new user")
+	number := s.processedOrder(token, 500)
+	s.expect(s.do(http.MethodPost, "/api/user/orders", token, "text/plain", number), http.StatusOK, "upload own order again")
+	s.expect(s.do(http.MethodPost, "/api/user/orders", other, "text/plain", number), http.StatusConflict, "upload order of another user")
+	s.expect(s.do(http.MethodPost, "/api/user/orders", token, "text/plain", "12345"), http.StatusUnprocessableEntity, "upload invalid number")
+
+	r := s.get("/api/user/orders", token)
+	s.expect(r, http.StatusOK, "get orders")
+	orders := decode[[]domain.Order](t, r)
+	if len(orders) != 1 || orders[0].Accrual == nil || *orders[0].Accrual != 50000 {
+		t.Fatalf("orders = %s, want one order with accrual 500", r.body)
+	}
+	s.expect(s.get("/api/user/orders?limit=0", token), http.StatusBadRequest, "orders with bad limit")
+
+	fresh := nextOrder()
+	batch := `["` + fresh + `", "` + number + `", "12345"]`
+	r = s.post("/api/user/orders/batch", token, batch)
+	s.expect(r, http.StatusOK, "upload batch")
+	results := map[string]string{}
+	for _, v := range decode[[]domain.OrderUploadResult](t, r) {
+		results[v.Number] = v.Result
+	}
+	want := map[string]string{fresh: domain.UploadAccepted, number: domain.UploadAlreadyUploaded, "12345": domain.UploadInvalidFormat}
+	for k, v := range want {
+		if results[k] != v {
+			t.Errorf("batch result for %s = %q, want %q", k, results[k], v)
+		}
+	}
+	s.expect(s.do(http.MethodPost, "/api/user/orders/batch", token, "text/plain", "\n\n"), http.StatusBadRequest, "empty batch")
+	huge := strings.Repeat(nextOrder()+"\n", 20000)
+	s.expect(s.do(http.MethodPost, "/api/user/orders/batch", token, "text/plain", huge), http.StatusRequestEntityTooLarge, "oversized batch")
+}
+
+func TestBalance(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register(nextLogin()).AccessToken
+	s.processedOrder(token, 500)
+
+	r := s.get("/api/user/balance", token)
+	s.expect(r, http.StatusOK, "balance")
+	if balance := decode[domain.Balance](t, r); balance.Current != 50000 || balance.Withdrawn != 0 {
+		t.Fatalf("balance = %s, want 500/0", r.body)
+	}
+
+	s.expect(s.get("/api/user/withdrawals", token), http.StatusNoContent, "withdrawals before withdraw")
+	spent := nextOrder()
+	s.expect(s.post("/api/user/balance/withdraw", token, `{"order":"`+spent+`","sum":200}`), http.StatusOK, "withdraw")
+	s.expect(s.post("/api/user/balance/withdraw", token, `{"order":"`+nextOrder()+`","sum":1000}`), http.StatusPaymentRequired,
+		"withdraw over balance")
+	s.expect(s.post("/api/user/balance/withdraw", token, `{"order":"12345","sum":1}`), http.StatusUnprocessableEntity,
+		"withdraw with invalid number")
+	s.expect(s.post("/api/user/balance/withdraw", token, `{"order":"`+nextOrder()+`","sum":-100}`), http.StatusUnprocessableEntity,
+		"withdraw of negative sum")
+	s.expect(s.post("/api/user/balance/withdraw", token, `{"order":"`+nextOrder()+`","sum":0}`), http.StatusUnprocessableEntity,
+		"withdraw of zero sum")
+
+	r = s.get("/api/user/balance", token)
+	if balance := decode[domain.Balance](t, r); balance.Current != 30000 || balance.Withdrawn != 20000 {
+		t.Fatalf("balance after withdraw = %s, want 300/200", r.body)
+	}
+	r = s.get("/api/user/withdrawals", token)
+	s.expect(r, http.StatusOK, "withdrawals")
+	if withdrawals := decode[[]domain.Withdraw](t, r); len(withdrawals) != 1 || withdrawals[0].Order != spent {
+		t.Fatalf("withdrawals = %s, want withdraw %s", r.body, spent)
+	}
+
+	r = s.get("/api/user/ledger", token)
+	s.expect(r, http.StatusOK, "ledger")
+	if ledger := decode[[]domain.LedgerEntry](t, r); len(ledger) != 2 {
+		t.Fatalf("ledger = %s, want accrual and withdraw", r.body)
+	}
+	r = s.get("/api/user/statement", token)
+	s.expect(r, http.StatusOK, "statement")
+	if statement := decode[domain.Statement](t, r); statement.Credited != 50000 || statement.Debited != 20000 || statement.Closing != 30000 {
+		t.Fatalf("statement = %s, want credited 500, debited 200, closing 300", r.body)
+	}
+	s.expect(s.get("/api/user/statement?from=2024-02-01&to=2024-01-01", token), http.StatusBadRequest, "statement with reversed period")
+	s.expect(s.get("/api/user/adjustments", token), http.StatusNoContent, "adjustments without adjustments")
+
+	r = s.get("/api/user/export?format=csv", token)
+	s.expect(r, http.StatusOK, "export")
+	if lines := strings.Split(strings.TrimSpace(r.body), "\n"); len(lines) != 3 {
+		t.Fatalf("export = %q, want header and two rows", r.body)
+	}
+	s.expect(s.get("/api/user/export?format=xml", token), http.StatusBadRequest, "export in unknown format")
+}
+
+func TestWebhooks(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register(nextLogin()).AccessToken
+
+	s.expect(s.get("/api/user/webhooks", token), http.StatusNoContent, "webhooks before registration")
+	// адрес публичный, но рассыльщик в тесте не запущен, поэтому запросов на него не будет
+	r := s.post("/api/user/webhooks", token, `{"url":"https://93.184.216.34/hook"}`)
+	s.expect(r, http.StatusCreated, "add webhook")
+	webhook := decode[domain.Webhook](t, r)
+	if webhook.Secret == "" {
+		t.Errorf("webhook secret is empty")
+	}
+	s.expect(s.post("/api/user/webhooks", token, `{"url":"http://127.0.0.1/hook"}`), http.StatusBadRequest, "webhook to loopback")
+	s.expect(s.post("/api/user/webhooks", token, `{"url":"ftp://example.com"}`), http.StatusBadRequest, "webhook with bad scheme")
+
+	r = s.get("/api/user/webhooks", token)
+	s.expect(r, http.StatusOK, "get webhooks")
+	if webhooks := decode[[]domain.Webhook](t, r); len(webhooks) != 1 || webhooks[0].Secret != "" {
+		t.Fatalf("webhooks = %s, want one webhook without secret", r.body)
+	}
+
+	s.processedOrder(token, 10)
+	path := "/api/user/webhooks/" + strconv.FormatInt(webhook.ID, 10)
+	r = s.get(path+"/deliveries", token)
+	s.expect(r, http.StatusOK, "deliveries")
+	deliveries := decode[[]domain.WebhookDelivery](t, r)
+	if len(deliveries) == 0 || deliveries[0].EventType != domain.EventOrderStatusChanged || deliveries[0].Status != domain.DeliveryPending {
+		t.Fatalf("deliveries = %s, want pending %s", r.body, domain.EventOrderStatusChanged)
+	}
+	other := s.register(nextLogin()).AccessToken
+	s.expect(s.get(path+"/deliveries", other), http.StatusNotFound, "deliveries of another user's webhook")
+	s.expect(s.get("/api/user/webhooks/999999/deliveries", token), http.StatusNotFound, "deliveries of unknown webhook")
+	r = s.post("/api/user/webhooks", other, `{"url":"https://93.184.216.34/other"}`)
+	s.expect(r, http.StatusCreated, "add webhook of another user")
+	otherPath := "/api/user/webhooks/" + strconv.FormatInt(decode[domain.Webhook](t, r).ID, 10)
+	s.expect(s.get(otherPath+"/deliveries", other), http.StatusNoContent, "deliveries of webhook without deliveries")
+	s.expect(s.do(http.MethodDelete, path, other, "", ""), http.StatusNotFound, "delete another user's webhook")
+
+	s.expect(s.do(http.MethodDelete, path, token, "", ""), http.StatusNoContent, "delete webhook")
+	s.expect(s.do(http.MethodDelete, path, token, "", ""), http.StatusNotFound, "delete deleted webhook")
+	s.expect(s.do(http.MethodDelete, "/api/user/webhooks/abc", token, "", ""), http.StatusBadRequest, "delete with bad id")
+}
+
+func TestEvents(t *testing.T) {
+	s := newTestServer(t)
+	token := s.register(nextLogin()).AccessToken
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url+"/api/user/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", token)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		t.Fatalf("events: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("events: status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	number := nextOrder()
+	s.expect(s.do(http.MethodPost, "/api/user/orders", token, "text/plain", number), http.StatusAccepted, "upload order")
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		if lines.Text() == "event: "+domain.EventOrderAccepted {
+			return
+		}
+	}
+	t.Fatalf("no %s event in stream: %v", domain.EventOrderAccepted, lines.Err())
+}
+
+func TestAdmin(t *testing.T) {
+	s := newTestServer(t)
+	admin := s.admin()
+	login := nextLogin()
+	token := s.register(login).AccessToken
+	number := s.processedOrder(token, 100)
+
+	s.expect(s.get("/api/admin/users", token), http.StatusForbidden, "admin route for user")
+	id := s.userID(admin, login)
+	user := "/api/admin/users/" + strconv.FormatInt(id, 10)
+	s.expect(s.get("/api/admin/users?limit=0", admin), http.StatusBadRequest, "search with bad limit")
+
+	r := s.get(user, admin)
+	s.expect(r, http.StatusOK, "get user")
+	if info := decode[domain.UserInfo](t, r); info.Login != login || info.Role != domain.RoleUser {
+		t.Errorf("user = %s, want %s with role user", r.body, login)
+	}
+	s.expect(s.get("/api/admin/users/0", admin), http.StatusNotFound, "get unknown user")
+	s.expect(s.get(user+"/orders", admin), http.StatusOK, "user orders")
+	s.expect(s.get(user+"/withdrawals", admin), http.StatusNoContent, "user withdrawals")
+
+	s.expect(s.post(user+"/adjustments", admin, `{"amount":25,"reason":"goodwill"}`), http.StatusOK, "adjust balance")
+	s.expect(s.post(user+"/adjustments", admin, `{"amount":25}`), http.StatusBadRequest, "adjustment without reason")
+	s.expect(s.post(user+"/adjustments", admin, `{"amount":-1000,"reason":"fraud"}`), http.StatusConflict, "adjustment below zero")
+	s.expect(s.post("/api/admin/users/0/adjustments", admin, `{"amount":1,"reason":"bonus"}`), http.StatusNotFound, "adjust unknown user")
+	r = s.get(user+"/balance", admin)
+	s.expect(r, http.StatusOK, "user balance")
+	if balance := decode[domain.Balance](t, r); balance.Current != 12500 {
+		t.Errorf("balance = %s, want 125", r.body)
+	}
+	// просмотр данных несуществующего пользователя не попадает в журнал
+	for _, path := range []string{"", "/orders", "/withdrawals", "/balance"} {
+		s.expect(s.get("/api/admin/users/999999"+path, admin), http.StatusNotFound, "unknown user"+path)
+	}
+	s.expect(s.get("/api/admin/audit?user=999999", admin), http.StatusNoContent, "audit of unknown user")
+	r = s.get("/api/user/adjustments", token)
+	s.expect(r, http.StatusOK, "own adjustments")
+	if adjustments := decode[[]domain.Adjustment](t, r); len(adjustments) != 1 || adjustments[0].Reason != "goodwill" {
+		t.Errorf("adjustments = %s, want one goodwill adjustment", r.body)
+	}
+
+	s.expect(s.post(user+"/lock", admin, ""), http.StatusOK, "lock user")
+	s.expect(s.get("/api/user/balance", token), http.StatusForbidden, "locked user")
+	s.expect(s.post("/api/user/login", "", credentials(login, "secret")), http.StatusForbidden, "login of locked user")
+	s.expect(s.post(user+"/unlock", admin, ""), http.StatusOK, "unlock user")
+	s.expect(s.get("/api/user/balance", token), http.StatusOK, "unlocked user")
+	s.expect(s.post("/api/admin/users/0/lock", admin, ""), http.StatusNotFound, "lock unknown user")
+
+	s.expect(s.post("/api/admin/orders/"+number+"/reset", admin, ""), http.StatusConflict, "reset processed order")
+	s.expect(s.post("/api/admin/orders/"+nextOrder()+"/reset", admin, ""), http.StatusNotFound, "reset unknown order")
+
+	s.expect(s.get("/api/admin/export", admin), http.StatusBadRequest, "export without period")
+	from := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	to := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	r = s.get("/api/admin/export?format=ndjson&from="+from+"&to="+to, admin)
+	s.expect(r, http.StatusOK, "export")
+	if !strings.Contains(r.body, `"number":"`+number+`"`) {
+		t.Errorf("export does not contain order %s", number)
+	}
+
+	r = s.get("/api/admin/audit?user="+strconv.FormatInt(id, 10), admin)
+	s.expect(r, http.StatusOK, "audit")
+	actionsSeen := map[string]bool{}
+	for _, v := range decode[[]domain.AuditRecord](t, r) {
+		actionsSeen[v.Action] = true
+	}
+	for _, action := range []string{domain.AuditViewUser, domain.AuditAdjustBalance, domain.AuditLockUser, domain.AuditUnlockUser} {
+		if !actionsSeen[action] {
+			t.Errorf("audit has no %s record: %s", action, r.body)
+		}
+	}
+}
+
+// unavailableAccrual - система начислений, которая не отвечает
+type unavailableAccrual struct{}
+
+func (unavailableAccrual) GetOrder(ctx context.Context, number string) (*domain.Accrual, error) {
+	return nil, errors.New("accrual system is unavailable")
+}
+
+func (unavailableAccrual) Ping(ctx context.Context) error {
+	return errors.New("accrual system is unavailable")
+}
+
+func TestWithAccrualClient(t *testing.T) {
+	cfg := testConfig("http://127.0.0.1:1")
+	srv, err := server.New(context.Background(), cfg, server.WithAccrualClient(unavailableAccrual{}))
+	if err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	httpServer := httptest.NewServer(srv.Handler())
+	defer httpServer.Close()
+	resp, err := httpServer.Client().Get(httpServer.URL + "/readyz")
+	if err != nil {
+		t.Fatalf("readyz: %v", err)
+	}
+	defer resp.Body.Close()
+	var status struct {
+		Checks map[string]struct {
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"checks"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("decode readyz: %v", err)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || status.Checks["accrual_system"].Status != "fail" {
+		t.Fatalf("readyz: status %d, accrual_system %+v; want 503 and fail from the given client",
+			resp.StatusCode, status.Checks["accrual_system"])
+	}
+}
+
+// failingAccrual - система начислений, которая отвечает ошибкой по заказу failing и начисляет sum по остальным
+type failingAccrual struct {
+	failing string
+	sum     domain.CustomMoney
+}
+
+func (a failingAccrual) GetOrder(ctx context.Context, number string) (*domain.Accrual, error) {
+	if number == a.failing {
+		return nil, errors.New("unexpected response")
+	}
+	return &domain.Accrual{Order: number, Status: "PROCESSED", Sum: &a.sum}, nil
+}
+
+func (failingAccrual) Ping(ctx context.Context) error {
+	return nil
+}
+
+// ошибка по одному заказу не останавливает обработку остальных заказов пачки
+func TestProcessingSkipsFailedOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := testConfig("http://127.0.0.1:1")
+	client := failingAccrual{failing: nextOrder(), sum: 30000}
+	storage, err := actions.OpenStorage(ctx, cfg)
+	if err != nil {
+		t.Fatalf("open storage: %v", err)
+	}
+	users, err := actions.GetUserStorage(cfg, storage)
+	if err != nil {
+		t.Fatalf("user storage: %v", err)
+	}
+	transactions, err := actions.NewTransactionRepo(cfg, storage, client)
+	if err != nil {
+		t.Fatalf("transaction repo: %v", err)
+	}
+	login := nextLogin()
+	if err = users.NewUser(ctx, login, "secret"); err != nil {
+		t.Fatalf("new user: %v", err)
+	}
+	user, err := users.LoginUser(ctx, login, "secret")
+	if err != nil {
+		t.Fatalf("login user: %v", err)
+	}
+	for _, number := range []string{client.failing, nextOrder()} {
+		if err = transactions.NewOrder(ctx, user.ID, number); !errors.Is(err, actions.ErrOrderAccepted) {
+			t.Fatalf("new order %s: %v", number, err)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		transactions.RunProcessing(ctx, cfg.BatchLimit, cfg.SendLimit, cfg.PollInterval)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		balance, err := transactions.GetBalance(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("get balance: %v", err)
+		}
+		if balance.Current == client.sum {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("accrual of the healthy order was not saved")
+}
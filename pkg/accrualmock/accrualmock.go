@@ -0,0 +1,278 @@
+// Package accrualmock имитирует систему расчёта начислений: регистрацию товаров с вознаграждением, регистрацию заказов,
+// смену статусов REGISTERED -> PROCESSING -> PROCESSED/INVALID со временем, ограничение частоты запросов (429 с Retry-After),
+// ошибки 500 и задержку ответов. Server - http.Handler, его можно запустить через httptest.NewServer
+package accrualmock
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+)
+
+const (
+	RewardPercent = "%"
+	RewardPoints  = "pt"
+)
+
+type Config struct {
+	// StatusDelay - сколько заказ остаётся в каждом промежуточном статусе; 0 - расчёт готов сразу
+	StatusDelay time.Duration
+	// RateLimit - сколько запросов GET /api/orders/{number} принимается за минуту; 0 - без ограничения
+	RateLimit int
+	// RetryAfter - значение заголовка Retry-After в секундах; 0 - до конца текущей минуты
+	RetryAfter int
+	// ErrorRate - доля запросов заказа, на которые отвечает 500
+	ErrorRate float64
+	// Latency - задержка перед каждым ответом
+	Latency time.Duration
+	// AutoAccrual - начисление для незарегистрированных заказов: такие заказы регистрируются при первом запросе;
+	// 0 - незарегистрированный заказ возвращает 204, как и настоящая система
+	AutoAccrual float64
+	// Seed - начальное значение генератора для ErrorRate
+	Seed int64
+	// Clock - источник времени; по умолчанию time.Now
+	Clock func() time.Time
+}
+
+// Good - позиция заказа
+type Good struct {
+	Description string  `json:"description"`
+	Price       float64 `json:"price"`
+}
+
+// Rule - вознаграждение за товары, в описании которых встречается Match
+type Rule struct {
+	Match      string  `json:"match"`
+	Reward     float64 `json:"reward"`
+	RewardType string  `json:"reward_type"`
+}
+
+// Accrual - ответ на запрос расчёта по заказу
+type Accrual struct {
+	Order   string   `json:"order"`
+	Status  string   `json:"status"`
+	Accrual *float64 `json:"accrual,omitempty"`
+}
+
+type order struct {
+	registeredAt time.Time
+	goods        []Good
+	// fixed - итог, заданный через SetOrder; без него итог считается по правилам
+	fixed *Accrual
+}
+
+type Server struct {
+	cfg    Config
+	router chi.Router
+
+	mu          sync.Mutex
+	rules       []Rule
+	orders      map[string]*order
+	rnd         *rand.Rand
+	window      time.Time
+	requests    int
+	failNext    int
+	throttleFor int
+	throttled   int
+}
+
+func New(cfg Config) *Server {
+	if cfg.Clock == nil {
+		cfg.Clock = time.Now
+	}
+	s := &Server{
+		cfg:    cfg,
+		orders: make(map[string]*order),
+		rnd:    rand.New(rand.NewSource(cfg.Seed)),
+	}
+	r := chi.NewRouter()
+	r.Use(s.withLatency)
+	r.Get("/api/orders/{number}", s.getOrder)
+	r.Post("/api/orders", s.registerOrder)
+	r.Post("/api/goods", s.registerRule)
+	s.router = r
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// AddRule регистрирует вознаграждение; правило с тем же Match уже зарегистрировано - ErrRuleExists
+func (s *Server) AddRule(rule Rule) error {
+	if rule.Match == "" || rule.Reward <= 0 || (rule.RewardType != RewardPercent && rule.RewardType != RewardPoints) {
+		return ErrBadRequest
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, v := range s.rules {
+		if v.Match == rule.Match {
+			return ErrRuleExists
+		}
+	}
+	s.rules = append(s.rules, rule)
+	return nil
+}
+
+// AddOrder регистрирует заказ для расчёта; повторная регистрация номера - ErrOrderExists
+func (s *Server) AddOrder(number string, goods []Good) error {
+	if number == "" {
+		return ErrBadRequest
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.orders[number]; ok {
+		return ErrOrderExists
+	}
+	s.orders[number] = &order{registeredAt: s.cfg.Clock(), goods: goods}
+	return nil
+}
+
+// SetOrder задаёт итог расчёта заказа, минуя правила: status - PROCESSED или INVALID (accrual не используется).
+// Промежуточные статусы, как и для обычных заказов, отсчитываются от момента вызова
+func (s *Server) SetOrder(number string, status string, accrual float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := &Accrual{Order: number, Status: status}
+	if status == StatusProcessed {
+		result.Accrual = &accrual
+	}
+	s.orders[number] = &order{registeredAt: s.cfg.Clock(), fixed: result}
+}
+
+// FailNext отвечает 500 на следующие n запросов заказа
+func (s *Server) FailNext(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failNext = n
+}
+
+// ThrottleNext отвечает 429 с Retry-After: retryAfter на следующие n запросов заказа
+func (s *Server) ThrottleNext(n int, retryAfter int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttled = n
+	s.throttleFor = retryAfter
+}
+
+// Order возвращает текущее состояние расчёта заказа, как его увидит клиент; false - заказ не зарегистрирован
+func (s *Server) Order(number string) (Accrual, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[number]
+	if !ok {
+		return Accrual{}, false
+	}
+	return s.accrual(number, o), true
+}
+
+// accrual вычисляет статус заказа на текущий момент. Вызывается под s.mu
+func (s *Server) accrual(number string, o *order) Accrual {
+	elapsed := s.cfg.Clock().Sub(o.registeredAt)
+	switch {
+	case elapsed < s.cfg.StatusDelay:
+		return Accrual{Order: number, Status: StatusRegistered}
+	case elapsed < 2*s.cfg.StatusDelay:
+		return Accrual{Order: number, Status: StatusProcessing}
+	case o.fixed != nil:
+		return *o.fixed
+	case len(o.goods) == 0:
+		// рассчитывать нечего: настоящая система отклоняет такой заказ
+		return Accrual{Order: number, Status: StatusInvalid}
+	}
+	var sum float64
+	matched := false
+	for _, good := range o.goods {
+		for _, rule := range s.rules {
+			if !strings.Contains(good.Description, rule.Match) {
+				continue
+			}
+			matched = true
+			if rule.RewardType == RewardPercent {
+				sum += good.Price * rule.Reward / 100
+			} else {
+				sum += rule.Reward
+			}
+			break
+		}
+	}
+	// как и настоящая система, заказ без подходящих товаров рассчитывается без поля accrual
+	if !matched {
+		return Accrual{Order: number, Status: StatusProcessed}
+	}
+	sum = math.Round(sum*100) / 100
+	return Accrual{Order: number, Status: StatusProcessed, Accrual: &sum}
+}
+
+// admit решает, отвечать ли на запрос заказа ошибкой: возвращает код 500 или 429 с паузой, либо 0
+func (s *Server) admit() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.throttled > 0 {
+		s.throttled--
+		return http.StatusTooManyRequests, s.throttleFor
+	}
+	if s.failNext > 0 {
+		s.failNext--
+		return http.StatusInternalServerError, 0
+	}
+	if s.cfg.RateLimit > 0 {
+		now := s.cfg.Clock()
+		if now.Sub(s.window) >= time.Minute {
+			s.window = now
+			s.requests = 0
+		}
+		s.requests++
+		if s.requests > s.cfg.RateLimit {
+			pause := s.cfg.RetryAfter
+			if pause == 0 {
+				pause = int(math.Ceil(s.window.Add(time.Minute).Sub(now).Seconds()))
+			}
+			return http.StatusTooManyRequests, pause
+		}
+	}
+	if s.cfg.ErrorRate > 0 && s.rnd.Float64() < s.cfg.ErrorRate {
+		return http.StatusInternalServerError, 0
+	}
+	return 0, 0
+}
+
+func (s *Server) withLatency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.Latency > 0 {
+			if err := sleep(r.Context(), s.cfg.Latency); err != nil {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) rateLimitMessage() string {
+	return "No more than " + strconv.Itoa(s.cfg.RateLimit) + " requests per minute allowed"
+}
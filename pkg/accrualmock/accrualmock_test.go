@@ -0,0 +1,231 @@
+package accrualmock
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clock - подменяемый источник времени; тесты вызывают обработчики синхронно, поэтому без блокировок
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+type response struct {
+	code       int
+	retryAfter string
+	accrual    Accrual
+}
+
+func get(t *testing.T, s *Server, number string) response {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/orders/"+number, nil))
+	ret := response{code: w.Code, retryAfter: w.Header().Get("Retry-After")}
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &ret.accrual); err != nil {
+			t.Fatalf("decode %s: %v", w.Body.String(), err)
+		}
+	}
+	return ret
+}
+
+func post(s *Server, path, body string) int {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return w.Code
+}
+
+func checkAccrual(t *testing.T, s *Server, number, status string, accrual *float64) {
+	t.Helper()
+	r := get(t, s, number)
+	if r.code != http.StatusOK || r.accrual.Status != status {
+		t.Fatalf("order %s: code %d, status %q; want 200 and %s", number, r.code, r.accrual.Status, status)
+	}
+	switch {
+	case accrual == nil && r.accrual.Accrual != nil:
+		t.Errorf("order %s: accrual %v, want none", number, *r.accrual.Accrual)
+	case accrual != nil && (r.accrual.Accrual == nil || *r.accrual.Accrual != *accrual):
+		t.Errorf("order %s: accrual %v, want %v", number, r.accrual.Accrual, *accrual)
+	}
+	if state, ok := s.Order(number); !ok || state.Status != status {
+		t.Errorf("Order(%s) = %+v, %v; want status %s", number, state, ok, status)
+	}
+}
+
+func TestStatusMachine(t *testing.T) {
+	c := newClock()
+	s := New(Config{StatusDelay: 10 * time.Second, Clock: c.Now})
+	if code := post(s, "/api/goods", `{"match": "Bork", "reward": 10, "reward_type": "%"}`); code != http.StatusOK {
+		t.Fatalf("register rule: %d", code)
+	}
+	if err := s.AddRule(Rule{Match: "Mouse", Reward: 5, RewardType: RewardPoints}); err != nil {
+		t.Fatalf("AddRule: %v", err)
+	}
+	body := `{"order": "1", "goods": [{"description": "Bork kettle", "price": 1000.55},
+	                                  {"description": "Mouse", "price": 50}, {"description": "Cable", "price": 10}]}`
+	if code := post(s, "/api/orders", body); code != http.StatusAccepted {
+		t.Fatalf("register order: %d", code)
+	}
+	if err := s.AddOrder("2", nil); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	if err := s.AddOrder("3", []Good{{Description: "Cable", Price: 10}}); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	s.SetOrder("4", StatusInvalid, 0)
+
+	for _, number := range []string{"1", "2", "3", "4"} {
+		checkAccrual(t, s, number, StatusRegistered, nil)
+	}
+	c.Advance(10 * time.Second)
+	for _, number := range []string{"1", "2", "3", "4"} {
+		checkAccrual(t, s, number, StatusProcessing, nil)
+	}
+	c.Advance(10 * time.Second)
+	// 10% от 1000.55 с округлением до копеек и 5 баллов за мышь; кабель не подходит ни под одно правило
+	sum := 105.06
+	checkAccrual(t, s, "1", StatusProcessed, &sum)
+	checkAccrual(t, s, "2", StatusInvalid, nil)
+	checkAccrual(t, s, "3", StatusProcessed, nil)
+	checkAccrual(t, s, "4", StatusInvalid, nil)
+
+	if r := get(t, s, "5"); r.code != http.StatusNoContent {
+		t.Errorf("unknown order: code %d, want 204", r.code)
+	}
+	if _, ok := s.Order("5"); ok {
+		t.Error("Order(unknown) reports a registered order")
+	}
+}
+
+func TestRegistrationErrors(t *testing.T) {
+	s := New(Config{})
+	tests := []struct {
+		path string
+		body string
+		want int
+	}{
+		{"/api/goods", `{"match": "Bork", "reward": 10, "reward_type": "%"}`, http.StatusOK},
+		{"/api/goods", `{"match": "Bork", "reward": 5, "reward_type": "pt"}`, http.StatusConflict},
+		{"/api/goods", `{"match": "Mouse", "reward": 5, "reward_type": "points"}`, http.StatusBadRequest},
+		{"/api/goods", `{"match": "Mouse", "reward": 0, "reward_type": "pt"}`, http.StatusBadRequest},
+		{"/api/goods", `{`, http.StatusBadRequest},
+		{"/api/orders", `{"order": "1", "goods": []}`, http.StatusAccepted},
+		{"/api/orders", `{"order": "1", "goods": []}`, http.StatusConflict},
+		{"/api/orders", `{"goods": []}`, http.StatusBadRequest},
+		{"/api/orders", `{`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code := post(s, tt.path, tt.body); code != tt.want {
+			t.Errorf("POST %s %s: code %d, want %d", tt.path, tt.body, code, tt.want)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	c := newClock()
+	s := New(Config{RateLimit: 2, Clock: c.Now})
+	s.SetOrder("1", StatusProcessed, 100)
+	for i := 0; i < 2; i++ {
+		if r := get(t, s, "1"); r.code != http.StatusOK {
+			t.Fatalf("request %d: code %d, want 200", i+1, r.code)
+		}
+	}
+	// Retry-After - время до конца минуты, которая началась с первого запроса
+	c.Advance(15 * time.Second)
+	if r := get(t, s, "1"); r.code != http.StatusTooManyRequests || r.retryAfter != "45" {
+		t.Fatalf("over the limit: code %d, Retry-After %q; want 429 and 45", r.code, r.retryAfter)
+	}
+	c.Advance(44 * time.Second)
+	if r := get(t, s, "1"); r.code != http.StatusTooManyRequests || r.retryAfter != "1" {
+		t.Fatalf("end of the window: code %d, Retry-After %q; want 429 and 1", r.code, r.retryAfter)
+	}
+	c.Advance(time.Second)
+	if r := get(t, s, "1"); r.code != http.StatusOK {
+		t.Fatalf("next window: code %d, want 200", r.code)
+	}
+
+	fixed := New(Config{RateLimit: 1, RetryAfter: 7, Clock: c.Now})
+	fixed.SetOrder("1", StatusProcessed, 100)
+	get(t, fixed, "1")
+	if r := get(t, fixed, "1"); r.code != http.StatusTooManyRequests || r.retryAfter != "7" {
+		t.Fatalf("configured Retry-After: code %d, Retry-After %q; want 429 and 7", r.code, r.retryAfter)
+	}
+}
+
+func TestErrorRate(t *testing.T) {
+	codes := func(seed int64) []int {
+		s := New(Config{ErrorRate: 0.5, Seed: seed, AutoAccrual: 1})
+		ret := make([]int, 100)
+		for i := range ret {
+			ret[i] = get(t, s, "1").code
+		}
+		return ret
+	}
+	first, second := codes(42), codes(42)
+	failed := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("request %d: code %d with the same seed, was %d", i+1, second[i], first[i])
+		}
+		switch first[i] {
+		case http.StatusInternalServerError:
+			failed++
+		case http.StatusOK:
+		default:
+			t.Fatalf("request %d: code %d, want 200 or 500", i+1, first[i])
+		}
+	}
+	if failed < 25 || failed > 75 {
+		t.Errorf("%d of 100 requests failed with error rate 0.5", failed)
+	}
+}
+
+func TestFailAndThrottleNext(t *testing.T) {
+	s := New(Config{})
+	s.SetOrder("1", StatusProcessed, 100)
+	s.FailNext(2)
+	s.ThrottleNext(1, 3)
+	// ограничение частоты проверяется раньше ошибок
+	want := []response{
+		{code: http.StatusTooManyRequests, retryAfter: "3"},
+		{code: http.StatusInternalServerError},
+		{code: http.StatusInternalServerError},
+		{code: http.StatusOK},
+	}
+	for i, w := range want {
+		if r := get(t, s, "1"); r.code != w.code || r.retryAfter != w.retryAfter {
+			t.Errorf("request %d: code %d, Retry-After %q; want %d and %q", i+1, r.code, r.retryAfter, w.code, w.retryAfter)
+		}
+	}
+}
+
+func TestAutoAccrual(t *testing.T) {
+	c := newClock()
+	s := New(Config{AutoAccrual: 100, StatusDelay: time.Second, Clock: c.Now})
+	checkAccrual(t, s, "1", StatusRegistered, nil)
+	c.Advance(2 * time.Second)
+	sum := 100.0
+	checkAccrual(t, s, "1", StatusProcessed, &sum)
+	// заранее зарегистрированный заказ считается по своим товарам
+	if err := s.AddOrder("2", nil); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	c.Advance(2 * time.Second)
+	checkAccrual(t, s, "2", StatusInvalid, nil)
+}
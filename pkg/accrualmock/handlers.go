@@ -0,0 +1,92 @@
+package accrualmock
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+var ErrBadRequest = errors.New("bad request")
+var ErrOrderExists = errors.New("order already registered")
+var ErrRuleExists = errors.New("reward rule already registered")
+
+// getOrder - GET /api/orders/{number}
+func (s *Server) getOrder(w http.ResponseWriter, r *http.Request) {
+	number := chi.URLParam(r, "number")
+	code, pause := s.admit()
+	switch code {
+	case http.StatusTooManyRequests:
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Retry-After", strconv.Itoa(pause))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(s.rateLimitMessage()))
+		return
+	case http.StatusInternalServerError:
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	o, ok := s.orders[number]
+	if !ok && s.cfg.AutoAccrual > 0 {
+		accrual := s.cfg.AutoAccrual
+		o = &order{registeredAt: s.cfg.Clock(), fixed: &Accrual{Order: number, Status: StatusProcessed, Accrual: &accrual}}
+		s.orders[number] = o
+		ok = true
+	}
+	var ret Accrual
+	if ok {
+		ret = s.accrual(number, o)
+	}
+	s.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	body, err := json.Marshal(ret)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// registerOrder - POST /api/orders {"order": "...", "goods": [{"description": "...", "price": 0}]}
+func (s *Server) registerOrder(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Order string `json:"order"`
+		Goods []Good `json:"goods"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeResult(w, s.AddOrder(req.Order, req.Goods), http.StatusAccepted)
+}
+
+// registerRule - POST /api/goods {"match": "...", "reward": 0, "reward_type": "%|pt"}
+func (s *Server) registerRule(w http.ResponseWriter, r *http.Request) {
+	rule := Rule{}
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeResult(w, s.AddRule(rule), http.StatusOK)
+}
+
+func writeResult(w http.ResponseWriter, err error, okStatus int) {
+	switch {
+	case err == nil:
+		w.WriteHeader(okStatus)
+	case errors.Is(err, ErrBadRequest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrOrderExists), errors.Is(err, ErrRuleExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gophermart"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Количество HTTP-запросов по маршруту и коду ответа.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Длительность обработки HTTP-запросов.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Длительность операций хранилища.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	RetryAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retry_attempts_total",
+		Help:      "Количество повторных попыток операций после retryable-ошибки.",
+	}, []string{"operation"})
+
+	AccrualRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accrual_requests_total",
+		Help:      "Запросы к системе расчёта начислений по результату (код ответа или error).",
+	}, []string{"outcome"})
+
+	AccrualCircuitState = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "accrual_circuit_state",
+		Help:      "Состояние автомата отключения системы начислений: 0 - закрыт, 1 - открыт, 2 - пробный запрос.",
+	})
+
+	ProcessingBatchSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "processing_batch_size",
+		Help:      "Количество заказов в пачке обработки.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	ProcessingLag = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "processing_lag_seconds",
+		Help:      "Возраст самого старого необработанного заказа в последней пачке.",
+	})
+
+	ProcessingOrderErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "processing_order_errors_total",
+		Help:      "Заказы, пропущенные в пачке обработки из-за ошибки запроса к системе начислений.",
+	})
+
+	Orders = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "orders",
+		Help:      "Количество заказов в каждом статусе.",
+	}, []string{"status"})
+)
+
+func ObserveQuery(operation string, start time.Time) {
+	DBQueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Handler отдаёт метрики без собственного сжатия - ответ сжимает middleware сервера
+func Handler() http.Handler {
+	return promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{DisableCompression: true})
+}
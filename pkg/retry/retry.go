@@ -2,7 +2,15 @@ package retry
 
 import (
 	"context"
+	"reflect"
+	"runtime"
+	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"loyalty-system/pkg/metrics"
+	"loyalty-system/pkg/tracing"
 )
 
 func DoWithoutReturn[T any](ctx context.Context, repeat int, retryFunc func(context.Context, T) error, p T, isRepeatableFunc func(err error) bool) error {
@@ -20,7 +28,7 @@ func DoWithoutReturn[T any](ctx context.Context, repeat int, retryFunc func(cont
 			break
 		}
 		if i < repeat-1 {
-			time.Sleep(time.Second * 3)
+			wait(ctx, operationName(retryFunc))
 		}
 	}
 	return err
@@ -42,8 +50,26 @@ func DoWithReturn[T, R any](ctx context.Context, repeat int, retryFunc func(cont
 			break
 		}
 		if i < repeat-1 {
-			time.Sleep(time.Second * 3)
+			wait(ctx, operationName(retryFunc))
 		}
 	}
 	return ret, err
 }
+
+func wait(ctx context.Context, operation string) {
+	metrics.RetryAttempts.WithLabelValues(operation).Inc()
+	_, span := tracing.Start(ctx, "retry.wait", trace.WithAttributes(attribute.String("operation", operation)))
+	defer span.End()
+	time.Sleep(time.Second * 3)
+}
+
+// operationName возвращает имя метода, переданного как значение: "pkg.(*T).GetOrder-fm" -> "GetOrder"
+func operationName(f any) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.TrimSuffix(name, "-fm")
+}
@@ -0,0 +1,210 @@
+package security
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrNoSigningKey = errors.New("no private key to sign tokens")
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type verificationKey struct {
+	kid    string
+	method jwt.SigningMethod
+	public interface{}
+}
+
+type signingKey struct {
+	verificationKey
+	private interface{}
+}
+
+// KeySet хранит ключ подписи токенов и ключи, которыми токены проверяются.
+// В режиме каталога ключей каждый PEM-файл - отдельный ключ, kid - имя файла без расширения.
+// Подписывает закрытый ключ с наибольшим kid, остальные ключи (в том числе только открытые) используются для проверки,
+// что позволяет ротировать ключи, не инвалидируя уже выданные токены
+type KeySet struct {
+	mu      sync.RWMutex
+	dir     string
+	signing *signingKey
+	keys    map[string]verificationKey
+}
+
+// NewHMACKeySet возвращает набор из одного общего секрета HS256
+func NewHMACKeySet(secret string) *KeySet {
+	key := &signingKey{
+		verificationKey: verificationKey{method: jwt.SigningMethodHS256, public: []byte(secret)},
+		private:         []byte(secret),
+	}
+	return &KeySet{signing: key, keys: map[string]verificationKey{"": key.verificationKey}}
+}
+
+// LoadKeySet читает ключи RS256/EdDSA из каталога dir
+func LoadKeySet(dir string) (*KeySet, error) {
+	ks := &KeySet{dir: dir}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// Reload перечитывает каталог ключей. Для набора HMAC ничего не делает
+func (ks *KeySet) Reload() error {
+	if ks.dir == "" {
+		return nil
+	}
+	files, err := filepath.Glob(filepath.Join(ks.dir, "*.pem"))
+	if err != nil {
+		return fmt.Errorf("list keys: %w", err)
+	}
+	sort.Strings(files)
+	keys := make(map[string]verificationKey, len(files))
+	var signing *signingKey
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("read key %q: %w", kid, err)
+		}
+		key, err := parsePEMKey(kid, data)
+		if err != nil {
+			return fmt.Errorf("parse key %q: %w", kid, err)
+		}
+		keys[kid] = key.verificationKey
+		if key.private != nil {
+			signing = key
+		}
+	}
+	if signing == nil {
+		return fmt.Errorf("%s: %w", ks.dir, ErrNoSigningKey)
+	}
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.signing = signing
+	ks.keys = keys
+	return nil
+}
+
+func (ks *KeySet) Sign(claims *Claims) (string, error) {
+	ks.mu.RLock()
+	key := ks.signing
+	ks.mu.RUnlock()
+	token := jwt.NewWithClaims(key.method, claims)
+	if key.kid != "" {
+		token.Header["kid"] = key.kid
+	}
+	return token.SignedString(key.private)
+}
+
+func (ks *KeySet) Parse(tokenString string, claims *Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, ks.keyFunc)
+}
+
+func (ks *KeySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	ks.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("kid %q: %w", kid, ErrUnknownKey)
+	}
+	// алгоритм определяется ключом, а не заголовком токена
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+	return key.public, nil
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS возвращает открытые ключи набора. Общий секрет HMAC не публикуется
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	ret := JWKS{Keys: make([]JWK, 0, len(ks.keys))}
+	for _, key := range ks.keys {
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		ret.Keys = append(ret.Keys, jwk)
+	}
+	sort.Slice(ret.Keys, func(i, j int) bool {
+		return ret.Keys[i].Kid < ret.Keys[j].Kid
+	})
+	return ret
+}
+
+func parsePEMKey(kid string, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block")
+	}
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	key := &signingKey{verificationKey: verificationKey{kid: kid}}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+	if pub, ok := key.public.(*rsa.PublicKey); ok && pub.N.BitLen() < 2048 {
+		return nil, errors.New("RSA key must be at least 2048 bits")
+	}
+	return key, nil
+}
@@ -0,0 +1,87 @@
+package security
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	OrderValidationLuhn  = "luhn"
+	OrderValidationRegex = "regex"
+	OrderValidationNone  = "none"
+)
+
+// maxOrderLength ограничивает длину номера заказа при любой стратегии проверки
+const maxOrderLength = 64
+
+// OrderValidator проверяет формат номера заказа
+type OrderValidator interface {
+	Valid(number string) bool
+}
+
+// NewOrderValidator возвращает проверку номеров выбранного вида; pattern используется только для regex
+// и должен совпадать с номером целиком
+func NewOrderValidator(kind string, pattern string) (OrderValidator, error) {
+	switch kind {
+	case OrderValidationLuhn:
+		return luhnValidator{}, nil
+	case OrderValidationRegex:
+		if pattern == "" {
+			return nil, fmt.Errorf("order validation %q: empty pattern", kind)
+		}
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("order validation %q: %w", kind, err)
+		}
+		return regexValidator{re: re}, nil
+	case OrderValidationNone:
+		return anyValidator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown order validation %q", kind)
+	}
+}
+
+type luhnValidator struct{}
+
+func (luhnValidator) Valid(number string) bool {
+	return ValidLuhnString(number)
+}
+
+type regexValidator struct {
+	re *regexp.Regexp
+}
+
+func (v regexValidator) Valid(number string) bool {
+	return number != "" && len(number) <= maxOrderLength && v.re.MatchString(number)
+}
+
+type anyValidator struct{}
+
+func (anyValidator) Valid(number string) bool {
+	return number != "" && len(number) <= maxOrderLength
+}
+
+// ValidLuhnString проверяет контрольную сумму Луна у номера из одних цифр произвольной длины
+func ValidLuhnString(number string) bool {
+	if number == "" || len(number) > maxOrderLength {
+		return false
+	}
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
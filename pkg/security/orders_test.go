@@ -0,0 +1,101 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidLuhnString(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		want   bool
+	}{
+		{"valid", "79927398713", true},
+		{"wrong check digit", "79927398710", false},
+		{"single zero", "0", true},
+		{"longer than 19 digits", "12345678901234567890121", true},
+		{"64 digits", strings.Repeat("9", 63) + "3", true},
+		{"over 64 digits", strings.Repeat("9", 64) + "4", false},
+		{"empty", "", false},
+		{"letter", "7992739871a", false},
+		{"space", "7992 7398713", false},
+		{"sign", "-79927398713", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidLuhnString(tt.number); got != tt.want {
+				t.Errorf("ValidLuhnString(%q) = %v, want %v", tt.number, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewOrderValidator(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		pattern string
+		valid   []string
+		invalid []string
+	}{
+		{
+			name:    "luhn",
+			kind:    OrderValidationLuhn,
+			valid:   []string{"79927398713", "12345678901234567890121"},
+			invalid: []string{"79927398710", "", "ORD-1"},
+		},
+		{
+			// шаблон совпадает с номером целиком, даже если в нём есть альтернатива
+			name:    "regex",
+			kind:    OrderValidationRegex,
+			pattern: `ORD-\d+|[A-Z]{3}`,
+			valid:   []string{"ORD-1", "ORD-12345", "ABC"},
+			invalid: []string{"xORD-1", "ORD-1x", "ORD-", "ABCD", "", "ORD-" + strings.Repeat("1", 61)},
+		},
+		{
+			name:    "none",
+			kind:    OrderValidationNone,
+			valid:   []string{"anything", strings.Repeat("x", 64)},
+			invalid: []string{"", strings.Repeat("x", 65)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, err := NewOrderValidator(tt.kind, tt.pattern)
+			if err != nil {
+				t.Fatalf("NewOrderValidator: %v", err)
+			}
+			for _, number := range tt.valid {
+				if !validator.Valid(number) {
+					t.Errorf("Valid(%q) = false, want true", number)
+				}
+			}
+			for _, number := range tt.invalid {
+				if validator.Valid(number) {
+					t.Errorf("Valid(%q) = true, want false", number)
+				}
+			}
+		})
+	}
+}
+
+func TestNewOrderValidatorErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		pattern string
+	}{
+		{"empty pattern", OrderValidationRegex, ""},
+		{"broken pattern", OrderValidationRegex, "("},
+		{"unknown kind", "mod11", ""},
+		{"empty kind", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewOrderValidator(tt.kind, tt.pattern); err == nil {
+				t.Errorf("NewOrderValidator(%q, %q): no error", tt.kind, tt.pattern)
+			}
+		})
+	}
+}
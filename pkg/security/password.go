@@ -0,0 +1,208 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HasherArgon2id = "argon2id"
+	HasherBcrypt   = "bcrypt"
+)
+
+// MaxPasswordLength - наибольшая длина пароля в байтах. bcrypt не принимает пароли длиннее, поэтому ограничение
+// действует при любом алгоритме: иначе после смены алгоритма такие пароли нельзя было бы перехэшировать
+const MaxPasswordLength = 72
+
+var ErrHashFormat = errors.New("unsupported password hash format")
+
+// PasswordHasher вычисляет и проверяет хэши паролей.
+// Соль и параметры алгоритма хранятся в самой строке хэша
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash сообщает, что хэш получен другим алгоритмом или с устаревшими параметрами
+	NeedsRehash(encoded string) bool
+}
+
+// NewPasswordHasher возвращает хэшер выбранного алгоритма, который также умеет проверять
+// хэши остальных поддерживаемых форматов, включая устаревший SHA-256 с общей солью legacySalt
+func NewPasswordHasher(kind string, legacySalt string) (PasswordHasher, error) {
+	argon := NewArgon2idHasher()
+	bc := NewBcryptHasher(bcrypt.DefaultCost)
+	var primary PasswordHasher
+	switch kind {
+	case HasherArgon2id:
+		primary = argon
+	case HasherBcrypt:
+		primary = bc
+	default:
+		return nil, fmt.Errorf("unknown password hasher %q", kind)
+	}
+	return &multiHasher{primary: primary, argon: argon, bcrypt: bc, legacy: &legacySHA256Hasher{salt: legacySalt}}, nil
+}
+
+type multiHasher struct {
+	primary PasswordHasher
+	argon   *Argon2idHasher
+	bcrypt  *BcryptHasher
+	legacy  *legacySHA256Hasher
+}
+
+func (h *multiHasher) Hash(password string) (string, error) {
+	return h.primary.Hash(password)
+}
+
+func (h *multiHasher) Verify(password, encoded string) (bool, error) {
+	return h.byFormat(encoded).Verify(password, encoded)
+}
+
+func (h *multiHasher) NeedsRehash(encoded string) bool {
+	return h.byFormat(encoded) != h.primary || h.primary.NeedsRehash(encoded)
+}
+
+func (h *multiHasher) byFormat(encoded string) PasswordHasher {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return h.argon
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return h.bcrypt
+	default:
+		return h.legacy
+	}
+}
+
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// NewArgon2idHasher возвращает хэшер с параметрами, рекомендованными OWASP
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{Memory: 19 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("generate salt: %w", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	actual := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(actual, key) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory != h.Memory || params.Iterations != h.Iterations || params.Parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength || uint32(len(key)) != h.KeyLength
+}
+
+func decodeArgon2id(encoded string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrHashFormat
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrHashFormat
+	}
+	params := &Argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return nil, nil, nil, ErrHashFormat
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrHashFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrHashFormat
+	}
+	return params, salt, key, nil
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	return &BcryptHasher{Cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt: %w", err)
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	// bcrypt сравнивает только первые 72 байта пароля, а хэша от более длинного пароля не бывает
+	if len(password) > MaxPasswordLength {
+		return false, nil
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return false, nil
+	default:
+		return false, fmt.Errorf("bcrypt: %w", err)
+	}
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// legacySHA256Hasher проверяет хэши, созданные до перехода на адаптивные алгоритмы: SHA-256 от пароля с общей солью
+type legacySHA256Hasher struct {
+	salt string
+}
+
+func (h *legacySHA256Hasher) Hash(password string) (string, error) {
+	sum := sha256.Sum256(append([]byte(password), []byte(h.salt)...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (h *legacySHA256Hasher) Verify(password, encoded string) (bool, error) {
+	expected, err := hex.DecodeString(encoded)
+	if err != nil || len(expected) != sha256.Size {
+		return false, ErrHashFormat
+	}
+	sum := sha256.Sum256(append([]byte(password), []byte(h.salt)...))
+	return subtle.ConstantTimeCompare(sum[:], expected) == 1, nil
+}
+
+func (h *legacySHA256Hasher) NeedsRehash(string) bool {
+	return true
+}
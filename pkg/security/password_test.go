@@ -0,0 +1,58 @@
+package security
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashers(t *testing.T) {
+	for _, kind := range []string{HasherArgon2id, HasherBcrypt} {
+		t.Run(kind, func(t *testing.T) {
+			hasher, err := NewPasswordHasher(kind, "salt")
+			if err != nil {
+				t.Fatalf("NewPasswordHasher: %v", err)
+			}
+			password := strings.Repeat("p", MaxPasswordLength)
+			hash, err := hasher.Hash(password)
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if ok, err := hasher.Verify(password, hash); err != nil || !ok {
+				t.Errorf("Verify(password) = %v, %v, want true", ok, err)
+			}
+			if ok, err := hasher.Verify(password[1:], hash); err != nil || ok {
+				t.Errorf("Verify(other) = %v, %v, want false", ok, err)
+			}
+			// слишком длинный пароль не совпадает, даже если его начало совпадает с паролем
+			if ok, err := hasher.Verify(password+"p", hash); err != nil || ok {
+				t.Errorf("Verify(too long) = %v, %v, want false", ok, err)
+			}
+		})
+	}
+}
+
+func TestLegacyRehash(t *testing.T) {
+	hasher, err := NewPasswordHasher(HasherArgon2id, "salt")
+	if err != nil {
+		t.Fatalf("NewPasswordHasher: %v", err)
+	}
+	legacy, _ := (&legacySHA256Hasher{salt: "salt"}).Hash("secret")
+	if ok, err := hasher.Verify("secret", legacy); err != nil || !ok {
+		t.Errorf("Verify(legacy) = %v, %v, want true", ok, err)
+	}
+	bc, err := NewBcryptHasher(bcrypt.MinCost).Hash("secret")
+	if err != nil {
+		t.Fatalf("bcrypt Hash: %v", err)
+	}
+	if ok, err := hasher.Verify("secret", bc); err != nil || !ok {
+		t.Errorf("Verify(bcrypt) = %v, %v, want true", ok, err)
+	}
+	// хэши чужого алгоритма и устаревшие переводятся на текущий при входе
+	for name, hash := range map[string]string{"legacy": legacy, "bcrypt": bc} {
+		if !hasher.NeedsRehash(hash) {
+			t.Errorf("NeedsRehash(%s) = false, want true", name)
+		}
+	}
+}
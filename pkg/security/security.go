@@ -1,77 +1,71 @@
 package security
 
 import (
-	"crypto/sha256"
-	"fmt"
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func CreateHash(password, salt string) string {
-	var s = append([]byte(password), []byte(salt)...)
-	return fmt.Sprintf("%x", sha256.Sum256(s))
-}
+const (
+	AccessToken  = "access"
+	RefreshToken = "refresh"
+)
 
-func CheckHash(password, hash, salt string) bool {
-	var s = append([]byte(password), []byte(salt)...)
-	return hash == fmt.Sprintf("%x", sha256.Sum256(s))
-}
+var ErrTokenType = errors.New("unexpected token type")
 
 type Claims struct {
 	jwt.RegisteredClaims
-	UserID int64
+	UserID    int64
+	TokenType string `json:"typ,omitempty"`
+	// Admin - доступ к /api/admin; сервер дополнительно сверяет роль с БД, поэтому снятие роли действует сразу
+	Admin bool `json:"adm,omitempty"`
 }
 
-func BuildJWTString(userID int64, tokenExp time.Duration, jwtKey string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+func BuildJWTString(userID int64, admin bool, tokenType string, tokenExp time.Duration, keys *KeySet) (string, *Claims, error) {
+	jti, err := newTokenID()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now()
+	claims := &Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExp)),
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(tokenExp)),
 		},
-		UserID: userID,
-	})
-
-	tokenString, err := token.SignedString([]byte(jwtKey))
+		UserID:    userID,
+		TokenType: tokenType,
+		Admin:     admin,
+	}
+	tokenString, err := keys.Sign(claims)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
-	return tokenString, nil
+	return tokenString, claims, nil
 }
 
-func GetUserID(tokenString string, jwtKey string) (int64, error) {
+// ParseJWT проверяет подпись, срок действия и тип токена.
+// Токены, выпущенные до появления типа, считаются токенами доступа
+func ParseJWT(tokenString string, tokenType string, keys *KeySet) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-			}
-			return []byte(jwtKey), nil
-		})
+	token, err := keys.Parse(tokenString, claims)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
 	if !token.Valid {
-		return -1, err
+		return nil, jwt.ErrTokenInvalidClaims
 	}
-	return claims.UserID, nil
-}
-
-func ValidLuhn(number int64) bool {
-	return (number%10+checksum(number/10))%10 == 0
+	actualType := claims.TokenType
+	if actualType == "" {
+		actualType = AccessToken
+	}
+	if actualType != tokenType {
+		return nil, ErrTokenType
+	}
+	return claims, nil
 }
 
-func checksum(number int64) int64 {
-	var luhn int64
-	for i := 0; number > 0; i++ {
-		cur := number % 10
-		if i%2 == 0 { // even
-			cur = cur * 2
-			if cur > 9 {
-				cur = cur%10 + cur/10
-			}
-		}
-		luhn += cur
-		number /= 10
-	}
-	return luhn % 10
+func newTokenID() (string, error) {
+	return NewSecret(16)
 }
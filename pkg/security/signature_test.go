@@ -0,0 +1,42 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSignPayload(t *testing.T) {
+	body := []byte(`{"event":"order.processed"}`)
+	// значение посчитано независимо: HMAC-SHA256("secret", "1700000000.<body>")
+	const want = "sha256=72bc88175ee04ab1dc7920d68159ea12673d969c95646f773ea186080944b90b"
+	if got := SignPayload("secret", 1700000000, body); got != want {
+		t.Fatalf("SignPayload = %s, want %s", got, want)
+	}
+	// подпись зависит от секрета, метки времени и тела
+	for name, got := range map[string]string{
+		"secret":    SignPayload("other", 1700000000, body),
+		"timestamp": SignPayload("secret", 1700000001, body),
+		"body":      SignPayload("secret", 1700000000, []byte(`{"event":"order.invalid"}`)),
+	} {
+		if got == want {
+			t.Errorf("signature does not depend on %s", name)
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	a, err := NewSecret(32)
+	if err != nil {
+		t.Fatalf("NewSecret: %v", err)
+	}
+	b, err := NewSecret(32)
+	if err != nil {
+		t.Fatalf("NewSecret: %v", err)
+	}
+	if len(a) != 64 || strings.Trim(a, "0123456789abcdef") != "" {
+		t.Errorf("NewSecret(32) = %q, want 64 hex digits", a)
+	}
+	if a == b {
+		t.Error("NewSecret returned the same secret twice")
+	}
+}